@@ -0,0 +1,152 @@
+// Package archive bundles many compressed images into a
+// single file with a table of contents, so that any one
+// image can be read without reading the others.
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+)
+
+const fileMagic = "ICAR"
+
+// MaxNameLength is the maximum length of the name of an
+// entry or its codec.
+const MaxNameLength = math.MaxUint16
+
+const (
+	headerSize = 4
+	footerSize = 8 + 4 + 4
+
+	// minEntrySize is the size of a table of contents
+	// entry with empty strings.
+	minEntrySize = 2 + 2 + 4 + 4 + 8 + 4 + 4
+)
+
+var encodingEndian = binary.LittleEndian
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// An Entry describes one compressed image in an archive.
+type Entry struct {
+	Name string
+
+	// Codec names the compressor that the data must be
+	// decoded with, such as "pcaprune-qt".
+	Codec string
+
+	Width  int
+	Height int
+
+	// Offset and Length locate the compressed data in the
+	// archive.
+	Offset int64
+	Length int64
+
+	// Checksum is the CRC-32C (Castagnoli) checksum of the
+	// compressed data.
+	Checksum uint32
+}
+
+// Bounds returns the bounds of the decoded image.
+func (e *Entry) Bounds() image.Rectangle {
+	return image.Rect(0, 0, e.Width, e.Height)
+}
+
+// A Writer writes an archive sequentially.
+//
+// The compressed data of each entry is written as soon as
+// it is added, and the table of contents is written by
+// Close.
+type Writer struct {
+	w       io.Writer
+	offset  int64
+	entries []*Entry
+	names   map[string]bool
+	err     error
+}
+
+// NewWriter creates a Writer and writes the archive
+// header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, fileMagic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, offset: headerSize, names: map[string]bool{}}, nil
+}
+
+// Add writes the compressed data of an image.
+//
+// Names must be unique within an archive.
+func (w *Writer) Add(name, codec string, bounds image.Rectangle, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if name == "" {
+		return errors.New("entry name must not be empty")
+	} else if len(name) > MaxNameLength || len(codec) > MaxNameLength {
+		return errors.New("entry name is too long")
+	} else if w.names[name] {
+		return errors.New("duplicate entry name: " + name)
+	} else if uint64(bounds.Dx()) > math.MaxUint32 || uint64(bounds.Dy()) > math.MaxUint32 ||
+		uint64(len(data)) > math.MaxUint32 {
+		return errors.New("entry is too large")
+	}
+	if _, err := w.w.Write(data); err != nil {
+		w.err = err
+		return err
+	}
+	w.entries = append(w.entries, &Entry{
+		Name:     name,
+		Codec:    codec,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		Offset:   w.offset,
+		Length:   int64(len(data)),
+		Checksum: crc32.Checksum(data, crcTable),
+	})
+	w.names[name] = true
+	w.offset += int64(len(data))
+	return nil
+}
+
+// Close writes the table of contents and the footer of
+// the archive.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	tocOffset := w.offset
+	for _, e := range w.entries {
+		if err := writeEntry(w.w, e); err != nil {
+			return err
+		}
+	}
+	footer := make([]byte, footerSize)
+	encodingEndian.PutUint64(footer, uint64(tocOffset))
+	encodingEndian.PutUint32(footer[8:], uint32(len(w.entries)))
+	copy(footer[12:], fileMagic)
+	_, err := w.w.Write(footer)
+	w.err = errors.New("archive is closed")
+	return err
+}
+
+func writeEntry(w io.Writer, e *Entry) error {
+	buf := make([]byte, 0, minEntrySize+len(e.Name)+len(e.Codec))
+	buf = encodingEndian.AppendUint16(buf, uint16(len(e.Name)))
+	buf = append(buf, e.Name...)
+	buf = encodingEndian.AppendUint16(buf, uint16(len(e.Codec)))
+	buf = append(buf, e.Codec...)
+	buf = encodingEndian.AppendUint32(buf, uint32(e.Width))
+	buf = encodingEndian.AppendUint32(buf, uint32(e.Height))
+	buf = encodingEndian.AppendUint64(buf, uint64(e.Offset))
+	buf = encodingEndian.AppendUint32(buf, uint32(e.Length))
+	buf = encodingEndian.AppendUint32(buf, e.Checksum)
+	_, err := w.Write(buf)
+	return err
+}
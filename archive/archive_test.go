@@ -0,0 +1,147 @@
+package archive
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := testArchive(t)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		Name string
+		Data string
+	}{
+		{"a.png", "first image"},
+		{"b.jpg", ""},
+		{"dir/c.png", "third image"},
+	}
+	entries := r.Entries()
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries but got %d", len(expected), len(entries))
+	}
+	for i, e := range entries {
+		if e.Name != expected[i].Name {
+			t.Errorf("entry %d: expected name %s but got %s", i, expected[i].Name, e.Name)
+		}
+		if e.Codec != "smallbasis" {
+			t.Errorf("entry %d: unexpected codec %s", i, e.Codec)
+		}
+		if e.Bounds() != image.Rect(0, 0, 3, 2) {
+			t.Errorf("entry %d: unexpected bounds %v", i, e.Bounds())
+		}
+		if r.Lookup(e.Name) != e {
+			t.Errorf("entry %d: lookup failed", i)
+		}
+		d, err := r.ReadData(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d) != expected[i].Data {
+			t.Errorf("entry %d: expected data %q but got %q", i, expected[i].Data, d)
+		}
+	}
+	if r.Lookup("c.png") != nil {
+		t.Error("unexpected entry for missing name")
+	}
+	if damaged := r.Verify(); len(damaged) != 0 {
+		t.Errorf("unexpected damaged entries: %v", damaged)
+	}
+}
+
+func TestWriterNames(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := image.Rect(0, 0, 1, 1)
+	if err := w.Add("a.png", "vq", bounds, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("a.png", "vq", bounds, []byte("y")); err == nil {
+		t.Error("expected error for duplicate name")
+	}
+	if err := w.Add("", "vq", bounds, []byte("y")); err == nil {
+		t.Error("expected error for empty name")
+	}
+	if err := w.Add("b.png", "vq", bounds, []byte("y")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReaderDuplicateNames(t *testing.T) {
+	data := testArchive(t)
+	tocOffset := encodingEndian.Uint64(data[len(data)-footerSize:])
+	toc := data[tocOffset:]
+	copy(toc[bytes.Index(toc, []byte("b.jpg")):], "a.png")
+	if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("expected error for duplicate names")
+	}
+}
+
+func TestReaderOutOfBounds(t *testing.T) {
+	original := testArchive(t)
+	tocOffset := encodingEndian.Uint64(original[len(original)-footerSize:])
+
+	// The fixed fields of the first entry ("a.png") come
+	// after its name, its codec, and its dimensions.
+	fieldsStart := int(tocOffset) + 2 + len("a.png") + 2 + len("smallbasis") + 8
+	offsetField := fieldsStart
+	lengthField := fieldsStart + 8
+
+	for name, modify := range map[string]func(data []byte){
+		"offset before header": func(data []byte) {
+			encodingEndian.PutUint64(data[offsetField:], 0)
+		},
+		"offset after data": func(data []byte) {
+			encodingEndian.PutUint64(data[offsetField:], tocOffset+1)
+		},
+		"huge offset": func(data []byte) {
+			encodingEndian.PutUint64(data[offsetField:], 1<<63)
+		},
+		"length past data": func(data []byte) {
+			encodingEndian.PutUint32(data[lengthField:], uint32(tocOffset))
+		},
+		"table past footer": func(data []byte) {
+			encodingEndian.PutUint64(data[len(data)-footerSize:], uint64(len(data)))
+		},
+		"too many entries": func(data []byte) {
+			encodingEndian.PutUint32(data[len(data)-footerSize+8:], 1000)
+		},
+	} {
+		data := append([]byte{}, original...)
+		modify(data)
+		if _, err := NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	data := testArchive(t)
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	damagedEntry := r.Lookup("dir/c.png")
+	data[damagedEntry.Offset+2] ^= 1
+
+	if _, err := r.ReadData(damagedEntry); err == nil {
+		t.Error("expected checksum error")
+	}
+	for _, e := range r.Entries() {
+		if e != damagedEntry {
+			if _, err := r.ReadData(e); err != nil {
+				t.Errorf("entry %s: %s", e.Name, err)
+			}
+		}
+	}
+	damaged := r.Verify()
+	if len(damaged) != 1 || damaged[0] != damagedEntry {
+		t.Errorf("expected only %s to be damaged but got %v", damagedEntry.Name, damaged)
+	}
+}
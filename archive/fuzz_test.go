@@ -0,0 +1,58 @@
+package archive
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// testArchive creates an archive with a few entries.
+func testArchive(t testing.TB) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []struct {
+		Name string
+		Data string
+	}{
+		{"a.png", "first image"},
+		{"b.jpg", ""},
+		{"dir/c.png", "third image"},
+	}
+	for _, e := range entries {
+		if err := w.Add(e.Name, "smallbasis", image.Rect(0, 0, 3, 2), []byte(e.Data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzReader(f *testing.F) {
+	f.Add(testArchive(f))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		damaged := map[*Entry]bool{}
+		for _, e := range r.Verify() {
+			damaged[e] = true
+		}
+		for _, e := range r.Entries() {
+			if r.Lookup(e.Name) != e {
+				t.Fatalf("entry %s cannot be looked up", e.Name)
+			}
+			d, err := r.ReadData(e)
+			if (err != nil) != damaged[e] {
+				t.Fatalf("entry %s: Verify disagrees with ReadData (%v)", e.Name, err)
+			} else if err == nil && int64(len(d)) != e.Length {
+				t.Fatalf("entry %s: expected %d bytes but got %d", e.Name, e.Length, len(d))
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// A Reader reads entries from an archive.
+//
+// Opening an archive only reads its table of contents,
+// and each entry is read on demand.
+type Reader struct {
+	r       io.ReaderAt
+	entries []*Entry
+	byName  map[string]*Entry
+}
+
+// NewReader reads the table of contents of an archive
+// with the given size.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < headerSize+footerSize {
+		return nil, errors.New("not an image archive")
+	}
+	magic := make([]byte, headerSize)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, errors.New("failed to read header: " + err.Error())
+	}
+	footer := make([]byte, footerSize)
+	if _, err := r.ReadAt(footer, size-footerSize); err != nil {
+		return nil, errors.New("failed to read footer: " + err.Error())
+	}
+	if string(magic) != fileMagic || string(footer[12:]) != fileMagic {
+		return nil, errors.New("not an image archive")
+	}
+
+	tocOffset := encodingEndian.Uint64(footer)
+	count := uint64(encodingEndian.Uint32(footer[8:]))
+	tocEnd := uint64(size - footerSize)
+	if tocOffset < headerSize || tocOffset > tocEnd || count*minEntrySize > tocEnd-tocOffset {
+		return nil, errors.New("invalid table of contents")
+	}
+	toc := make([]byte, tocEnd-tocOffset)
+	if _, err := r.ReadAt(toc, int64(tocOffset)); err != nil {
+		return nil, errors.New("failed to read table of contents: " + err.Error())
+	}
+
+	res := &Reader{r: r, byName: map[string]*Entry{}}
+	buf := bytes.NewBuffer(toc)
+	for i := uint64(0); i < count; i++ {
+		e, err := readEntry(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entry %d: %s", i, err)
+		}
+		if e.Offset < headerSize || e.Offset > int64(tocOffset) ||
+			e.Length > int64(tocOffset)-e.Offset {
+			return nil, fmt.Errorf("entry %d is out of bounds", i)
+		}
+		if res.byName[e.Name] != nil {
+			return nil, errors.New("duplicate entry name: " + e.Name)
+		}
+		res.entries = append(res.entries, e)
+		res.byName[e.Name] = e
+	}
+	if buf.Len() != 0 {
+		return nil, errors.New("unexpected data after table of contents")
+	}
+	return res, nil
+}
+
+// Entries returns the entries of the archive, in the
+// order they were added.
+func (r *Reader) Entries() []*Entry {
+	return append([]*Entry{}, r.entries...)
+}
+
+// Lookup finds an entry by name, returning nil if there
+// is no such entry.
+func (r *Reader) Lookup(name string) *Entry {
+	return r.byName[name]
+}
+
+// ReadData reads the compressed data of an entry and
+// verifies its checksum.
+func (r *Reader) ReadData(e *Entry) ([]byte, error) {
+	data := make([]byte, e.Length)
+	if _, err := r.r.ReadAt(data, e.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read entry %s: %s", e.Name, err)
+	}
+	if crc32.Checksum(data, crcTable) != e.Checksum {
+		return nil, fmt.Errorf("checksum mismatch for entry %s", e.Name)
+	}
+	return data, nil
+}
+
+// Verify checks the checksum of every entry, returning
+// the entries that are damaged.
+func (r *Reader) Verify() []*Entry {
+	var res []*Entry
+	for _, e := range r.entries {
+		if _, err := r.ReadData(e); err != nil {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func readEntry(buf *bytes.Buffer) (*Entry, error) {
+	name, err := readString(buf)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := readString(buf)
+	if err != nil {
+		return nil, err
+	}
+	fixed := buf.Next(4 + 4 + 8 + 4 + 4)
+	if len(fixed) < 24 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	offset := encodingEndian.Uint64(fixed[8:])
+	if offset > 1<<62 {
+		return nil, errors.New("invalid offset")
+	}
+	return &Entry{
+		Name:     name,
+		Codec:    codec,
+		Width:    int(encodingEndian.Uint32(fixed)),
+		Height:   int(encodingEndian.Uint32(fixed[4:])),
+		Offset:   int64(offset),
+		Length:   int64(encodingEndian.Uint32(fixed[16:])),
+		Checksum: encodingEndian.Uint32(fixed[20:]),
+	}, nil
+}
+
+func readString(buf *bytes.Buffer) (string, error) {
+	lenData := buf.Next(2)
+	if len(lenData) < 2 {
+		return "", io.ErrUnexpectedEOF
+	}
+	data := buf.Next(int(encodingEndian.Uint16(lenData)))
+	if len(data) < int(encodingEndian.Uint16(lenData)) {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(data), nil
+}
@@ -0,0 +1,178 @@
+// Package batch compresses sets of related images, such
+// as product photos or video frames, with a single basis
+// that is shared by every image.
+package batch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/unixpickle/imagecompress/limits"
+)
+
+const fileMagic = "ICBT"
+
+var encodingEndian = binary.LittleEndian
+
+// A SharedCodec compresses images with a basis that is
+// stored separately from the compressed images.
+type SharedCodec interface {
+	TrainSharedBasis(images []image.Image) []byte
+	CompressShared(basis []byte, i image.Image) ([]byte, error)
+	DecompressShared(basis, d []byte) (image.Image, error)
+}
+
+// A Compressor compresses batches of images.
+//
+// The compressed data contains the shared basis, followed
+// by a table with the length of each compressed image,
+// followed by the images themselves, which consist of
+// nothing but their dimensions and coefficients.
+// The shared basis includes the quantization table, if
+// the Codec has one, so that it is not repeated for
+// every image.
+type Compressor struct {
+	Codec SharedCodec
+
+	// DecodeOptions limits the total size of decoded
+	// batches.
+	// Images themselves are decoded by the Codec, which
+	// should enforce its own limits.
+	DecodeOptions limits.DecodeOptions
+}
+
+// NewCompressor creates a Compressor that uses a codec.
+func NewCompressor(codec SharedCodec) *Compressor {
+	return &Compressor{Codec: codec}
+}
+
+// Compress trains a basis on the union of the blocks of
+// the images and compresses every image with it.
+func (c *Compressor) Compress(images []image.Image) ([]byte, error) {
+	basis := c.Codec.TrainSharedBasis(images)
+	entries := make([][]byte, len(images))
+	for i, img := range images {
+		var err error
+		entries[i], err = c.Codec.CompressShared(basis, img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress image %d: %s", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	binary.Write(&buf, encodingEndian, uint32(len(basis)))
+	buf.Write(basis)
+	binary.Write(&buf, encodingEndian, uint32(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&buf, encodingEndian, uint32(len(entry)))
+	}
+	for _, entry := range entries {
+		buf.Write(entry)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decodes every image in a batch.
+func (c *Compressor) Decompress(d []byte) ([]image.Image, error) {
+	b, err := readBatch(d)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]image.Image, len(b.Entries))
+	var totalPixels uint64
+	for i := range res {
+		res[i], err = c.decodeEntry(b, i)
+		if err != nil {
+			return nil, err
+		}
+		size := res[i].Bounds().Size()
+		totalPixels = limits.Sum(totalPixels, limits.Product(uint64(size.X), uint64(size.Y)))
+		if err := c.DecodeOptions.CheckMemory(limits.Product(totalPixels, 4)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// DecompressImage decodes a single image from a batch,
+// without decoding any of the other images.
+func (c *Compressor) DecompressImage(d []byte, idx int) (image.Image, error) {
+	b, err := readBatch(d)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= len(b.Entries) {
+		return nil, errors.New("image index out of range")
+	}
+	return c.decodeEntry(b, idx)
+}
+
+// Len returns the number of images in a batch.
+func Len(d []byte) (int, error) {
+	b, err := readBatch(d)
+	if err != nil {
+		return 0, err
+	}
+	return len(b.Entries), nil
+}
+
+func (c *Compressor) decodeEntry(b *batch, idx int) (image.Image, error) {
+	img, err := c.Codec.DecompressShared(b.Basis, b.Entries[idx])
+	if _, ok := err.(*limits.LimitError); ok {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to decode image %d: %s", idx, err)
+	}
+	return img, nil
+}
+
+// batch is the raw contents of a compressed batch.
+type batch struct {
+	Basis   []byte
+	Entries [][]byte
+}
+
+func readBatch(d []byte) (*batch, error) {
+	r := bytes.NewReader(d)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return nil, errors.New("not an image batch")
+	}
+
+	var basisLength uint32
+	if err := binary.Read(r, encodingEndian, &basisLength); err != nil {
+		return nil, errors.New("failed to read basis length: " + err.Error())
+	}
+	if int64(basisLength) > int64(r.Len()) {
+		return nil, errors.New("failed to read basis: unexpected EOF")
+	}
+	offset := len(d) - r.Len()
+	res := &batch{Basis: d[offset : offset+int(basisLength)]}
+	r.Seek(int64(basisLength), io.SeekCurrent)
+
+	var count uint32
+	if err := binary.Read(r, encodingEndian, &count); err != nil {
+		return nil, errors.New("failed to read image count: " + err.Error())
+	}
+	if int64(count)*4 > int64(r.Len()) {
+		return nil, errors.New("failed to read image table: unexpected EOF")
+	}
+	lengths := make([]uint32, count)
+	binary.Read(r, encodingEndian, lengths)
+
+	offset = len(d) - r.Len()
+	res.Entries = make([][]byte, count)
+	for i, length := range lengths {
+		if int64(length) > int64(len(d)-offset) {
+			return nil, fmt.Errorf("failed to read image %d: unexpected EOF", i)
+		}
+		res.Entries[i] = d[offset : offset+int(length)]
+		offset += int(length)
+	}
+	return res, nil
+}
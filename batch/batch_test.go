@@ -0,0 +1,136 @@
+package batch
+
+import (
+	"bytes"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/pcaprune"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+// testTable is not registered, so it is written in full
+// rather than as an ID.
+var testTable = quantize.Linear(16, 1.0/128, 1.0/16, 0)
+
+func testCodecs(quantized bool) map[string]SharedCodec {
+	sb := smallbasis.NewCompressorBlockSize(0.5, 4)
+	pca := pcaprune.NewCompressorBlockSize(0.5, 4)
+	if quantized {
+		sb.Quantizer = testTable
+		pca.Quantizer = testTable
+	}
+	return map[string]SharedCodec{"smallbasis": sb, "pcaprune": pca}
+}
+
+func testImages() []image.Image {
+	return []image.Image{testutil.Image(13, 9), testutil.Image(8, 8), testutil.Image(5, 11)}
+}
+
+func TestRoundTrip(t *testing.T) {
+	images := testImages()
+	for _, quantized := range []bool{false, true} {
+		for name, codec := range testCodecs(quantized) {
+			c := NewCompressor(codec)
+			data, err := c.Compress(images)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if n, err := Len(data); err != nil {
+				t.Fatal(name, err)
+			} else if n != len(images) {
+				t.Errorf("%s: expected %d images but got %d", name, len(images), n)
+			}
+			decoded, err := c.Decompress(data)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if len(decoded) != len(images) {
+				t.Fatalf("%s: expected %d images but got %d", name, len(images), len(decoded))
+			}
+			for i, img := range decoded {
+				if img.Bounds() != images[i].Bounds() {
+					t.Errorf("%s: image %d: expected bounds %v but got %v", name, i,
+						images[i].Bounds(), img.Bounds())
+					continue
+				}
+				if e := testutil.MeanError(img, images[i], img.Bounds()); e > 8 {
+					t.Errorf("%s: image %d: mean error %f", name, i, e)
+				}
+			}
+		}
+	}
+}
+
+func TestDecompressImage(t *testing.T) {
+	images := testImages()
+	for name, codec := range testCodecs(true) {
+		c := NewCompressor(codec)
+		data, err := c.Compress(images)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		decoded, err := c.Decompress(data)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		for i, expected := range decoded {
+			actual, err := c.DecompressImage(data, i)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s: image %d differs from Decompress", name, i)
+			}
+		}
+		for _, idx := range []int{-1, len(images)} {
+			if _, err := c.DecompressImage(data, idx); err == nil {
+				t.Errorf("%s: expected error for index %d", name, idx)
+			}
+		}
+	}
+}
+
+func TestSharedTable(t *testing.T) {
+	var table bytes.Buffer
+	quantize.WriteTable(&table, testTable)
+
+	images := testImages()
+	unquantized := testCodecs(false)
+	for name, codec := range testCodecs(true) {
+		basis := codec.TrainSharedBasis(images)
+		if !bytes.Contains(basis, table.Bytes()) {
+			t.Errorf("%s: basis does not contain the table", name)
+		}
+		for i, img := range images {
+			entry, err := codec.CompressShared(basis, img)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if bytes.Contains(entry, table.Bytes()) {
+				t.Errorf("%s: image %d repeats the table", name, i)
+			}
+		}
+
+		// The table is read from the batch, not from the
+		// decoding codec.
+		data, err := NewCompressor(codec).Compress(images)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		expected, err := NewCompressor(codec).Decompress(data)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		actual, err := NewCompressor(unquantized[name]).Decompress(data)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: decoding depends on the codec's Quantizer", name)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package batch
+
+import (
+	"image"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/pcaprune"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func fuzzCompressors() []*Compressor {
+	sb := smallbasis.NewCompressorBlockSize(0.5, 4)
+	sb.DecodeOptions = testutil.FuzzOptions
+	pca := pcaprune.NewCompressorBlockSize(0.5, 4)
+	pca.DecodeOptions = testutil.FuzzOptions
+	var res []*Compressor
+	for _, codec := range []SharedCodec{sb, pca} {
+		c := NewCompressor(codec)
+		c.DecodeOptions = testutil.FuzzOptions
+		res = append(res, c)
+	}
+	return res
+}
+
+func FuzzDecompress(f *testing.F) {
+	compressors := fuzzCompressors()
+	images := []image.Image{testutil.Image(11, 9), testutil.Image(5, 7), testutil.Image(1, 1)}
+	for i, c := range compressors {
+		data, err := c.Compress(images)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), data)
+	}
+	f.Fuzz(func(t *testing.T, which uint8, data []byte) {
+		c := compressors[int(which)%len(compressors)]
+		n, err := Len(data)
+		if err != nil {
+			return
+		}
+		if decoded, err := c.Decompress(data); err == nil {
+			if len(decoded) != n {
+				t.Fatalf("expected %d images but got %d", n, len(decoded))
+			}
+			for _, img := range decoded {
+				testutil.CheckFuzzBounds(t, img.Bounds())
+			}
+		}
+		if n > 0 {
+			if img, err := c.DecompressImage(data, n-1); err == nil {
+				testutil.CheckFuzzBounds(t, img.Bounds())
+			}
+		}
+	})
+}
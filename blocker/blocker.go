@@ -2,7 +2,6 @@ package blocker
 
 import (
 	"image"
-	"image/color"
 	"math"
 
 	"github.com/unixpickle/num-analysis/linalg"
@@ -18,33 +17,29 @@ import (
 // If a square block extends past the bounds of the
 // image, the overflowing pixel values will be 0's.
 func Blocks(i image.Image, blockSize int) []linalg.Vector {
-	numRows, numCols := blockCounts(i.Bounds(), blockSize)
+	bounds := i.Bounds()
+	numRows, numCols := blockCounts(bounds, blockSize)
+	readPixel := newPixelReader(i)
 
 	res := make([]linalg.Vector, 0, 3*numRows*numCols)
 	for row := 0; row < numRows; row++ {
 		for col := 0; col < numCols; col++ {
-			startX := i.Bounds().Min.X + col*blockSize
-			startY := i.Bounds().Min.Y + row*blockSize
+			startX := bounds.Min.X + col*blockSize
+			startY := bounds.Min.Y + row*blockSize
 			blocks := make([]linalg.Vector, 3)
 			for i := range blocks {
 				blocks[i] = make(linalg.Vector, blockSize*blockSize)
 			}
 			for y := 0; y < blockSize; y++ {
-				if y+startY >= i.Bounds().Max.Y {
+				if y+startY >= bounds.Max.Y {
 					continue
 				}
 				for x := 0; x < blockSize; x++ {
-					if x+startX >= i.Bounds().Max.X {
+					if x+startX >= bounds.Max.X {
 						continue
 					}
-					px := i.At(x+startX, y+startY)
-					r, g, b, _ := px.RGBA()
-					idx := y * blockSize
-					if y%2 == 0 {
-						idx += x
-					} else {
-						idx += blockSize - (x + 1)
-					}
+					r, g, b := readPixel(x+startX, y+startY)
+					idx := PixelIndex(x, y, blockSize)
 					blocks[0][idx] = float64(r) / 0xffff
 					blocks[1][idx] = float64(g) / 0xffff
 					blocks[2][idx] = float64(b) / 0xffff
@@ -59,8 +54,17 @@ func Blocks(i image.Image, blockSize int) []linalg.Vector {
 
 // Image performs the inverse of Blocks.
 func Image(w, h int, blocks []linalg.Vector, blockSize int) image.Image {
-	res := image.NewRGBA(image.Rect(0, 0, w, h))
-	rows, cols := blockCounts(res.Bounds(), blockSize)
+	return ImageRect(image.Rect(0, 0, w, h), blocks, blockSize)
+}
+
+// ImageRect is like Image, but the resulting image has
+// the given bounds.
+// This makes it possible to decode a strip or tile of a
+// larger image in place.
+func ImageRect(r image.Rectangle, blocks []linalg.Vector, blockSize int) *image.RGBA {
+	res := image.NewRGBA(r)
+	w, h := r.Dx(), r.Dy()
+	rows, cols := blockCounts(r, blockSize)
 
 	blockIdx := 0
 	for row := 0; row < rows; row++ {
@@ -75,22 +79,17 @@ func Image(w, h int, blocks []linalg.Vector, blockSize int) image.Image {
 					if x+col*blockSize >= w {
 						continue
 					}
-					pxIdx := y * blockSize
-					if y%2 == 0 {
-						pxIdx += x
-					} else {
-						pxIdx += blockSize - (x + 1)
-					}
+					pxIdx := PixelIndex(x, y, blockSize)
 					rVal := math.Min(math.Max(colorBlocks[0][pxIdx], 0), 1)
 					gVal := math.Min(math.Max(colorBlocks[1][pxIdx], 0), 1)
 					bVal := math.Min(math.Max(colorBlocks[2][pxIdx], 0), 1)
-					px := color.RGBA{
-						R: uint8(rVal * 0xff),
-						G: uint8(gVal * 0xff),
-						B: uint8(bVal * 0xff),
-						A: 0xff,
-					}
-					res.Set(x+col*blockSize, y+row*blockSize, px)
+					// Writing to Pix directly is much faster than
+					// calling res.Set with a color.Color.
+					p := res.Pix[res.PixOffset(r.Min.X+x+col*blockSize, r.Min.Y+y+row*blockSize):]
+					p[0] = uint8(rVal * 0xff)
+					p[1] = uint8(gVal * 0xff)
+					p[2] = uint8(bVal * 0xff)
+					p[3] = 0xff
 				}
 			}
 		}
@@ -99,6 +98,22 @@ func Image(w, h int, blocks []linalg.Vector, blockSize int) image.Image {
 	return res
 }
 
+// PixelIndex returns the index in a block vector of the
+// pixel at (x, y) relative to the corner of the block.
+//
+// Pixels are stored row by row, but every other row is
+// reversed so that adjacent components of a block vector
+// are always adjacent in the image.
+func PixelIndex(x, y, blockSize int) int {
+	idx := y * blockSize
+	if y%2 == 0 {
+		idx += x
+	} else {
+		idx += blockSize - (x + 1)
+	}
+	return idx
+}
+
 // Count returns the number of blocks needed to
 // encode an image of the given dimensions.
 func Count(b image.Rectangle, blockSize int) int {
@@ -0,0 +1,75 @@
+package blocker
+
+import (
+	"image"
+	"image/color"
+)
+
+// A pixelReader returns the same 16-bit premultiplied
+// color channels as i.At(x, y).RGBA().
+type pixelReader func(x, y int) (r, g, b uint32)
+
+// newPixelReader creates a pixelReader for an image.
+//
+// Common image types are read straight from their Pix
+// slices, which avoids allocating a color.Color for
+// every pixel.
+func newPixelReader(i image.Image) pixelReader {
+	switch i := i.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b uint32) {
+			p := i.Pix[i.PixOffset(x, y):]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b uint32) {
+			p := i.Pix[i.PixOffset(x, y):]
+			a := uint32(p[3]) * 0x101
+			r = uint32(p[0]) * 0x101 * a / 0xffff
+			g = uint32(p[1]) * 0x101 * a / 0xffff
+			b = uint32(p[2]) * 0x101 * a / 0xffff
+			return
+		}
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b uint32) {
+			c := color.YCbCr{
+				Y:  i.Y[i.YOffset(x, y)],
+				Cb: i.Cb[i.COffset(x, y)],
+				Cr: i.Cr[i.COffset(x, y)],
+			}
+			r, g, b, _ = c.RGBA()
+			return
+		}
+	case *image.Gray:
+		return func(x, y int) (r, g, b uint32) {
+			v := uint32(i.Pix[i.PixOffset(x, y)]) * 0x101
+			return v, v, v
+		}
+	case *image.Paletted:
+		if len(i.Palette) > 0 {
+			return palettedReader(i)
+		}
+	}
+	return func(x, y int) (r, g, b uint32) {
+		r, g, b, _ = i.At(x, y).RGBA()
+		return
+	}
+}
+
+func palettedReader(i *image.Paletted) pixelReader {
+	var palette [256][3]uint32
+	for j := range palette {
+		// Out-of-range indices would make i.At panic, so
+		// we simply repeat the last color for them.
+		c := i.Palette[len(i.Palette)-1]
+		if j < len(i.Palette) {
+			c = i.Palette[j]
+		}
+		r, g, b, _ := c.RGBA()
+		palette[j] = [3]uint32{r, g, b}
+	}
+	return func(x, y int) (r, g, b uint32) {
+		c := palette[i.Pix[i.PixOffset(x, y)]]
+		return c[0], c[1], c[2]
+	}
+}
@@ -0,0 +1,148 @@
+package blocker
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// genericImage hides the concrete type of an image, so
+// that it is read through the image.Image interface.
+type genericImage struct {
+	image.Image
+}
+
+func testImages() map[string]image.Image {
+	r := rand.New(rand.NewSource(1))
+	rect := image.Rect(3, -2, 103, 75)
+	rgba := image.NewRGBA(rect)
+	nrgba := image.NewNRGBA(rect)
+	gray := image.NewGray(rect)
+	paletted := image.NewPaletted(rect, palette.Plan9)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for _, pix := range [][]byte{rgba.Pix, nrgba.Pix, gray.Pix, paletted.Pix,
+		ycbcr.Y, ycbcr.Cb, ycbcr.Cr} {
+		r.Read(pix)
+	}
+	return map[string]image.Image{
+		"RGBA":     rgba,
+		"NRGBA":    nrgba,
+		"YCbCr":    ycbcr,
+		"Gray":     gray,
+		"Paletted": paletted,
+	}
+}
+
+func TestPixelReader(t *testing.T) {
+	for name, img := range testImages() {
+		read := newPixelReader(img)
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, b := read(x, y)
+				er, eg, eb, _ := img.At(x, y).RGBA()
+				if r != er || g != eg || b != eb {
+					t.Fatalf("%s: pixel (%d, %d) should be %d,%d,%d but got %d,%d,%d",
+						name, x, y, er, eg, eb, r, g, b)
+				}
+			}
+		}
+	}
+}
+
+func TestBlocksFastPaths(t *testing.T) {
+	for name, img := range testImages() {
+		expected := Blocks(genericImage{img}, 8)
+		actual := Blocks(img, 8)
+		for i, block := range expected {
+			for j, x := range block {
+				if actual[i][j] != x {
+					t.Fatalf("%s: block %d differs at %d", name, i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestImageRect(t *testing.T) {
+	rect := image.Rect(-5, 7, 36, 30)
+	r := rand.New(rand.NewSource(1))
+	blocks := make([]linalg.Vector, Count(rect, 8))
+	for i := range blocks {
+		blocks[i] = make(linalg.Vector, 64)
+		for j := range blocks[i] {
+			blocks[i][j] = r.Float64()*1.2 - 0.1
+		}
+	}
+	expected := setImageRect(rect, blocks, 8)
+	actual := ImageRect(rect, blocks, 8)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if actual.RGBAAt(x, y) != expected.RGBAAt(x, y) {
+				t.Fatalf("pixel (%d, %d) differs", x, y)
+			}
+		}
+	}
+}
+
+func BenchmarkBlocks(b *testing.B) {
+	for name, img := range testImages() {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Blocks(img, 8)
+			}
+		})
+		b.Run(name+"Generic", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Blocks(genericImage{img}, 8)
+			}
+		})
+	}
+}
+
+func BenchmarkImage(b *testing.B) {
+	blocks := Blocks(testImages()["RGBA"], 8)
+	rect := image.Rect(0, 0, 100, 77)
+	b.Run("Pix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ImageRect(rect, blocks, 8)
+		}
+	})
+	b.Run("Generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			setImageRect(rect, blocks, 8)
+		}
+	})
+}
+
+// setImageRect is like ImageRect, but it writes every
+// pixel with Set, like a generic draw.Image would.
+func setImageRect(r image.Rectangle, blocks []linalg.Vector, blockSize int) *image.RGBA {
+	res := image.NewRGBA(r)
+	rows, cols := blockCounts(r, blockSize)
+	channel := func(x float64) uint8 {
+		return uint8(math.Min(math.Max(x, 0), 1) * 0xff)
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			colorBlocks := blocks[3*(row*cols+col):]
+			for y := 0; y < blockSize && y+row*blockSize < r.Dy(); y++ {
+				for x := 0; x < blockSize && x+col*blockSize < r.Dx(); x++ {
+					idx := PixelIndex(x, y, blockSize)
+					res.Set(r.Min.X+x+col*blockSize, r.Min.Y+y+row*blockSize, color.RGBA{
+						R: channel(colorBlocks[0][idx]),
+						G: channel(colorBlocks[1][idx]),
+						B: channel(colorBlocks[2][idx]),
+						A: 0xff,
+					})
+				}
+			}
+		}
+	}
+	return res
+}
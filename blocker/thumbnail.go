@@ -0,0 +1,84 @@
+package blocker
+
+import (
+	"image"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// Downsample averages each scale-by-scale group of pixels
+// in a block vector, producing a block vector with a side
+// length of blockSize/scale.
+//
+// The scale must evenly divide blockSize.
+func Downsample(vec linalg.Vector, blockSize, scale int) linalg.Vector {
+	return downsampleRect(vec, blockSize, scale, blockSize, blockSize)
+}
+
+// ThumbnailBases downsamples a list of basis vectors for
+// every block of a w-by-h image.
+// The result contains a list of downsampled vectors for
+// each block vector returned by Blocks.
+//
+// Unlike Downsample, the blocks at the right and bottom
+// edges only average the pixels inside the image, so
+// that their thumbnail pixels are not darkened by the
+// padding of the blocks.
+func ThumbnailBases(basis []linalg.Vector, w, h, blockSize, scale int) [][]linalg.Vector {
+	rows, cols := blockCounts(image.Rect(0, 0, w, h), blockSize)
+	res := make([][]linalg.Vector, 0, rows*cols*3)
+
+	// There are at most four distinct edge extents.
+	cache := map[image.Point][]linalg.Vector{}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			extent := image.Pt(blockSize, blockSize)
+			if rem := w - col*blockSize; rem < blockSize {
+				extent.X = rem
+			}
+			if rem := h - row*blockSize; rem < blockSize {
+				extent.Y = rem
+			}
+			vecs, ok := cache[extent]
+			if !ok {
+				vecs = make([]linalg.Vector, len(basis))
+				for i, vec := range basis {
+					vecs[i] = downsampleRect(vec, blockSize, scale, extent.X, extent.Y)
+				}
+				cache[extent] = vecs
+			}
+			res = append(res, vecs, vecs, vecs)
+		}
+	}
+	return res
+}
+
+// ThumbnailSize returns the dimensions of a w-by-h image
+// after it is scaled down by the given factor.
+// Partial pixels at the edges are rounded up.
+func ThumbnailSize(w, h, scale int) (int, int) {
+	return (w + scale - 1) / scale, (h + scale - 1) / scale
+}
+
+// downsampleRect is like Downsample, but it only averages
+// the pixels in the first w columns and h rows of the
+// block.
+// Groups without any of these pixels are set to 0.
+func downsampleRect(vec linalg.Vector, blockSize, scale, w, h int) linalg.Vector {
+	smallSize := blockSize / scale
+	res := make(linalg.Vector, smallSize*smallSize)
+	counts := make([]int, len(res))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			idx := PixelIndex(x/scale, y/scale, smallSize)
+			res[idx] += vec[PixelIndex(x, y, blockSize)]
+			counts[idx]++
+		}
+	}
+	for i, count := range counts {
+		if count > 0 {
+			res[i] /= float64(count)
+		}
+	}
+	return res
+}
@@ -0,0 +1,54 @@
+package blocker
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+func TestThumbnailBasesEdges(t *testing.T) {
+	// With the standard basis, each thumbnail pixel should
+	// be the average of the image pixels it covers.
+	const blockSize = 8
+	const scale = 4
+	basis := make([]linalg.Vector, blockSize*blockSize)
+	for i := range basis {
+		basis[i] = make(linalg.Vector, blockSize*blockSize)
+		basis[i][i] = 1
+	}
+	img := image.NewGray(image.Rect(0, 0, 13, 10))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 3)
+	}
+	blocks := Blocks(img, blockSize)
+	bases := ThumbnailBases(basis, 13, 10, blockSize, scale)
+	if len(bases) != len(blocks) {
+		t.Fatalf("expected %d bases but got %d", len(blocks), len(bases))
+	}
+	smallBlocks := make([]linalg.Vector, len(blocks))
+	for i, block := range blocks {
+		smallBlocks[i] = make(linalg.Vector, (blockSize/scale)*(blockSize/scale))
+		for j, vec := range bases[i] {
+			smallBlocks[i].Add(vec.Copy().Scale(block[j]))
+		}
+	}
+	w, h := ThumbnailSize(13, 10, scale)
+	thumb := Image(w, h, smallBlocks, blockSize/scale).(*image.RGBA)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, count float64
+			for py := y * scale; py < (y+1)*scale && py < 10; py++ {
+				for px := x * scale; px < (x+1)*scale && px < 13; px++ {
+					sum += float64(img.GrayAt(px, py).Y)
+					count++
+				}
+			}
+			actual := float64(thumb.RGBAAt(x, y).R)
+			if math.Abs(actual-sum/count) > 1 {
+				t.Errorf("pixel (%d, %d) should be %f but got %f", x, y, sum/count, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package entropy
+
+// A ByteModel adaptively codes bytes, one bit at a time,
+// using a binary tree of probabilities.
+// Each context in a context model should have its own
+// ByteModel.
+type ByteModel struct {
+	probs [256]Prob
+}
+
+// NewByteModel creates a ByteModel with no prior
+// knowledge of the byte distribution.
+func NewByteModel() *ByteModel {
+	res := &ByteModel{}
+	for i := range res.probs {
+		res.probs[i] = NewProb()
+	}
+	return res
+}
+
+// Encode writes a byte with an Encoder.
+func (m *ByteModel) Encode(e *Encoder, b byte) {
+	node := 1
+	for i := 7; i >= 0; i-- {
+		bit := int(b>>uint(i)) & 1
+		e.EncodeBit(&m.probs[node], bit)
+		node = node*2 + bit
+	}
+}
+
+// Decode reads a byte that was written by Encode.
+func (m *ByteModel) Decode(d *Decoder) (byte, error) {
+	node := 1
+	for i := 0; i < 8; i++ {
+		bit, err := d.DecodeBit(&m.probs[node])
+		if err != nil {
+			return 0, err
+		}
+		node = node*2 + bit
+	}
+	return byte(node), nil
+}
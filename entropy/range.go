@@ -0,0 +1,145 @@
+// Package entropy implements an adaptive binary range
+// coder, which can be used to entropy code data with
+// context modeling.
+package entropy
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	probBits  = 11
+	probInit  = 1 << (probBits - 1)
+	moveBits  = 5
+	topValue  = 1 << 24
+	flushSize = 5
+)
+
+// A Prob is an adaptive estimate of the probability that
+// a bit is 0.
+//
+// Each Prob must be initialized with NewProb, and the
+// encoder and decoder must use the same Probs in the same
+// order.
+type Prob uint16
+
+// NewProb creates a Prob that starts at 50%.
+func NewProb() Prob {
+	return probInit
+}
+
+func (p *Prob) update(bit int) {
+	if bit == 0 {
+		*p += ((1 << probBits) - *p) >> moveBits
+	} else {
+		*p -= *p >> moveBits
+	}
+}
+
+// An Encoder writes bits with adaptive probabilities.
+type Encoder struct {
+	w         io.ByteWriter
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+	err       error
+}
+
+// NewEncoder creates an Encoder that writes to w.
+func NewEncoder(w io.ByteWriter) *Encoder {
+	return &Encoder{w: w, rng: 0xffffffff, cacheSize: 1}
+}
+
+// EncodeBit writes a bit (0 or 1) and updates the
+// probability estimate.
+func (e *Encoder) EncodeBit(p *Prob, bit int) {
+	bound := (e.rng >> probBits) * uint32(*p)
+	if bit == 0 {
+		e.rng = bound
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+	}
+	p.update(bit)
+	for e.rng < topValue {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+}
+
+// Flush writes the final bytes of the stream.
+// It must be called once all the bits have been encoded,
+// and it returns the first error from the writer, if any.
+func (e *Encoder) Flush() error {
+	for i := 0; i < flushSize; i++ {
+		e.shiftLow()
+	}
+	return e.err
+}
+
+func (e *Encoder) shiftLow() {
+	if uint32(e.low) < 0xff000000 || e.low>>32 != 0 {
+		carry := byte(e.low >> 32)
+		temp := e.cache
+		for ; e.cacheSize > 0; e.cacheSize-- {
+			if err := e.w.WriteByte(temp + carry); err != nil && e.err == nil {
+				e.err = err
+			}
+			temp = 0xff
+		}
+		e.cache = byte(e.low >> 24)
+	}
+	e.cacheSize++
+	e.low = (e.low & 0x00ffffff) << 8
+}
+
+// A Decoder reads bits that were written by an Encoder.
+type Decoder struct {
+	r    io.ByteReader
+	rng  uint32
+	code uint32
+}
+
+// NewDecoder creates a Decoder that reads from r.
+func NewDecoder(r io.ByteReader) (*Decoder, error) {
+	d := &Decoder{r: r, rng: 0xffffffff}
+	for i := 0; i < flushSize; i++ {
+		if err := d.shift(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// DecodeBit reads a bit and updates the probability
+// estimate in the same way as EncodeBit.
+func (d *Decoder) DecodeBit(p *Prob) (int, error) {
+	bound := (d.rng >> probBits) * uint32(*p)
+	var bit int
+	if d.code < bound {
+		d.rng = bound
+	} else {
+		d.code -= bound
+		d.rng -= bound
+		bit = 1
+	}
+	p.update(bit)
+	for d.rng < topValue {
+		d.rng <<= 8
+		if err := d.shift(); err != nil {
+			return 0, err
+		}
+	}
+	return bit, nil
+}
+
+func (d *Decoder) shift() error {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return errors.New("unexpected end of range coded data")
+	}
+	d.code = (d.code << 8) | uint32(b)
+	return nil
+}
@@ -0,0 +1,436 @@
+// Package testutil provides images and checks that are
+// shared by the tests of the codecs.
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/strip"
+)
+
+// A Codec is any compressor whose output can be checked
+// by this package.
+type Codec interface {
+	Compress(i image.Image) []byte
+	Decompress(d []byte) (image.Image, error)
+}
+
+// Image creates a smooth, colorful test image.
+func Image(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 128 + 100*math.Sin(float64(x)/3)*math.Cos(float64(y)/5)
+			img.Set(x, y, color.RGBA{uint8(v), uint8(255 - v), uint8(x * 8), 0xff})
+		}
+	}
+	return img
+}
+
+// DegenerateImages returns images that codecs tend to
+// get wrong, keyed by description: a solid color, an
+// empty image, a single pixel, and an image whose bounds
+// do not start at the origin.
+func DegenerateImages() map[string]image.Image {
+	solid := image.NewRGBA(image.Rect(0, 0, 13, 6))
+	for i := range solid.Pix {
+		solid.Pix[i] = []uint8{0x30, 0xc0, 0x7f, 0xff}[i%4]
+	}
+	return map[string]image.Image{
+		"solid":  solid,
+		"0x0":    image.NewRGBA(image.Rect(0, 0, 0, 0)),
+		"1x1":    Image(1, 1),
+		"offset": Image(20, 15).(*image.RGBA).SubImage(image.Rect(3, 5, 14, 12)),
+	}
+}
+
+// CheckConcurrency checks that a codec produces the same
+// data and the same decoded image regardless of its
+// concurrency.
+// The newCodec function creates a codec that uses the
+// given number of goroutines.
+func CheckConcurrency(t *testing.T, img image.Image, newCodec func(concurrency int) Codec) {
+	t.Helper()
+	var expected []byte
+	var expectedPix []byte
+	// Concurrency 1 is repeated to catch nondeterminism
+	// that does not come from concurrency.
+	for _, concurrency := range []int{1, 1, 4, 16} {
+		c := newCodec(concurrency)
+		data := c.Compress(img)
+		decoded, err := c.Decompress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pix := decoded.(*image.RGBA).Pix
+		if expected == nil {
+			expected, expectedPix = data, pix
+		} else if !bytes.Equal(data, expected) {
+			t.Errorf("data differs with concurrency %d", concurrency)
+		} else if !bytes.Equal(pix, expectedPix) {
+			t.Errorf("image differs with concurrency %d", concurrency)
+		}
+	}
+}
+
+// CheckThumbnail checks that every pixel of a thumbnail
+// is close to the average of the pixels of full that it
+// covers, including at the edges of the image, where a
+// thumbnail pixel covers fewer than scale*scale pixels.
+func CheckThumbnail(t *testing.T, full, thumb image.Image, scale int) {
+	t.Helper()
+	fb := full.Bounds()
+	w, h := (fb.Dx()+scale-1)/scale, (fb.Dy()+scale-1)/scale
+	if thumb.Bounds() != image.Rect(0, 0, w, h) {
+		t.Fatalf("bad thumbnail bounds: %v", thumb.Bounds())
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum, count float64
+			for py := y * scale; py < (y+1)*scale && py < fb.Dy(); py++ {
+				for px := x * scale; px < (x+1)*scale && px < fb.Dx(); px++ {
+					r, _, _, _ := full.At(px+fb.Min.X, py+fb.Min.Y).RGBA()
+					sum += float64(r >> 8)
+					count++
+				}
+			}
+			r, _, _, _ := thumb.At(x, y).RGBA()
+			if math.Abs(float64(r>>8)-sum/count) > 2 {
+				t.Fatalf("pixel (%d, %d) should be %f but got %d", x, y, sum/count, r>>8)
+			}
+		}
+	}
+}
+
+// CheckDecodeThumbnail checks the thumbnail of an image
+// whose size is not a multiple of the block size of c,
+// which must have a DecodeThumbnail method, against the
+// full decoded image using CheckThumbnail.
+func CheckDecodeThumbnail(t *testing.T, c Codec, scale int) {
+	t.Helper()
+	th, ok := c.(thumbnailer)
+	if !ok {
+		t.Fatal("codec cannot decode thumbnails")
+	}
+	data := c.Compress(Image(21, 13))
+	full, err := c.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := th.DecodeThumbnail(data, scale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckThumbnail(t, full, thumb, scale)
+}
+
+// CheckDecoders checks that the data of each codec
+// decodes to the same image with every one of the codecs,
+// so that decoding only depends on the data rather than
+// on the settings of the decoder.
+func CheckDecoders(t *testing.T, img image.Image, codecs ...Codec) {
+	t.Helper()
+	for i, encoder := range codecs {
+		data := encoder.Compress(img)
+		expected, err := encoder.Decompress(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, decoder := range codecs {
+			actual, err := decoder.Decompress(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(actual.(*image.RGBA).Pix, expected.(*image.RGBA).Pix) {
+				t.Errorf("data of codec %d decodes differently with codec %d", i, j)
+			}
+		}
+	}
+}
+
+// A StripedCodec compresses and decompresses images one
+// row of blocks at a time.
+type StripedCodec interface {
+	CompressStriped(src strip.Source, w io.Writer) error
+	DecompressStriped(r io.Reader, sink strip.Sink) error
+}
+
+// CheckStripedLimits checks that DecompressStriped
+// reports a limits.LimitError for huge dimensions, both
+// when a single row of blocks would be too large and when
+// only the whole image would be.
+//
+// The data of c must start with its width and height as
+// little-endian 32-bit integers.
+func CheckStripedLimits(t *testing.T, c StripedCodec) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := c.CompressStriped(strip.ImageSource(Image(9, 5)), &buf); err != nil {
+		t.Fatal(err)
+	}
+	sizes := []struct {
+		Width  uint32
+		Height uint32
+		Sink   *strip.ImageSink
+	}{
+		// Each row of blocks would take gigabytes.
+		{1 << 28, 1, &strip.ImageSink{}},
+
+		// Rows are small, but the full image is not.
+		{4, 1 << 26, &strip.ImageSink{DecodeOptions: limits.DecodeOptions{MaxMemory: 1 << 20}}},
+	}
+	for _, size := range sizes {
+		data := append([]byte{}, buf.Bytes()...)
+		binary.LittleEndian.PutUint32(data, size.Width)
+		binary.LittleEndian.PutUint32(data[4:], size.Height)
+		err := c.DecompressStriped(bytes.NewReader(data), size.Sink)
+		var limitErr *limits.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%dx%d: expected LimitError but got %v", size.Width, size.Height, err)
+		}
+	}
+}
+
+// FuzzOptions are small decode limits for fuzz targets,
+// so that hostile headers are rejected quickly.
+var FuzzOptions = limits.DecodeOptions{MaxPixels: 1 << 16, MaxMemory: 1 << 24}
+
+// CheckFuzzBounds checks that a decoded image respects
+// the pixel limit of FuzzOptions.
+func CheckFuzzBounds(t *testing.T, b image.Rectangle) {
+	t.Helper()
+	if int64(b.Dx())*int64(b.Dy()) > FuzzOptions.MaxPixels {
+		t.Fatalf("decoded image exceeds MaxPixels: %v", b)
+	}
+}
+
+// FuzzCodecs fuzzes the decoders of some codecs, which
+// should use FuzzOptions.
+// The corpus is seeded with the encodings of small images.
+//
+// Besides Decompress, DecodeThumbnail and
+// DecompressStriped are fuzzed if a codec has them.
+func FuzzCodecs(f *testing.F, codecs []Codec) {
+	for i, c := range codecs {
+		f.Add(uint8(i), c.Compress(Image(11, 9)))
+		f.Add(uint8(i), c.Compress(Image(1, 1)))
+	}
+	f.Fuzz(func(t *testing.T, which uint8, data []byte) {
+		c := codecs[int(which)%len(codecs)]
+		if img, err := c.Decompress(data); err == nil {
+			CheckFuzzBounds(t, img.Bounds())
+		}
+		if th, ok := c.(thumbnailer); ok {
+			if img, err := th.DecodeThumbnail(data, 2); err == nil {
+				CheckFuzzBounds(t, img.Bounds())
+			}
+		}
+		if sd, ok := c.(stripedDecoder); ok {
+			sink := &strip.ImageSink{DecodeOptions: FuzzOptions}
+			if err := sd.DecompressStriped(bytes.NewReader(data), sink); err == nil {
+				CheckFuzzBounds(t, sink.Image.Bounds())
+			}
+		}
+	})
+}
+
+// CheckProgressive checks that progressive data is
+// flagged, so that it cannot be decoded by plain (a
+// non-progressive codec) and vice versa, and that it can
+// be truncated after a layer but not in the middle of
+// the last layer.
+// The last layer of img must take more than one byte.
+func CheckProgressive(t *testing.T, progressive, plain Codec, img image.Image) {
+	t.Helper()
+	data := progressive.Compress(img)
+	if _, err := progressive.Decompress(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Decompress(data); err == nil {
+		t.Error("non-progressive codec decoded progressive data")
+	}
+	if _, err := progressive.Decompress(plain.Compress(img)); err == nil {
+		t.Error("progressive codec decoded non-progressive data")
+	}
+
+	if _, err := progressive.Decompress(data[:len(data)-1]); err == nil {
+		t.Error("expected error for data truncated in the middle of a layer")
+	}
+	for n := len(data) - 2; n > 0; n-- {
+		if preview, err := progressive.Decompress(data[:n]); err == nil {
+			if preview.Bounds().Size() != img.Bounds().Size() {
+				t.Errorf("bad preview bounds: %v", preview.Bounds())
+			}
+			return
+		}
+	}
+	t.Error("no prefix of the data could be decoded")
+}
+
+// A SharedCodec is a codec that can store a basis once
+// for several images.
+//
+// It mirrors batch.SharedCodec, which cannot be used
+// here since the batch tests import this package.
+type SharedCodec interface {
+	TrainSharedBasis(images []image.Image) []byte
+	CompressShared(basis []byte, i image.Image) ([]byte, error)
+	DecompressShared(basis, d []byte) (image.Image, error)
+}
+
+// FuzzSharedCodecs is like FuzzCodecs, but it fuzzes
+// both the shared basis and the data of DecompressShared.
+func FuzzSharedCodecs(f *testing.F, codecs []SharedCodec) {
+	for i, c := range codecs {
+		images := []image.Image{Image(11, 9), Image(5, 7)}
+		basis := c.TrainSharedBasis(images)
+		data, err := c.CompressShared(basis, images[1])
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), basis, data)
+	}
+	f.Fuzz(func(t *testing.T, which uint8, basis, data []byte) {
+		c := codecs[int(which)%len(codecs)]
+		if img, err := c.DecompressShared(basis, data); err == nil {
+			CheckFuzzBounds(t, img.Bounds())
+		}
+	})
+}
+
+// CheckRoundTrip checks that a codec preserves the size
+// of an image and that no 8-bit channel of any pixel
+// changes by more than maxError.
+func CheckRoundTrip(t *testing.T, c Codec, img image.Image, maxError int) {
+	t.Helper()
+	data, err := compress(c, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := c.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1, b2 := img.Bounds(), decoded.Bounds()
+	if b1.Size() != b2.Size() {
+		t.Fatalf("expected size %v but got %v", b1.Size(), b2.Size())
+	}
+	for y := 0; y < b1.Dy(); y++ {
+		for x := 0; x < b1.Dx(); x++ {
+			r1, g1, bl1, _ := img.At(x+b1.Min.X, y+b1.Min.Y).RGBA()
+			r2, g2, bl2, _ := decoded.At(x+b2.Min.X, y+b2.Min.Y).RGBA()
+			for _, d := range []int{
+				int(r1>>8) - int(r2>>8),
+				int(g1>>8) - int(g2>>8),
+				int(bl1>>8) - int(bl2>>8),
+			} {
+				if d > maxError || -d > maxError {
+					t.Fatalf("pixel (%d, %d) is off by %d (max %d)", x, y, d, maxError)
+				}
+			}
+		}
+	}
+}
+
+// MeanError computes the mean absolute difference between
+// the 8-bit channels of two images of the same size, over
+// a rectangle relative to the top-left corner of each
+// image.
+func MeanError(img1, img2 image.Image, r image.Rectangle) float64 {
+	b1, b2 := img1.Bounds(), img2.Bounds()
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, bl1, _ := img1.At(x+b1.Min.X, y+b1.Min.Y).RGBA()
+			r2, g2, bl2, _ := img2.At(x+b2.Min.X, y+b2.Min.Y).RGBA()
+			for _, d := range []int{
+				int(r1>>8) - int(r2>>8),
+				int(g1>>8) - int(g2>>8),
+				int(bl1>>8) - int(bl2>>8),
+			} {
+				sum += math.Abs(float64(d))
+			}
+		}
+	}
+	return sum / float64(3*r.Dx()*r.Dy())
+}
+
+// compress uses CompressChecked if the codec has it, so
+// that errors are reported rather than panicking.
+func compress(c Codec, img image.Image) ([]byte, error) {
+	if cc, ok := c.(interface {
+		CompressChecked(i image.Image) ([]byte, error)
+	}); ok {
+		return cc.CompressChecked(img)
+	}
+	return c.Compress(img), nil
+}
+
+// QuantizationError converts the largest error of each
+// coefficient of an orthonormal basis into the largest
+// error of an 8-bit channel.
+//
+// By the Cauchy-Schwarz inequality, a pixel is off by at
+// most the norm of the coefficient errors, since every
+// row of an orthonormal basis has unit norm.
+// One more level is allowed for rounding the output.
+func QuantizationError(coeffErrors []float64) int {
+	var sum float64
+	for _, e := range coeffErrors {
+		sum += e * e
+	}
+	return int(math.Ceil(math.Sqrt(sum)*0xff)) + 1
+}
+
+// CoefficientErrors bounds the error of each coefficient
+// when the blocks of img are stored in an orthonormal
+// basis.
+//
+// If t is nil, the coefficients are assumed to be stored
+// as bytes spanning the range of coefficients, which is
+// at most the largest block norm in each direction.
+// Otherwise, they are quantized by t, with the step of
+// coefficient i at stepIndices[i] (or at i, if
+// stepIndices is nil).
+func CoefficientErrors(img image.Image, blockSize int, t *quantize.Table,
+	stepIndices []int) []float64 {
+	res := make([]float64, blockSize*blockSize)
+	if t == nil {
+		var maxNorm float64
+		for _, block := range blocker.Blocks(img, blockSize) {
+			maxNorm = math.Max(maxNorm, block.Mag())
+		}
+		for i := range res {
+			res[i] = maxNorm / 0xff
+		}
+		return res
+	}
+	for i := range res {
+		idx := i
+		if stepIndices != nil {
+			idx = stepIndices[i]
+		}
+		// Values in the deadzone become zero, and values
+		// just outside of it become one step.
+		res[i] = t.Step(idx) * math.Max(t.Deadzone, 1-t.Deadzone)
+	}
+	return res
+}
+
+type thumbnailer interface {
+	DecodeThumbnail(d []byte, scale int) (image.Image, error)
+}
+
+type stripedDecoder interface {
+	DecompressStriped(r io.Reader, sink strip.Sink) error
+}
@@ -0,0 +1,141 @@
+// Package limits bounds the resources that decoders may
+// use, so that hostile or corrupt data cannot trigger
+// huge allocations.
+package limits
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	DefaultMaxPixels    = 1 << 28
+	DefaultMaxMemory    = 1 << 32
+	DefaultMaxBasisSize = 1 << 12
+)
+
+// DecodeOptions specifies the resource limits for a
+// decoder.
+//
+// For each field, 0 means that the corresponding default
+// limit is used, and a negative value means no limit.
+type DecodeOptions struct {
+	// MaxPixels is the maximum number of pixels in a
+	// decoded image.
+	MaxPixels int64
+
+	// MaxMemory is the maximum number of bytes that a
+	// decoder may allocate, as estimated before decoding.
+	MaxMemory int64
+
+	// MaxBasisSize is the maximum number of basis vectors
+	// (or principal components) in an encoded image.
+	MaxBasisSize int64
+}
+
+// A LimitError is returned when decoding some data would
+// exceed a limit in DecodeOptions.
+type LimitError struct {
+	// Limit is the name of the exceeded limit, such as
+	// "pixels" or "memory".
+	Limit string
+
+	Value uint64
+	Max   uint64
+}
+
+func (l *LimitError) Error() string {
+	return fmt.Sprintf("decode limit exceeded: %s %d > %d", l.Limit, l.Value, l.Max)
+}
+
+// CheckPixels checks the dimensions of an image.
+func (d *DecodeOptions) CheckPixels(width, height uint64) error {
+	return check("pixels", Product(width, height), d.MaxPixels, DefaultMaxPixels)
+}
+
+// CheckMemory checks the estimated number of bytes that a
+// decoder will allocate.
+func (d *DecodeOptions) CheckMemory(bytes uint64) error {
+	return check("memory", bytes, d.MaxMemory, DefaultMaxMemory)
+}
+
+// CheckBasisSize checks the number of basis vectors in an
+// encoded image.
+func (d *DecodeOptions) CheckBasisSize(size uint64) error {
+	return check("basis size", size, d.MaxBasisSize, DefaultMaxBasisSize)
+}
+
+// CheckBlocks checks the dimensions of an image and the
+// memory needed to decode it from square blocks with
+// coeffs coefficients per color channel.
+//
+// The estimate covers the coefficients, the decoded
+// blocks, and the resulting RGBA image.
+func (d *DecodeOptions) CheckBlocks(width, height uint64, blockSize, coeffs int) error {
+	if err := d.CheckPixels(width, height); err != nil {
+		return err
+	}
+	bs := uint64(blockSize)
+	blocks := Product(3, (width+bs-1)/bs, (height+bs-1)/bs)
+	memory := Sum(
+		Product(blocks, uint64(coeffs), 8),
+		Product(blocks, bs, bs, 8),
+		Product(width, height, 4),
+	)
+	return d.CheckMemory(memory)
+}
+
+// CheckStrip checks the memory needed to decode a single
+// row of square blocks, with coeffs coefficients per color
+// channel, for an image of the given width.
+//
+// Like CheckBlocks, the estimate covers the coefficients,
+// the decoded blocks, and the resulting RGBA strip.
+func (d *DecodeOptions) CheckStrip(width uint64, blockSize, coeffs int) error {
+	bs := uint64(blockSize)
+	blocks := Product(3, (width+bs-1)/bs)
+	memory := Sum(
+		Product(blocks, uint64(coeffs), 8),
+		Product(blocks, bs, bs, 8),
+		Product(width, bs, 4),
+	)
+	return d.CheckMemory(memory)
+}
+
+// Product multiplies numbers, saturating at the maximum
+// uint64 instead of overflowing.
+func Product(nums ...uint64) uint64 {
+	res := uint64(1)
+	for _, n := range nums {
+		if n != 0 && res > math.MaxUint64/n {
+			return math.MaxUint64
+		}
+		res *= n
+	}
+	return res
+}
+
+// Sum adds numbers, saturating at the maximum uint64
+// instead of overflowing.
+func Sum(nums ...uint64) uint64 {
+	var res uint64
+	for _, n := range nums {
+		if res > math.MaxUint64-n {
+			return math.MaxUint64
+		}
+		res += n
+	}
+	return res
+}
+
+func check(name string, value uint64, limit, defaultLimit int64) error {
+	if limit == 0 {
+		limit = defaultLimit
+	} else if limit < 0 {
+		return nil
+	}
+	if value > uint64(limit) {
+		return &LimitError{Limit: name, Value: value, Max: uint64(limit)}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package limits
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	opts := &DecodeOptions{MaxPixels: 100, MaxMemory: 1000, MaxBasisSize: 4}
+	tests := []struct {
+		name  string
+		err   error
+		limit string
+	}{
+		{"pixels ok", opts.CheckPixels(10, 10), ""},
+		{"pixels", opts.CheckPixels(11, 10), "pixels"},
+		{"pixels overflow", opts.CheckPixels(math.MaxUint64, 2), "pixels"},
+		{"memory ok", opts.CheckMemory(1000), ""},
+		{"memory", opts.CheckMemory(1001), "memory"},
+		{"basis ok", opts.CheckBasisSize(4), ""},
+		{"basis", opts.CheckBasisSize(5), "basis size"},
+		{"blocks pixels", opts.CheckBlocks(20, 20, 4, 1), "pixels"},
+		{"blocks memory", opts.CheckBlocks(8, 8, 4, 16), "memory"},
+		{"strip memory", opts.CheckStrip(1<<20, 8, 1), "memory"},
+	}
+	for _, test := range tests {
+		if test.limit == "" {
+			if test.err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, test.err)
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(test.err, &limitErr) {
+			t.Errorf("%s: expected LimitError but got %v", test.name, test.err)
+		} else if limitErr.Limit != test.limit {
+			t.Errorf("%s: expected limit %q but got %q", test.name, test.limit, limitErr.Limit)
+		}
+	}
+}
+
+func TestDecodeOptionsDefaults(t *testing.T) {
+	var opts DecodeOptions
+	if err := opts.CheckPixels(1<<14, 1<<14); err != nil {
+		t.Error(err)
+	}
+	if err := opts.CheckPixels(1<<14, 1<<14+1); err == nil {
+		t.Error("expected default pixel limit")
+	}
+	unlimited := DecodeOptions{MaxPixels: -1, MaxMemory: -1, MaxBasisSize: -1}
+	if err := unlimited.CheckBlocks(1<<30, 1<<30, 8, 64); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,29 @@
+package lossless
+
+import (
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func testCompressor(maxAbsError int) *Compressor {
+	codec := smallbasis.NewCompressorBlockSize(0.3, 4)
+	codec.DecodeOptions = testutil.FuzzOptions
+	c := NewCompressor(codec)
+	c.MaxAbsError = maxAbsError
+	c.DecodeOptions = testutil.FuzzOptions
+	return c
+}
+
+func FuzzDecompress(f *testing.F) {
+	c := testCompressor(0)
+	f.Add(c.Compress(testutil.Image(11, 9)))
+	f.Add(c.Compress(testutil.Image(1, 1)))
+	f.Add(testCompressor(3).Compress(testutil.Image(11, 9)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if img, err := c.Decompress(data); err == nil {
+			testutil.CheckFuzzBounds(t, img.Bounds())
+		}
+	})
+}
@@ -0,0 +1,185 @@
+// Package lossless stores images exactly (or within a
+// fixed error bound) by combining a lossy codec with an
+// entropy coded residual.
+package lossless
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+
+	"github.com/unixpickle/imagecompress/entropy"
+	"github.com/unixpickle/imagecompress/limits"
+)
+
+const fileMagic = "ICLS"
+
+const headerSize = 4 + 4
+
+// MaxMaxAbsError is the largest supported MaxAbsError.
+// With this bound, every pixel of the lossy layer is
+// already close enough.
+const MaxMaxAbsError = 0xff
+
+var encodingEndian = binary.LittleEndian
+
+// A Codec compresses the lossy layer of an image.
+type Codec interface {
+	Compress(i image.Image) []byte
+	Decompress(d []byte) (image.Image, error)
+}
+
+// A Compressor stores images losslessly.
+//
+// The compressed data has two layers: the lossy encoding
+// produced by a Codec, and the residual between the lossy
+// approximation and the original image.
+// The lossy layer comes first and can be decoded on its
+// own, so the residual may be fetched later (or never).
+//
+// Images are lossless up to 8-bit RGB values.
+// Like the lossy codecs, the Compressor does not store
+// alpha, so only opaque images are reproduced exactly.
+type Compressor struct {
+	Codec Codec
+
+	// MaxAbsError, if non-zero, makes the Compressor
+	// near-lossless: every 8-bit channel of every decoded
+	// pixel is within MaxAbsError of the original, and only
+	// the pixels for which the lossy layer exceeds that
+	// bound are corrected.
+	// It must be at most MaxMaxAbsError.
+	MaxAbsError int
+
+	// DecodeOptions limits the size of refined images.
+	// The lossy layer is decoded by the Codec, which should
+	// enforce its own limits.
+	DecodeOptions limits.DecodeOptions
+}
+
+// NewCompressor creates a Compressor with a lossy codec.
+func NewCompressor(codec Codec) *Compressor {
+	return &Compressor{Codec: codec}
+}
+
+// Compress compresses an image and returns a binary
+// encoding of the result.
+//
+// It panics if the Codec cannot decode its own output;
+// use CompressChecked to get an error instead.
+func (c *Compressor) Compress(img image.Image) []byte {
+	res, err := c.CompressChecked(img)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// CompressChecked is like Compress, but it returns an
+// error if the lossy layer cannot be decoded or if
+// MaxAbsError is out of range.
+func (c *Compressor) CompressChecked(img image.Image) ([]byte, error) {
+	if c.MaxAbsError < 0 || c.MaxAbsError > MaxMaxAbsError {
+		return nil, errors.New("max absolute error out of range")
+	}
+	lossyData := c.Codec.Compress(img)
+	lossy, err := c.Codec.Decompress(lossyData)
+	if err != nil {
+		return nil, errors.New("failed to decode lossy layer: " + err.Error())
+	}
+	if lossy.Bounds().Dx() != img.Bounds().Dx() || lossy.Bounds().Dy() != img.Bounds().Dy() {
+		return nil, errors.New("lossy layer has unexpected dimensions")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	binary.Write(&buf, encodingEndian, uint32(len(lossyData)))
+	buf.Write(lossyData)
+	buf.WriteByte(byte(c.MaxAbsError))
+	e := entropy.NewEncoder(&buf)
+	encodeResidual(e, img, toRGBA(lossy), c.MaxAbsError)
+	e.Flush()
+	return buf.Bytes(), nil
+}
+
+// Decompress decodes both layers of an image, producing
+// an exact copy of the original (or one within the bound
+// it was compressed with).
+func (c *Compressor) Decompress(d []byte) (image.Image, error) {
+	lossyData, residual, err := Layers(d)
+	if err != nil {
+		return nil, err
+	}
+	lossy, err := c.Codec.Decompress(lossyData)
+	if err != nil {
+		return nil, err
+	}
+	return c.Refine(lossy, residual)
+}
+
+// DecompressLossy decodes only the lossy layer of an
+// image.
+// The data may be truncated anywhere after the lossy
+// layer.
+func (c *Compressor) DecompressLossy(d []byte) (image.Image, error) {
+	lossyData, _, err := Layers(d)
+	if err != nil {
+		return nil, err
+	}
+	return c.Codec.Decompress(lossyData)
+}
+
+// Refine applies the residual layer to a decoded lossy
+// layer, producing a copy of the original image.
+//
+// The residual layer records its own error bound, so
+// Refine does not depend on c.MaxAbsError.
+func (c *Compressor) Refine(lossy image.Image, residual []byte) (image.Image, error) {
+	b := lossy.Bounds()
+	if err := c.DecodeOptions.CheckPixels(uint64(b.Dx()), uint64(b.Dy())); err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(residual)
+	maxError, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("failed to read residual: missing error bound")
+	}
+	d, err := entropy.NewDecoder(r)
+	if err != nil {
+		return nil, errors.New("failed to read residual: " + err.Error())
+	}
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Bounds(), lossy, b.Min, draw.Src)
+	if err := decodeResidual(d, res, int(maxError)); err != nil {
+		return nil, errors.New("failed to read residual: " + err.Error())
+	}
+	return res, nil
+}
+
+// Layers splits compressed data into the lossy layer and
+// the residual layer.
+// The residual layer will be incomplete if the data is
+// truncated.
+func Layers(d []byte) (lossy, residual []byte, err error) {
+	if len(d) < headerSize || string(d[:4]) != fileMagic {
+		return nil, nil, errors.New("not a lossless image")
+	}
+	lossyLen := uint64(encodingEndian.Uint32(d[4:]))
+	if lossyLen > uint64(len(d)-headerSize) {
+		return nil, nil, errors.New("lossy layer is truncated")
+	}
+	return d[headerSize : headerSize+lossyLen], d[headerSize+lossyLen:], nil
+}
+
+// toRGBA returns an *image.RGBA with the pixels of img,
+// copying it only if necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	res := image.NewRGBA(img.Bounds())
+	draw.Draw(res, res.Bounds(), img, img.Bounds().Min, draw.Src)
+	return res
+}
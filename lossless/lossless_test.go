@@ -0,0 +1,56 @@
+package lossless
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/pcaprune"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func TestMaxAbsError(t *testing.T) {
+	pca := pcaprune.NewCompressorBlockSize(0.3, 4)
+	pca.Quantizer = quantize.Lookup(quantize.CoarseTableID)
+	codecs := map[string]Codec{
+		"smallbasis": smallbasis.NewCompressorBlockSize(0.3, 4),
+		"pcaprune":   pca,
+	}
+	gen := rand.New(rand.NewSource(1337))
+	for name, codec := range codecs {
+		for _, size := range []image.Point{{1, 1}, {7, 5}, {40, 33}} {
+			img := randomImage(gen, size.X, size.Y)
+			for _, maxAbsError := range []int{0, 1, 5, 40} {
+				c := NewCompressor(codec)
+				c.MaxAbsError = maxAbsError
+				t.Run(fmt.Sprintf("%s/%v/%d", name, size, maxAbsError), func(t *testing.T) {
+					testutil.CheckRoundTrip(t, c, img, maxAbsError)
+				})
+			}
+		}
+	}
+}
+
+// randomImage creates an opaque image of smooth gradients
+// mixed with noise, so that the lossy layer is accurate
+// for some pixels and far off for others.
+func randomImage(gen *rand.Rand, width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := img.Pix[img.PixOffset(x, y):]
+			for c := 0; c < 3; c++ {
+				v := (x+y)*4 + c*60
+				if gen.Intn(4) == 0 {
+					v += gen.Intn(256)
+				}
+				p[c] = uint8(v)
+			}
+			p[3] = 0xff
+		}
+	}
+	return img
+}
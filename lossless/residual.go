@@ -0,0 +1,190 @@
+package lossless
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/unixpickle/imagecompress/entropy"
+)
+
+const (
+	activityBuckets = 8
+	channelBuckets  = 4
+)
+
+// residualModel chooses a ByteModel for each residual
+// based on the residuals around it.
+//
+// Residuals tend to be larger near edges and in textured
+// regions, and they are correlated between the channels
+// of a pixel, so the context is made up of the activity
+// of the left and upper neighbors and the residual of
+// the previous channel.
+type residualModel struct {
+	models []*entropy.ByteModel
+}
+
+func newResidualModel() *residualModel {
+	res := &residualModel{
+		models: make([]*entropy.ByteModel, 3*activityBuckets*channelBuckets),
+	}
+	for i := range res.models {
+		res.models[i] = entropy.NewByteModel()
+	}
+	return res
+}
+
+func (r *residualModel) model(channel int, left, up, prevChannel int8) *entropy.ByteModel {
+	activity := bucket(abs8(left)+abs8(up), activityBuckets)
+	cross := 0
+	if channel > 0 {
+		cross = bucket(abs8(prevChannel), channelBuckets)
+	}
+	return r.models[(channel*activityBuckets+activity)*channelBuckets+cross]
+}
+
+// residualRows stores the residuals of the current and
+// previous rows, which are used as contexts.
+type residualRows struct {
+	width int
+	prev  []int8
+	cur   []int8
+}
+
+func newResidualRows(width int) *residualRows {
+	return &residualRows{
+		width: width,
+		prev:  make([]int8, width*3),
+		cur:   make([]int8, width*3),
+	}
+}
+
+// context returns the left, upper, and previous channel
+// residuals for a channel of a pixel.
+func (r *residualRows) context(x, channel int) (left, up, prevChannel int8) {
+	if x > 0 {
+		left = r.cur[(x-1)*3+channel]
+	}
+	up = r.prev[x*3+channel]
+	if channel > 0 {
+		prevChannel = r.cur[x*3+channel-1]
+	}
+	return
+}
+
+func (r *residualRows) nextRow() {
+	r.prev, r.cur = r.cur, r.prev
+}
+
+// encodeResidual codes the difference between the 8-bit
+// RGB values of src and lossy, which must have the same
+// dimensions.
+//
+// If maxError is non-zero, the differences are quantized
+// so that no decoded value is off by more than maxError.
+func encodeResidual(e *entropy.Encoder, src image.Image, lossy *image.RGBA, maxError int) {
+	b := src.Bounds()
+	lb := lossy.Bounds()
+	model := newResidualModel()
+	rows := newResidualRows(b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := src.At(x+b.Min.X, y+b.Min.Y).RGBA()
+			p := lossy.Pix[lossy.PixOffset(x+lb.Min.X, y+lb.Min.Y):]
+			for ch, val := range []uint32{r >> 8, g >> 8, bl >> 8} {
+				residual := quantizeResidual(int(val)-int(p[ch]), maxError)
+				left, up, prevChannel := rows.context(x, ch)
+				model.model(ch, left, up, prevChannel).Encode(e, zigzag(residual))
+				rows.cur[x*3+ch] = residual
+			}
+		}
+		rows.nextRow()
+	}
+}
+
+// decodeResidual adds the residual written by
+// encodeResidual to the pixels of img.
+func decodeResidual(d *entropy.Decoder, img *image.RGBA, maxError int) error {
+	b := img.Bounds()
+	model := newResidualModel()
+	rows := newResidualRows(b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			p := img.Pix[img.PixOffset(x+b.Min.X, y+b.Min.Y):]
+			for ch := 0; ch < 3; ch++ {
+				left, up, prevChannel := rows.context(x, ch)
+				z, err := model.model(ch, left, up, prevChannel).Decode(d)
+				if err != nil {
+					return err
+				}
+				residual := unzigzag(z)
+				p[ch] = applyResidual(p[ch], residual, maxError)
+				rows.cur[x*3+ch] = residual
+			}
+			p[3] = 0xff
+		}
+		rows.nextRow()
+	}
+	return nil
+}
+
+// quantizeResidual quantizes the difference between a
+// source value and a lossy value.
+//
+// For lossless coding, the difference is stored modulo
+// 256, which always fits in an int8.
+// Otherwise, it is divided into steps of 2*maxError+1,
+// which never leave more than maxError behind.
+func quantizeResidual(diff, maxError int) int8 {
+	if maxError == 0 {
+		return int8(diff)
+	}
+	step := 2*maxError + 1
+	if diff < 0 {
+		return -int8((-diff + maxError) / step)
+	}
+	return int8((diff + maxError) / step)
+}
+
+// applyResidual adds a quantized residual to a value.
+// Clamping can only bring near-lossless values closer to
+// the source value, which is between 0 and 255.
+func applyResidual(val uint8, residual int8, maxError int) uint8 {
+	if maxError == 0 {
+		return val + uint8(residual)
+	}
+	res := int(val) + int(residual)*(2*maxError+1)
+	if res < 0 {
+		return 0
+	} else if res > 0xff {
+		return 0xff
+	}
+	return uint8(res)
+}
+
+// zigzag maps small positive and negative residuals to
+// small bytes.
+func zigzag(x int8) byte {
+	return byte((x << 1) ^ (x >> 7))
+}
+
+func unzigzag(b byte) int8 {
+	return int8(b>>1) ^ -int8(b&1)
+}
+
+func abs8(x int8) int {
+	if x < 0 {
+		return -int(x)
+	}
+	return int(x)
+}
+
+// bucket quantizes a non-negative number logarithmically
+// into one of count buckets.
+func bucket(x, count int) int {
+	b := bits.Len(uint(x))
+	if b >= count {
+		return count - 1
+	}
+	return b
+}
@@ -1,16 +1,33 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"image"
+	"image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/unixpickle/imagecompress/archive"
+	"github.com/unixpickle/imagecompress/batch"
+	"github.com/unixpickle/imagecompress/lossless"
+	"github.com/unixpickle/imagecompress/metadata"
 	"github.com/unixpickle/imagecompress/pcaprune"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
+	"github.com/unixpickle/imagecompress/sequence"
 	"github.com/unixpickle/imagecompress/smallbasis"
+	"github.com/unixpickle/imagecompress/sparsecode"
+	"github.com/unixpickle/imagecompress/tiled"
+	"github.com/unixpickle/imagecompress/vq"
 )
 
 type Compressor interface {
@@ -20,6 +37,25 @@ type Compressor interface {
 
 type CompressorGen func(quality float64) Compressor
 
+// A CheckedCompressor reports invalid images or settings
+// as errors rather than panicking.
+type CheckedCompressor interface {
+	CompressChecked(i image.Image) ([]byte, error)
+}
+
+// A MapCompressor can spend more bits on the important
+// regions of an image.
+type MapCompressor interface {
+	CompressMap(i image.Image, m roi.Map) []byte
+	UsesQualityMap() bool
+}
+
+// A Thumbnailer can decode a compressed image at a
+// reduced size without fully decoding it.
+type Thumbnailer interface {
+	DecodeThumbnail(d []byte, scale int) (image.Image, error)
+}
+
 var Compressors = map[string]CompressorGen{
 	"smallbasis": func(q float64) Compressor {
 		return smallbasis.NewCompressor(q)
@@ -30,65 +66,361 @@ var Compressors = map[string]CompressorGen{
 	"pcaprune": func(q float64) Compressor {
 		return pcaprune.NewCompressor(q)
 	},
+	"pcaprune-fast": func(q float64) Compressor {
+		c := pcaprune.NewCompressor(q)
+		c.PowerIterations = 4
+		c.SampleSize = 20000
+		return c
+	},
+	"smallbasis-qt": func(q float64) Compressor {
+		c := smallbasis.NewCompressor(q)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		return c
+	},
+	"pcaprune-qt": func(q float64) Compressor {
+		c := pcaprune.NewCompressor(q)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		return c
+	},
+	"smallbasis-roi": func(q float64) Compressor {
+		c := smallbasis.NewCompressor(q)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		c.QualityMap = true
+		return c
+	},
+	"pcaprune-roi": func(q float64) Compressor {
+		c := pcaprune.NewCompressor(q)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		c.QualityMap = true
+		return c
+	},
+	"smallbasis-csf": func(q float64) Compressor {
+		c := smallbasis.NewCompressorBasis(q, 16, smallbasis.DCTBasis(16))
+		c.Weighting = smallbasis.CSFWeighting(smallbasis.DefaultPixelsPerDegree)
+		return c
+	},
+	"smallbasis-prog": func(q float64) Compressor {
+		c := smallbasis.NewCompressor(q)
+		c.Progressive = true
+		return c
+	},
+	"pcaprune-prog": func(q float64) Compressor {
+		c := pcaprune.NewCompressor(q)
+		c.Progressive = true
+		return c
+	},
+	"vq": func(q float64) Compressor {
+		return vq.NewCompressor(q)
+	},
+	"vq-residual": func(q float64) Compressor {
+		c := vq.NewCompressor(q)
+		c.ResidualBasis = smallbasis.BasisMatrix(vq.DefaultBlockSize * vq.DefaultBlockSize)
+		c.ResidualTable = quantize.Lookup(quantize.CoarseTableID)
+		return c
+	},
+	"sparsecode": func(q float64) Compressor {
+		return sparsecode.NewCompressor(q)
+	},
+}
+
+// errUsage is returned by commands whose arguments are
+// invalid, so that the usage is printed.
+var errUsage = errors.New("invalid usage")
+
+// A command is a subcommand of the CLI.
+type command struct {
+	Name string
+
+	// Usage describes the options and arguments.
+	Usage string
+
+	// Flags, if non-nil, registers the options of the
+	// command, which come before its arguments.
+	Flags func(fs *flag.FlagSet)
+
+	Run func(args []string) error
+}
+
+var commands = []*command{
+	{
+		Name:  "compress",
+		Usage: "[-orient] <compressor> <quality> <in.png> <out>",
+		Flags: orientFlag,
+		Run:   encodeCommand(compress),
+	},
+	{
+		Name:  "decompress",
+		Usage: "<compressor> <in> <out.png>",
+		Run:   decodeCommand(decompress),
+	},
+	{
+		Name:  "compress-striped",
+		Usage: "<compressor> <quality> <in.ppm> <out>",
+		Run:   encodeCommand(compressStriped),
+	},
+	{
+		Name:  "decompress-striped",
+		Usage: "<compressor> <in> <out.ppm>",
+		Run:   decodeCommand(decompressStriped),
+	},
+	{
+		Name:  "decompress-region",
+		Usage: "<tiled:compressor> <x0> <y0> <x1> <y1> <in> <out.png>",
+		Run:   decompressRegion,
+	},
+	{
+		Name:  "compress-roi",
+		Usage: "[-orient] <compressor> <quality> <mask.png> <in.png> <out>",
+		Flags: orientFlag,
+		Run:   compressROI,
+	},
+	{
+		Name:  "thumbnail",
+		Usage: "<compressor> <scale> <in> <out.png>",
+		Run:   thumbnail,
+	},
+	{
+		Name:  "compress-seq",
+		Usage: "<compressor> <quality> <out> <in.gif | in.png ...>",
+		Run:   compressSeq,
+	},
+	{
+		Name:  "decompress-seq",
+		Usage: "<compressor> <in> <out.gif | out.png>",
+		Run:   decompressSeq,
+	},
+	{
+		Name:  "compress-batch",
+		Usage: "<compressor> <quality> <out> <in.png> ...",
+		Run:   compressBatch,
+	},
+	{
+		Name:  "decompress-batch",
+		Usage: "<compressor> <in> <out.png>",
+		Run:   decompressBatch,
+	},
+	{
+		Name:  "pack",
+		Usage: "<compressor> <quality> <out.icar> <in.png> ...",
+		Run:   packArchive,
+	},
+	{
+		Name:  "unpack",
+		Usage: "<in.icar> <out dir> [name ...]",
+		Run:   unpackArchive,
+	},
+	{
+		Name:  "list",
+		Usage: "<in.icar>",
+		Run:   listArchive,
+	},
+	{
+		Name:  "verify",
+		Usage: "<in.icar | tiled:compressor in>",
+		Run:   verify,
+	},
+	{
+		Name:  "repair",
+		Usage: "<tiled:compressor> <in> <out.png>",
+		Run:   repair,
+	},
+	{
+		Name:  "train-dict",
+		Usage: "<block size> <atoms> <sparsity> <iterations> <out.dict> <in.png> ...",
+		Run:   trainDict,
+	},
 }
 
 func main() {
-	if len(os.Args) != 5 && len(os.Args) != 6 {
-		dieUsage()
+	if err := run(os.Args[1:]); err == errUsage {
+		printUsage()
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+}
 
-	compName := os.Args[2]
-	gen := Compressors[compName]
-	if gen == nil {
-		fmt.Fprintln(os.Stderr, "unknown compressor: ", compName)
-		os.Exit(1)
+// run runs the command named by the first argument.
+func run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	for _, c := range commands {
+		if c.Name != args[0] {
+			continue
+		}
+		fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		if c.Flags != nil {
+			c.Flags(fs)
+		}
+		if err := fs.Parse(args[1:]); err == flag.ErrHelp {
+			return errUsage
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", c.Name, err)
+		}
+		return c.Run(fs.Args())
 	}
+	return errUsage
+}
 
-	if os.Args[1] == "compress" {
-		if len(os.Args) != 6 {
-			dieUsage()
+// encodeCommand creates a command with the arguments
+// <compressor> <quality> <in> <out>.
+func encodeCommand(f func(c Compressor, inFile, outFile string) error) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 4 {
+			return errUsage
 		}
-		quality, err := strconv.ParseFloat(os.Args[3], 64)
-		if err != nil || quality < 0 || quality > 1 {
-			fmt.Fprintln(os.Stderr, "invalid quality: ", os.Args[3])
-			os.Exit(1)
+		gen, err := lookupCompressor(args[0])
+		if err != nil {
+			return err
 		}
-		if err := compress(gen(quality), os.Args[4], os.Args[5]); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+		quality, err := parseQuality(args[1])
+		if err != nil {
+			return err
 		}
-	} else if os.Args[1] == "decompress" {
-		if len(os.Args) != 5 {
-			dieUsage()
+		return f(gen(quality), args[2], args[3])
+	}
+}
+
+// decodeCommand creates a command with the arguments
+// <compressor> <in> <out>.
+func decodeCommand(f func(c Compressor, inFile, outFile string) error) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 3 {
+			return errUsage
 		}
-		if err := decompress(gen(0), os.Args[3], os.Args[4]); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+		gen, err := lookupCompressor(args[0])
+		if err != nil {
+			return err
 		}
-	} else {
-		dieUsage()
+		return f(gen(0), args[1], args[2])
 	}
 }
 
+// parseQuality parses a quality argument between 0 and 1.
+func parseQuality(arg string) (float64, error) {
+	quality, err := strconv.ParseFloat(arg, 64)
+	if err != nil || quality < 0 || quality > 1 {
+		return 0, errors.New("invalid quality: " + arg)
+	}
+	return quality, nil
+}
+
+// lookupCompressor finds a compressor by name.
+//
+// Besides the names in Compressors, this accepts names of
+// the form "sparsecode:path", which use a dictionary file
+// produced by train-dict, "tiled:name", which split
+// images into tiles compressed with another compressor,
+// and "lossless:name", which add a residual to another
+// compressor to store images exactly.
+func lookupCompressor(name string) (CompressorGen, error) {
+	if gen := Compressors[name]; gen != nil {
+		return gen, nil
+	}
+	if strings.HasPrefix(name, "tiled:") {
+		gen, err := lookupCompressor(strings.TrimPrefix(name, "tiled:"))
+		if err != nil {
+			return nil, err
+		}
+		return func(q float64) Compressor {
+			return tiled.NewCompressor(gen(q))
+		}, nil
+	}
+	if strings.HasPrefix(name, "lossless:") {
+		gen, err := lookupCompressor(strings.TrimPrefix(name, "lossless:"))
+		if err != nil {
+			return nil, err
+		}
+		return func(q float64) Compressor {
+			return lossless.NewCompressor(gen(q))
+		}, nil
+	}
+	if strings.HasPrefix(name, "sparsecode:") {
+		dict, _, err := sparsecode.LoadDictionary(strings.TrimPrefix(name, "sparsecode:"))
+		if err != nil {
+			return nil, err
+		}
+		blockSize := int(math.Sqrt(float64(dict.Dim())))
+		if blockSize*blockSize != dict.Dim() {
+			return nil, errors.New("dictionary atoms are not square blocks")
+		}
+		return func(q float64) Compressor {
+			sparsity := int(q*float64(dict.Dim()) + 0.5)
+			return sparsecode.NewCompressorDictionary(sparsity, blockSize, dict)
+		}, nil
+	}
+	return nil, errors.New("unknown compressor: " + name)
+}
+
+// orientImages is set by the -orient option.
+var orientImages bool
+
+func orientFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&orientImages, "orient", false,
+		"apply the EXIF orientation to the pixels rather than storing it")
+}
+
+// compress compresses an image along with its metadata.
 func compress(c Compressor, inFile, outFile string) error {
-	f, err := os.Open(inFile)
+	img, meta, err := readInputImage(inFile)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	img, _, err := image.Decode(f)
+	data, err := compressImage(c, img)
 	if err != nil {
 		return err
 	}
-	data := c.Compress(img)
-	return ioutil.WriteFile(outFile, data, 0755)
+	return writeCompressed(outFile, meta, data)
 }
 
+// readInputImage reads an image to compress along with its
+// metadata.
+//
+// If orientImages is set, the EXIF orientation is applied
+// to the pixels and then reset, so that the image needs no
+// further rotation.
+func readInputImage(file string) (image.Image, *metadata.Metadata, error) {
+	img, meta, err := readImageMetadata(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if orientImages && meta.EXIF != nil {
+		img = metadata.Orient(img, metadata.Orientation(meta.EXIF))
+		meta.EXIF = metadata.SetOrientation(meta.EXIF, 1)
+	}
+	return img, meta, nil
+}
+
+// writeCompressed writes a compressed image inside of a
+// container with its metadata, which every command that
+// reads single compressed images unwraps.
+func writeCompressed(file string, meta *metadata.Metadata, data []byte) error {
+	return ioutil.WriteFile(file, metadata.Wrap(meta, data), 0755)
+}
+
+// compressImage compresses an image, using
+// CompressChecked if the compressor supports it.
+func compressImage(c Compressor, img image.Image) ([]byte, error) {
+	if cc, ok := c.(CheckedCompressor); ok {
+		return cc.CompressChecked(img)
+	}
+	return c.Compress(img), nil
+}
+
+// decompress decodes an image and writes it as a PNG
+// file, including any metadata stored by compress.
 func decompress(c Compressor, inFile, outFile string) error {
 	data, err := ioutil.ReadFile(inFile)
 	if err != nil {
 		return err
 	}
+	meta, data, err := metadata.Unwrap(data)
+	if err != nil {
+		return err
+	}
 	img, err := c.Decompress(data)
 	if err != nil {
 		return err
@@ -100,16 +432,534 @@ func decompress(c Compressor, inFile, outFile string) error {
 	}
 	defer f.Close()
 
-	return png.Encode(f, img)
+	return metadata.EncodePNG(f, img, meta)
 }
 
-func dieUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s <compress> <compressor> <quality> <in.png> <out>\n"+
-		"       %s <decompress> <compressor> <in> <out.png>\n\n"+
+func decompressRegion(args []string) error {
+	if len(args) != 7 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	c, ok := gen(0).(*tiled.Compressor)
+	if !ok {
+		return errors.New("region decoding requires a tiled compressor")
+	}
+	var coords [4]int
+	for i := range coords {
+		coords[i], err = strconv.Atoi(args[i+1])
+		if err != nil {
+			return errors.New("invalid coordinate: " + args[i+1])
+		}
+	}
+
+	in, meta, data, err := openCompressed(args[5])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	img, err := c.DecodeRegion(data, image.Rect(coords[0], coords[1], coords[2], coords[3]))
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(args[6])
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return metadata.EncodePNG(out, img, meta)
+}
+
+// openCompressed opens a file written by compress for
+// random access, returning its metadata and a reader for
+// the compressed data inside of the metadata container.
+func openCompressed(file string) (*os.File, *metadata.Metadata, *io.SectionReader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	meta, data, err := metadata.UnwrapAt(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return f, meta, data, nil
+}
+
+func compressROI(args []string) error {
+	if len(args) != 5 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	quality, err := parseQuality(args[1])
+	if err != nil {
+		return err
+	}
+	c, ok := gen(quality).(MapCompressor)
+	if !ok || !c.UsesQualityMap() {
+		return errors.New("compressor does not support quality maps: " + args[0])
+	}
+
+	mask, err := readImage(args[2])
+	if err != nil {
+		return err
+	}
+	img, meta, err := readInputImage(args[3])
+	if err != nil {
+		return err
+	}
+	data := c.CompressMap(img, &roi.ImageMap{Image: mask})
+	return writeCompressed(args[4], meta, data)
+}
+
+func thumbnail(args []string) error {
+	if len(args) != 4 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	t, ok := gen(0).(Thumbnailer)
+	if !ok {
+		return errors.New("compressor does not support thumbnails: " + args[0])
+	}
+	scale, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errors.New("invalid scale: " + args[1])
+	}
+
+	data, err := ioutil.ReadFile(args[2])
+	if err != nil {
+		return err
+	}
+	meta, data, err := metadata.Unwrap(data)
+	if err != nil {
+		return err
+	}
+	img, err := t.DecodeThumbnail(data, scale)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(args[3])
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return metadata.EncodePNG(out, img, meta)
+}
+
+// printUsage prints the usage of every command and the
+// names of the compressors.
+func printUsage() {
+	for i, c := range commands {
+		prefix := "Usage:"
+		if i > 0 {
+			prefix = "      "
+		}
+		fmt.Fprintf(os.Stderr, "%s %s <%s> %s\n", prefix, os.Args[0], c.Name, c.Usage)
+	}
+	fmt.Fprint(os.Stderr, "\n"+
+		"Only compress and compress-roi keep the metadata (ICC profile, EXIF data,\n"+
+		"and text) of their input; sequences, batches, and archives drop it.\n"+
+		"With -orient, the mask of compress-roi must match the oriented image.\n"+
+		"Only archives and tiled images have checksums, so verify and repair locate\n"+
+		"damage by archive entry or by tile, not by row within a compressor's data.\n\n"+
 		"Compressors:\n"+
 		" smallbasis       algebraic basis pruning\n"+
 		" ortho16          prune a recursive orthogonal basis\n"+
-		" pcaprune         use PCA to reduce dimensionality\n",
-		os.Args[0], os.Args[0])
-	os.Exit(1)
+		" pcaprune         use PCA to reduce dimensionality\n"+
+		" pcaprune-fast    pcaprune with randomized PCA on sampled blocks\n"+
+		" smallbasis-qt    smallbasis with a quantization table\n"+
+		" pcaprune-qt      pcaprune with a quantization table\n"+
+		" smallbasis-roi   smallbasis-qt with per-block quality from saliency or a mask\n"+
+		" pcaprune-roi     pcaprune-qt with per-block quality from saliency or a mask\n"+
+		" smallbasis-csf   smallbasis with a DCT basis ranked by visibility\n"+
+		" smallbasis-prog  smallbasis with progressive coefficient order\n"+
+		" pcaprune-prog    pcaprune with progressive coefficient order\n"+
+		" vq               vector quantization with k-means\n"+
+		" vq-residual      vq with a quantized residual\n"+
+		" sparsecode       orthogonal matching pursuit on a dictionary\n"+
+		" sparsecode:<file> sparsecode with a dictionary from train-dict\n"+
+		" tiled:<name>     independently decodable tiles of another compressor\n"+
+		" lossless:<name>  another compressor plus an exact residual\n")
+}
+
+func compressSeq(args []string) error {
+	if len(args) < 4 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	quality, err := parseQuality(args[1])
+	if err != nil {
+		return err
+	}
+
+	var seq *sequence.Sequence
+	if len(args) == 4 && strings.HasSuffix(strings.ToLower(args[3]), ".gif") {
+		f, err := os.Open(args[3])
+		if err != nil {
+			return err
+		}
+		g, err := gif.DecodeAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		seq = sequence.FromGIF(g)
+	} else {
+		seq = &sequence.Sequence{}
+		for _, path := range args[3:] {
+			img, err := readImage(path)
+			if err != nil {
+				return err
+			}
+			seq.Frames = append(seq.Frames, img)
+		}
+	}
+
+	data, err := sequence.NewCompressor(gen(quality)).Compress(seq)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args[2], data, 0755)
+}
+
+// decompressSeq writes a decoded sequence as an animated
+// GIF, or as numbered PNG files (e.g. out-000.png) if the
+// output path does not end in ".gif".
+func decompressSeq(args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	seq, err := sequence.NewCompressor(gen(0)).Decompress(data)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(strings.ToLower(args[2]), ".gif") {
+		f, err := os.Create(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return gif.EncodeAll(f, seq.GIF())
+	}
+	return writeNumberedPNGs(strings.TrimSuffix(args[2], ".png"), seq.Frames)
+}
+
+func compressBatch(args []string) error {
+	if len(args) < 4 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	quality, err := parseQuality(args[1])
+	if err != nil {
+		return err
+	}
+	codec, ok := gen(quality).(batch.SharedCodec)
+	if !ok {
+		return errors.New("compressor does not support shared bases: " + args[0])
+	}
+
+	var images []image.Image
+	for _, path := range args[3:] {
+		img, err := readImage(path)
+		if err != nil {
+			return err
+		}
+		images = append(images, img)
+	}
+	data, err := batch.NewCompressor(codec).Compress(images)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args[2], data, 0755)
+}
+
+// decompressBatch writes the images of a batch as
+// numbered PNG files (e.g. out-000.png).
+func decompressBatch(args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	codec, ok := gen(0).(batch.SharedCodec)
+	if !ok {
+		return errors.New("compressor does not support shared bases: " + args[0])
+	}
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	images, err := batch.NewCompressor(codec).Decompress(data)
+	if err != nil {
+		return err
+	}
+	return writeNumberedPNGs(strings.TrimSuffix(args[2], ".png"), images)
+}
+
+func writeNumberedPNGs(prefix string, images []image.Image) error {
+	for i, img := range images {
+		f, err := os.Create(fmt.Sprintf("%s-%03d.png", prefix, i))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func packArchive(args []string) error {
+	if len(args) < 4 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	quality, err := parseQuality(args[1])
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(args[2])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := archive.NewWriter(f)
+	if err != nil {
+		return err
+	}
+	c := gen(quality)
+	for _, path := range args[3:] {
+		img, err := readImage(path)
+		if err != nil {
+			return err
+		}
+		data, err := compressImage(c, img)
+		if err != nil {
+			return err
+		}
+		if err := w.Add(filepath.Base(path), args[0], img.Bounds(), data); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// unpackArchive decodes the entries of an archive (or
+// only the named entries) as PNG files in a directory.
+func unpackArchive(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := archive.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	entries := r.Entries()
+	if len(args) > 2 {
+		entries = nil
+		for _, name := range args[2:] {
+			e := r.Lookup(name)
+			if e == nil {
+				return errors.New("no such entry: " + name)
+			}
+			entries = append(entries, e)
+		}
+	}
+	outNames := map[string]string{}
+	for _, e := range entries {
+		name := unpackName(e.Name)
+		if other, ok := outNames[name]; ok {
+			return fmt.Errorf("entries %s and %s would both be written to %s", other, e.Name,
+				name)
+		}
+		outNames[name] = e.Name
+	}
+	for _, e := range entries {
+		gen, err := lookupCompressor(e.Codec)
+		if err != nil {
+			return err
+		}
+		data, err := r.ReadData(e)
+		if err != nil {
+			return err
+		}
+		img, err := gen(0).Decompress(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode entry %s: %s", e.Name, err)
+		}
+
+		out, err := os.Create(filepath.Join(args[1], unpackName(e.Name)))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(out, img)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpackName finds the name of the PNG file that an
+// archive entry is unpacked to.
+//
+// Entry names come from the archive, so they must not be
+// allowed to escape the output directory.
+// The original extension is kept, so that a.jpg and a.png
+// are unpacked to different files.
+func unpackName(entryName string) string {
+	name := filepath.Base(entryName)
+	if strings.ToLower(filepath.Ext(name)) != ".png" {
+		name += ".png"
+	}
+	return name
+}
+
+func listArchive(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := archive.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, e := range r.Entries() {
+		fmt.Printf("%s\t%s\t%dx%d\t%d bytes\n", e.Name, e.Codec, e.Width, e.Height, e.Length)
+	}
+	return nil
+}
+
+// verify checks the checksums of an archive or a tiled
+// image, printing the damaged entries or regions.
+// The codecs' own formats have no checksums, so they
+// cannot be verified on their own.
+func verify(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errUsage
+	}
+	var damaged []string
+	if len(args) == 1 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		r, err := archive.NewReader(f, info.Size())
+		if err != nil {
+			return err
+		}
+		for _, e := range r.Verify() {
+			damaged = append(damaged, e.Name)
+		}
+	} else {
+		gen, err := lookupCompressor(args[0])
+		if err != nil {
+			return err
+		}
+		c, ok := gen(0).(*tiled.Compressor)
+		if !ok {
+			return errors.New("verification requires a tiled compressor")
+		}
+		f, _, data, err := openCompressed(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rects, err := c.Verify(data)
+		if err != nil {
+			return err
+		}
+		for _, r := range rects {
+			damaged = append(damaged, fmt.Sprintf("%d %d %d %d", r.Min.X, r.Min.Y,
+				r.Max.X, r.Max.Y))
+		}
+	}
+
+	for _, d := range damaged {
+		fmt.Println("damaged:", d)
+	}
+	if len(damaged) > 0 {
+		return fmt.Errorf("found %d damaged regions", len(damaged))
+	}
+	return nil
+}
+
+// repair decodes a tiled image, filling damaged tiles
+// from their neighbors.
+func repair(args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+	gen, err := lookupCompressor(args[0])
+	if err != nil {
+		return err
+	}
+	c, ok := gen(0).(*tiled.Compressor)
+	if !ok {
+		return errors.New("repair requires a tiled compressor")
+	}
+	c.BestEffort = true
+	return decompress(c, args[1], args[2])
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/metadata"
+)
+
+// testJPEG is a 40x24 JPEG file with EXIF orientation 6,
+// as phone cameras produce.
+//
+// It is a file rather than being encoded by the test, so
+// that the test does not register the JPEG format itself.
+const testJPEG = "testdata/orient6.jpg"
+
+// readPNG reads a PNG file along with its metadata.
+func readPNG(t *testing.T, path string) (image.Image, *metadata.Metadata) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := metadata.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, meta
+}
+
+func TestCompressJPEG(t *testing.T) {
+	dir := t.TempDir()
+	in := testJPEG
+	compressed := filepath.Join(dir, "out.sb")
+	out := filepath.Join(dir, "out.png")
+
+	for _, orient := range []bool{false, true} {
+		args := []string{"compress", "smallbasis-qt", "0.5", in, compressed}
+		if orient {
+			args = []string{"compress", "-orient", "smallbasis-qt", "0.5", in, compressed}
+		}
+		if err := run(args); err != nil {
+			t.Fatal(err)
+		}
+		if err := run([]string{"decompress", "smallbasis-qt", compressed, out}); err != nil {
+			t.Fatal(err)
+		}
+		img, meta := readPNG(t, out)
+		size, orientation := image.Pt(40, 24), 6
+		if orient {
+			size, orientation = image.Pt(24, 40), 1
+		}
+		if img.Bounds().Size() != size {
+			t.Errorf("orient=%v: expected size %v but got %v", orient, size,
+				img.Bounds().Size())
+		}
+		if o := metadata.Orientation(meta.EXIF); o != orientation {
+			t.Errorf("orient=%v: expected orientation %d but got %d", orient, orientation, o)
+		}
+	}
+}
+
+func TestCompressROIMetadata(t *testing.T) {
+	dir := t.TempDir()
+	in := testJPEG
+	mask := filepath.Join(dir, "mask.png")
+	compressed := filepath.Join(dir, "out.sb")
+	out := filepath.Join(dir, "out.png")
+	f, err := os.Create(mask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewGray(image.Rect(0, 0, 40, 24)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = run([]string{"compress-roi", "smallbasis-roi", "0.5", mask, in, compressed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"decompress", "smallbasis-roi", compressed, out}); err != nil {
+		t.Fatal(err)
+	}
+	_, meta := readPNG(t, out)
+	if o := metadata.Orientation(meta.EXIF); o != 6 {
+		t.Errorf("expected orientation 6 but got %d", o)
+	}
+
+	// These compressors would ignore the mask.
+	for _, name := range []string{"smallbasis", "smallbasis-qt", "pcaprune", "vq"} {
+		if err := run([]string{"compress-roi", name, "0.5", mask, in, compressed}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"compress", "smallbasis"},
+		{"decompress", "-h"},
+	} {
+		if err := run(args); err != errUsage {
+			t.Errorf("%v: expected usage error but got %v", args, err)
+		}
+	}
+	if err := run([]string{"compress", "-unknown", "smallbasis", "0.5", "a", "b"}); err == nil {
+		t.Error("expected error for unknown flag")
+	}
+}
+
+func TestTrainDictCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	dict := filepath.Join(dir, "out.dict")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, testutil.Image(32, 32))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run([]string{"train-dict", "4", "8", "2", "1", dict, in}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"train-dict", "4", "16", "2", "2", dict, in}); err == nil {
+		t.Error("expected error for a checkpoint with a different atom count")
+	}
+	if err := run([]string{"train-dict", "8", "8", "2", "2", dict, in}); err == nil {
+		t.Error("expected error for a checkpoint with a different block size")
+	}
+	if err := run([]string{"train-dict", "4", "8", "2", "2", dict, in}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,179 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"time"
+)
+
+const (
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagDateTimeOriginal = 0x9003
+
+	typeShort = 3
+	typeLong  = 4
+
+	// exifTimeLayout is the layout of EXIF dates and times.
+	exifTimeLayout = "2006:01:02 15:04:05"
+)
+
+// Orientation returns the EXIF orientation (from 1 to 8)
+// of an image, or 1 if the EXIF data has none.
+func Orientation(exif []byte) int {
+	t, ok := parseTIFF(exif)
+	if !ok {
+		return 1
+	}
+	entry, ok := t.findTag(t.ifd0, tagOrientation)
+	if !ok || t.order.Uint16(exif[entry+2:]) != typeShort {
+		return 1
+	}
+	o := int(t.order.Uint16(exif[entry+8:]))
+	if o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// SetOrientation returns a copy of the EXIF data with a
+// new orientation, or the data itself if it has no
+// orientation to change.
+func SetOrientation(exif []byte, orientation int) []byte {
+	t, ok := parseTIFF(exif)
+	if !ok {
+		return exif
+	}
+	entry, ok := t.findTag(t.ifd0, tagOrientation)
+	if !ok || t.order.Uint16(exif[entry+2:]) != typeShort {
+		return exif
+	}
+	res := append([]byte{}, exif...)
+	t.order.PutUint16(res[entry+8:], uint16(orientation))
+	return res
+}
+
+// CaptureTime returns the time an image was taken,
+// according to its EXIF data.
+//
+// EXIF times have no time zone, so the result is in UTC.
+func CaptureTime(exif []byte) (time.Time, bool) {
+	t, ok := parseTIFF(exif)
+	if !ok {
+		return time.Time{}, false
+	}
+	if entry, ok := t.findTag(t.ifd0, tagExifIFD); ok {
+		if t.order.Uint16(exif[entry+2:]) == typeLong {
+			sub := t.order.Uint32(exif[entry+8:])
+			if res, ok := t.timeTag(sub, tagDateTimeOriginal); ok {
+				return res, true
+			}
+		}
+	}
+	return t.timeTag(t.ifd0, tagDateTime)
+}
+
+// Orient transforms an image according to an EXIF
+// orientation, so that it displays correctly without the
+// orientation.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// tiffData is EXIF data with a valid TIFF header.
+type tiffData struct {
+	data  []byte
+	order binary.ByteOrder
+	ifd0  uint32
+}
+
+func parseTIFF(data []byte) (*tiffData, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	return &tiffData{data: data, order: order, ifd0: order.Uint32(data[4:])}, true
+}
+
+// findTag finds the 12-byte entry for a tag in an IFD,
+// returning the offset of the entry.
+func (t *tiffData) findTag(ifd uint32, tag uint16) (int, bool) {
+	if uint64(ifd)+2 > uint64(len(t.data)) {
+		return 0, false
+	}
+	count := int(t.order.Uint16(t.data[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := int(ifd) + 2 + 12*i
+		if entry+12 > len(t.data) {
+			return 0, false
+		}
+		if t.order.Uint16(t.data[entry:]) == tag {
+			return entry, true
+		}
+	}
+	return 0, false
+}
+
+// timeTag reads an ASCII date and time tag.
+func (t *tiffData) timeTag(ifd uint32, tag uint16) (time.Time, bool) {
+	entry, ok := t.findTag(ifd, tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	count := t.order.Uint32(t.data[entry+4:])
+	if count < uint32(len(exifTimeLayout)) || count > 64 {
+		return time.Time{}, false
+	}
+	offset := t.order.Uint32(t.data[entry+8:])
+	if uint64(offset)+uint64(len(exifTimeLayout)) > uint64(len(t.data)) {
+		return time.Time{}, false
+	}
+	text := string(t.data[offset : int(offset)+len(exifTimeLayout)])
+	res, err := time.Parse(exifTimeLayout, text)
+	return res, err == nil
+}
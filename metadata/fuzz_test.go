@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// testMetadata is metadata with every kind of field.
+func testMetadata() *Metadata {
+	return &Metadata{
+		ICCProfile: []byte("fake ICC profile"),
+		EXIF:       testEXIF(6),
+		Text:       []Text{{Key: "Comment", Value: "hello"}},
+	}
+}
+
+// testEXIF creates little-endian EXIF data with nothing
+// but an orientation.
+func testEXIF(orientation int) []byte {
+	res := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12+4)
+	binary.LittleEndian.PutUint16(entry, tagOrientation)
+	binary.LittleEndian.PutUint16(entry[2:], typeShort)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+	return append(res, entry...)
+}
+
+// encodeJPEG encodes a small JPEG with APP1, APP2, and
+// comment segments for the metadata, splitting the ICC
+// profile in two to check that its chunks are joined.
+func encodeJPEG(t testing.TB, m *Metadata) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 3, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, body []byte) {
+		segments.Write([]byte{0xff, marker})
+		binary.Write(&segments, binary.BigEndian, uint16(len(body)+2))
+		segments.Write(body)
+	}
+	if m.EXIF != nil {
+		writeSegment(0xe1, append([]byte(exifPrefix), m.EXIF...))
+	}
+	if m.ICCProfile != nil {
+		half := len(m.ICCProfile) / 2
+		// The chunks are out of order on purpose.
+		writeSegment(0xe2, append([]byte(iccPrefix+"\x02\x02"), m.ICCProfile[half:]...))
+		writeSegment(0xe2, append([]byte(iccPrefix+"\x01\x02"), m.ICCProfile[:half]...))
+	}
+	for _, text := range m.Text {
+		writeSegment(0xfe, []byte(text.Value))
+	}
+	return append(append(append([]byte{}, encoded[:2]...), segments.Bytes()...), encoded[2:]...)
+}
+
+// encodePNG encodes a small PNG with metadata.
+func encodePNG(t testing.TB, m *Metadata) []byte {
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, image.NewGray(image.Rect(0, 0, 3, 2)), m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func FuzzUnwrap(f *testing.F) {
+	f.Add(Wrap(testMetadata(), []byte("image data")))
+	f.Add(Wrap(&Metadata{EXIF: []byte{}}, nil))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, body, err := Unwrap(data)
+		if err != nil || m.Empty() {
+			return
+		}
+		m1, body1, err := Unwrap(Wrap(m, body))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(body, body1) {
+			t.Fatal("data changed after wrapping again")
+		} else if !reflect.DeepEqual(m, m1) {
+			t.Fatal("metadata changed after wrapping again")
+		}
+	})
+}
+
+func FuzzRead(f *testing.F) {
+	f.Add(encodeJPEG(f, testMetadata()))
+	f.Add(encodePNG(f, testMetadata()))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := Read(data)
+		if err != nil {
+			return
+		}
+		if len(m.ICCProfile) > maxICCProfileSize {
+			t.Fatalf("ICC profile is too large: %d bytes", len(m.ICCProfile))
+		}
+		if o := Orientation(m.EXIF); o < 1 || o > 8 {
+			t.Fatalf("invalid orientation: %d", o)
+		}
+		CaptureTime(m.EXIF)
+		if _, _, err := Unwrap(Wrap(m, nil)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
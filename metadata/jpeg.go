@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+const (
+	exifPrefix = "Exif\x00\x00"
+	iccPrefix  = "ICC_PROFILE\x00"
+)
+
+// readJPEG extracts the EXIF data (APP1), ICC profile
+// (APP2), and comments of a JPEG file.
+func readJPEG(data []byte) (*Metadata, error) {
+	res := &Metadata{}
+	type iccChunk struct {
+		Index int
+		Data  []byte
+	}
+	var iccChunks []iccChunk
+
+	d := data[2:]
+	for len(d) >= 2 {
+		if d[0] != 0xff {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := d[1]
+		if marker == 0xff {
+			// Fill bytes may precede a marker.
+			d = d[1:]
+			continue
+		}
+		d = d[2:]
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			continue
+		} else if marker == 0xd9 || marker == 0xda {
+			// The metadata precedes the image data.
+			break
+		}
+		if len(d) < 2 {
+			return nil, errors.New("invalid JPEG segment")
+		}
+		length := int(binary.BigEndian.Uint16(d))
+		if length < 2 || length > len(d) {
+			return nil, errors.New("invalid JPEG segment")
+		}
+		body := d[2:length]
+		d = d[length:]
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(body, []byte(exifPrefix)) && res.EXIF == nil:
+			res.EXIF = append([]byte{}, body[len(exifPrefix):]...)
+		case marker == 0xe2 && bytes.HasPrefix(body, []byte(iccPrefix)):
+			body = body[len(iccPrefix):]
+			if len(body) < 2 {
+				return nil, errors.New("invalid ICC profile segment")
+			}
+			iccChunks = append(iccChunks, iccChunk{Index: int(body[0]), Data: body[2:]})
+		case marker == 0xfe:
+			res.Text = append(res.Text, Text{Key: "Comment", Value: string(body)})
+		}
+	}
+
+	if len(iccChunks) > 0 {
+		// Large profiles are split across several segments,
+		// which are numbered starting at 1.
+		sort.SliceStable(iccChunks, func(i, j int) bool {
+			return iccChunks[i].Index < iccChunks[j].Index
+		})
+		res.ICCProfile = []byte{}
+		for _, c := range iccChunks {
+			res.ICCProfile = append(res.ICCProfile, c.Data...)
+		}
+	}
+	return res, nil
+}
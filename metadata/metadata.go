@@ -0,0 +1,151 @@
+// Package metadata carries the ICC profile, EXIF data, and
+// text of an image alongside its compressed pixels.
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const fileMagic = "ICMD"
+
+// Chunk types of the container written by Wrap.
+const (
+	chunkICC  = "ICCP"
+	chunkEXIF = "EXIF"
+	chunkText = "TEXT"
+	chunkData = "DATA"
+)
+
+var encodingEndian = binary.LittleEndian
+
+// A Text is a key/value pair, such as a PNG tEXt chunk or
+// a JPEG comment.
+type Text struct {
+	Key   string
+	Value string
+}
+
+// Metadata is the non-pixel information of an image.
+type Metadata struct {
+	// ICCProfile is the raw (uncompressed) ICC profile.
+	ICCProfile []byte
+
+	// EXIF is the EXIF data in TIFF format, as stored in a
+	// PNG eXIf chunk (without the "Exif\0\0" prefix used
+	// by JPEG files).
+	EXIF []byte
+
+	Text []Text
+}
+
+// Empty returns whether there is no metadata at all.
+func (m *Metadata) Empty() bool {
+	return m == nil || (m.ICCProfile == nil && m.EXIF == nil && len(m.Text) == 0)
+}
+
+// Wrap stores metadata in a container with some
+// compressed image data.
+// If the metadata is empty, the data is returned as is.
+func Wrap(m *Metadata, data []byte) []byte {
+	if m.Empty() {
+		return data
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	if m.ICCProfile != nil {
+		writeChunk(&buf, chunkICC, m.ICCProfile)
+	}
+	if m.EXIF != nil {
+		writeChunk(&buf, chunkEXIF, m.EXIF)
+	}
+	for _, t := range m.Text {
+		writeChunk(&buf, chunkText, []byte(t.Key+"\x00"+t.Value))
+	}
+	writeChunk(&buf, chunkData, data)
+	return buf.Bytes()
+}
+
+// Unwrap performs the inverse of Wrap, returning the
+// metadata and the compressed image data.
+//
+// Data without a container is returned as is, with nil
+// metadata.
+func Unwrap(d []byte) (*Metadata, []byte, error) {
+	m, data, err := UnwrapAt(bytes.NewReader(d), int64(len(d)))
+	if err != nil {
+		return nil, nil, err
+	}
+	_, offset, length := data.Outer()
+	return m, d[offset : offset+length], nil
+}
+
+// UnwrapAt is like Unwrap, but it reads a container of
+// the given size from r, and it returns a reader for the
+// compressed image data rather than reading it.
+//
+// This way, images that can be decoded a region at a
+// time, such as tiled images, need not be read in full.
+func UnwrapAt(r io.ReaderAt, size int64) (*Metadata, *io.SectionReader, error) {
+	magic := make([]byte, len(fileMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil || string(magic) != fileMagic {
+		return nil, io.NewSectionReader(r, 0, size), nil
+	}
+	offset := int64(len(fileMagic))
+	res := &Metadata{}
+	for {
+		var header [8]byte
+		if _, err := r.ReadAt(header[:], offset); err != nil || size-offset < 8 {
+			return nil, nil, errors.New("failed to read metadata chunk: unexpected EOF")
+		}
+		kind := string(header[:4])
+		length := int64(encodingEndian.Uint32(header[4:]))
+		offset += 8
+		if length > size-offset {
+			return nil, nil, errors.New("failed to read metadata chunk: unexpected EOF")
+		}
+		if kind == chunkData {
+			return res, io.NewSectionReader(r, offset, length), nil
+		}
+		body := make([]byte, length)
+		if _, err := r.ReadAt(body, offset); err != nil {
+			return nil, nil, errors.New("failed to read metadata chunk: " + err.Error())
+		}
+		offset += length
+		switch kind {
+		case chunkICC:
+			res.ICCProfile = body
+		case chunkEXIF:
+			res.EXIF = body
+		case chunkText:
+			idx := bytes.IndexByte(body, 0)
+			if idx < 0 {
+				return nil, nil, errors.New("invalid text chunk")
+			}
+			res.Text = append(res.Text, Text{Key: string(body[:idx]), Value: string(body[idx+1:])})
+		default:
+			// Unknown chunks are skipped, so that new kinds of
+			// metadata can be added later.
+		}
+	}
+}
+
+// Read extracts the metadata of an encoded JPEG or PNG
+// file.
+// Other formats yield empty metadata.
+func Read(data []byte) (*Metadata, error) {
+	if bytes.HasPrefix(data, []byte(pngSignature)) {
+		return readPNG(data)
+	} else if bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return readJPEG(data)
+	}
+	return &Metadata{}, nil
+}
+
+func writeChunk(buf *bytes.Buffer, kind string, body []byte) {
+	buf.WriteString(kind)
+	binary.Write(buf, encodingEndian, uint32(len(body)))
+	buf.Write(body)
+}
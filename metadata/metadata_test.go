@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestReadJPEG(t *testing.T) {
+	expected := testMetadata()
+	actual, err := Read(encodeJPEG(t, expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+	if o := Orientation(actual.EXIF); o != 6 {
+		t.Errorf("expected orientation 6 but got %d", o)
+	}
+}
+
+func TestReadPNG(t *testing.T) {
+	expected := testMetadata()
+	data := encodePNG(t, expected)
+	actual, err := Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+
+	empty, err := Read(encodePNG(t, &Metadata{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !empty.Empty() {
+		t.Errorf("unexpected metadata: %+v", empty)
+	}
+}
+
+func TestReadOther(t *testing.T) {
+	m, err := Read([]byte("GIF89a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Empty() {
+		t.Errorf("unexpected metadata: %+v", m)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// The corners of a 3x2 image are labeled A (top left),
+	// B (top right), C (bottom left), and D (bottom right).
+	a := color.RGBA{1, 0, 0, 0xff}
+	b := color.RGBA{2, 0, 0, 0xff}
+	c := color.RGBA{3, 0, 0, 0xff}
+	d := color.RGBA{4, 0, 0, 0xff}
+	img := image.NewRGBA(image.Rect(5, 5, 8, 7))
+	img.Set(5, 5, a)
+	img.Set(7, 5, b)
+	img.Set(5, 6, c)
+	img.Set(7, 6, d)
+
+	// The expected corners after orienting, in the same
+	// order as the labels above.
+	expected := map[int][4]color.RGBA{
+		1: {a, b, c, d},
+		2: {b, a, d, c},
+		3: {d, c, b, a},
+		4: {c, d, a, b},
+		5: {a, c, b, d},
+		6: {c, a, d, b},
+		7: {d, b, c, a},
+		8: {b, d, a, c},
+	}
+	for orientation := 1; orientation <= 8; orientation++ {
+		res := Orient(img, orientation)
+		size := res.Bounds().Size()
+		if orientation >= 5 {
+			if size != image.Pt(2, 3) {
+				t.Errorf("orientation %d: unexpected size %v", orientation, size)
+				continue
+			}
+		} else if size != image.Pt(3, 2) {
+			t.Errorf("orientation %d: unexpected size %v", orientation, size)
+			continue
+		}
+		min, max := res.Bounds().Min, res.Bounds().Max.Sub(image.Pt(1, 1))
+		corners := [4]color.RGBA{
+			color.RGBAModel.Convert(res.At(min.X, min.Y)).(color.RGBA),
+			color.RGBAModel.Convert(res.At(max.X, min.Y)).(color.RGBA),
+			color.RGBAModel.Convert(res.At(min.X, max.Y)).(color.RGBA),
+			color.RGBAModel.Convert(res.At(max.X, max.Y)).(color.RGBA),
+		}
+		if corners != expected[orientation] {
+			t.Errorf("orientation %d: expected corners %v but got %v", orientation,
+				expected[orientation], corners)
+		}
+	}
+}
+
+func TestSetOrientation(t *testing.T) {
+	exif := testEXIF(6)
+	changed := SetOrientation(exif, 1)
+	if o := Orientation(changed); o != 1 {
+		t.Errorf("expected orientation 1 but got %d", o)
+	}
+	if o := Orientation(exif); o != 6 {
+		t.Errorf("original data was modified")
+	}
+	if o := Orientation(nil); o != 1 {
+		t.Errorf("expected orientation 1 without EXIF but got %d", o)
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	data := []byte("image data")
+	for _, m := range []*Metadata{testMetadata(), {EXIF: []byte{}}, {Text: []Text{{}}}} {
+		wrapped := Wrap(m, data)
+		actual, actualData, err := Unwrap(wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, m) {
+			t.Errorf("expected %+v but got %+v", m, actual)
+		}
+		if !bytes.Equal(actualData, data) {
+			t.Errorf("expected data %q but got %q", data, actualData)
+		}
+		// Shorter prefixes are not containers at all.
+		for i := len(fileMagic); i < len(wrapped); i++ {
+			if _, _, err := Unwrap(wrapped[:i]); err == nil {
+				t.Errorf("expected error for %d of %d bytes", i, len(wrapped))
+				break
+			}
+		}
+	}
+
+	if wrapped := Wrap(&Metadata{}, data); !bytes.Equal(wrapped, data) {
+		t.Error("empty metadata should not be wrapped")
+	}
+	m, actualData, err := Unwrap(data)
+	if err != nil {
+		t.Fatal(err)
+	} else if m != nil || !bytes.Equal(actualData, data) {
+		t.Error("unwrapped data should be returned as is")
+	}
+}
+
+func TestUnwrapAt(t *testing.T) {
+	data := []byte("image data")
+	wrapped := Wrap(testMetadata(), data)
+	m, r, err := UnwrapAt(bytes.NewReader(wrapped), int64(len(wrapped)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, testMetadata()) {
+		t.Errorf("expected %+v but got %+v", testMetadata(), m)
+	}
+	actualData := make([]byte, r.Size())
+	if _, err := r.ReadAt(actualData, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actualData, data) {
+		t.Errorf("expected data %q but got %q", data, actualData)
+	}
+
+	m, r, err = UnwrapAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	} else if m != nil || r.Size() != int64(len(data)) {
+		t.Error("unwrapped data should be returned as is")
+	}
+}
@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// maxICCProfileSize limits the decompressed size of an
+// iCCP chunk.
+const maxICCProfileSize = 1 << 24
+
+// iccProfileName is the profile name written to iCCP
+// chunks, which PNG requires but nothing uses.
+const iccProfileName = "ICC profile"
+
+// EncodePNG encodes an image as a PNG file, including the
+// metadata as iCCP, eXIf, and tEXt chunks.
+func EncodePNG(w io.Writer, img image.Image, m *Metadata) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	if m.Empty() {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	// The metadata chunks go right after IHDR, which is
+	// always the first chunk, since iCCP must precede the
+	// image data.
+	encoded := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 8 + int(binary.BigEndian.Uint32(encoded[8:])) + 4
+	var chunks bytes.Buffer
+	if m.ICCProfile != nil {
+		var profile bytes.Buffer
+		profile.WriteString(iccProfileName + "\x00\x00")
+		zw := zlib.NewWriter(&profile)
+		zw.Write(m.ICCProfile)
+		zw.Close()
+		writePNGChunk(&chunks, "iCCP", profile.Bytes())
+	}
+	if m.EXIF != nil {
+		writePNGChunk(&chunks, "eXIf", m.EXIF)
+	}
+	for _, t := range m.Text {
+		if len(t.Key) < 1 || len(t.Key) > 79 || bytes.IndexByte([]byte(t.Key), 0) >= 0 {
+			return errors.New("invalid PNG text key: " + t.Key)
+		}
+		writePNGChunk(&chunks, "tEXt", []byte(t.Key+"\x00"+t.Value))
+	}
+
+	for _, part := range [][]byte{encoded[:ihdrEnd], chunks.Bytes(), encoded[ihdrEnd:]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPNG extracts the iCCP, eXIf, and tEXt chunks of a
+// PNG file.
+func readPNG(data []byte) (*Metadata, error) {
+	res := &Metadata{}
+	d := data[len(pngSignature):]
+	for len(d) >= 12 {
+		length := binary.BigEndian.Uint32(d)
+		kind := string(d[4:8])
+		if uint64(length)+12 > uint64(len(d)) {
+			return nil, errors.New("invalid PNG chunk")
+		}
+		body := d[8 : 8+length]
+		d = d[12+length:]
+
+		switch kind {
+		case "iCCP":
+			idx := bytes.IndexByte(body, 0)
+			if idx < 0 || idx+2 > len(body) {
+				return nil, errors.New("invalid iCCP chunk")
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(body[idx+2:]))
+			if err != nil {
+				return nil, errors.New("invalid iCCP chunk: " + err.Error())
+			}
+			profile, err := ioutil.ReadAll(io.LimitReader(zr, maxICCProfileSize+1))
+			if err != nil {
+				return nil, errors.New("invalid iCCP chunk: " + err.Error())
+			} else if len(profile) > maxICCProfileSize {
+				return nil, errors.New("ICC profile is too large")
+			}
+			res.ICCProfile = profile
+		case "eXIf":
+			res.EXIF = append([]byte{}, body...)
+		case "tEXt":
+			idx := bytes.IndexByte(body, 0)
+			if idx < 0 {
+				return nil, errors.New("invalid tEXt chunk")
+			}
+			res.Text = append(res.Text, Text{Key: string(body[:idx]), Value: string(body[idx+1:])})
+		case "IEND":
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, body []byte) {
+	binary.Write(w, binary.BigEndian, uint32(len(body)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(body)
+	w.WriteString(kind)
+	w.Write(body)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
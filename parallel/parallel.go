@@ -0,0 +1,56 @@
+// Package parallel runs independent pieces of work on
+// a pool of goroutines.
+package parallel
+
+import (
+	"runtime"
+	"sync"
+)
+
+// For calls f(i) for every i in [0, n), using up to
+// concurrency goroutines at once.
+// If concurrency is 0 or negative, runtime.GOMAXPROCS(0)
+// goroutines are used.
+//
+// Calls to f may happen in any order, so f should only
+// write to state that belongs to index i.
+// For returns once every call has finished.
+func For(n, concurrency int, f func(i int)) {
+	concurrency = Workers(concurrency)
+	if concurrency > n {
+		concurrency = n
+	}
+	if concurrency <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	indices := make(chan int, concurrency)
+	var wg sync.WaitGroup
+	for j := 0; j < concurrency; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+}
+
+// Workers returns the number of goroutines that For
+// uses for a concurrency setting (ignoring the amount
+// of work).
+func Workers(concurrency int) int {
+	if concurrency <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return concurrency
+}
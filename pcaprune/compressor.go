@@ -4,10 +4,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"image"
+	"io"
 	"math"
 
 	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
 	"github.com/unixpickle/num-analysis/linalg"
 )
 
@@ -17,6 +23,70 @@ const DefaultBlockSize = 8
 // the least significant principle components of small
 // blocks in an image.
 type Compressor struct {
+	// Quantizer, if non-nil, specifies a quantization table
+	// to use for the principal component coefficients.
+	// Component i uses the step for basis index i, so the
+	// steps should typically increase with the index.
+	//
+	// The table itself is stored in (or referenced by) the
+	// compressed data, along with a flag saying that the
+	// data is quantized, so decoding does not depend on
+	// Quantizer.
+	Quantizer *quantize.Table
+
+	// Lambda controls rate-distortion optimized quantization
+	// when Quantizer is set.
+	// Larger values trade more squared pixel error (with
+	// pixel values between 0 and 1) for each saved bit.
+	// If Lambda is 0, coefficients are simply rounded.
+	Lambda float64
+
+	// Concurrency is the maximum number of goroutines used
+	// to process blocks.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	// The compressed data does not depend on Concurrency.
+	Concurrency int
+
+	// PowerIterations, if non-zero, makes the Compressor
+	// find only the principal components it keeps, using
+	// randomized block power iteration instead of a full
+	// eigendecomposition.
+	// More iterations are slower but more accurate; a few
+	// iterations are typically enough.
+	PowerIterations int
+
+	// SampleSize, if non-zero, is the maximum number of
+	// randomly chosen blocks used to find the principal
+	// components of an image.
+	SampleSize int
+
+	// Progressive, if true, stores the coefficients of the
+	// first principal component for every block, followed
+	// by those of the second component, etc.
+	// Each component's coefficients form a layer, and
+	// Decompress can render a coarse preview from data that
+	// is truncated after any layer (past the header).
+	// The data is flagged as progressive, and a Compressor
+	// must have Progressive set to decode it.
+	Progressive bool
+
+	// QualityMap, if true, gives every block a quality
+	// level that scales the Quantizer's steps for all of
+	// the block's components.
+	// Important blocks get finer levels: CompressMap takes
+	// the importance from a roi.Map, and Compress uses
+	// roi.Saliency.
+	//
+	// Levels are only stored for non-progressive quantized
+	// data, and CompressStriped rejects QualityMap.
+	// A flag in the data marks leveled images, so decoding
+	// does not depend on QualityMap.
+	QualityMap bool
+
+	// DecodeOptions limits the resources used to decode
+	// images, which is important for untrusted data.
+	DecodeOptions limits.DecodeOptions
+
 	basisSize int
 	blockSize int
 }
@@ -39,23 +109,85 @@ func NewCompressorBlockSize(quality float64, blockSize int) *Compressor {
 	return &Compressor{basisSize: basisSize, blockSize: blockSize}
 }
 
+// NewCompressorBlockSizeChecked is like
+// NewCompressorBlockSize, but it returns an error if the
+// arguments are invalid.
+func NewCompressorBlockSizeChecked(quality float64, blockSize int) (*Compressor, error) {
+	if blockSize < 1 {
+		return nil, errors.New("block size must be positive")
+	} else if !(quality >= 0 && quality <= 1) {
+		return nil, errors.New("quality must be between 0 and 1")
+	}
+	return NewCompressorBlockSize(quality, blockSize), nil
+}
+
 // Compress compresses an image and returns a binary
 // encoding of the result.
 func (c *Compressor) Compress(i image.Image) []byte {
+	if c.leveled() {
+		return c.CompressMap(i, roi.Saliency(i, c.blockSize))
+	}
+	return c.CompressMap(i, nil)
+}
+
+// CompressMap is like Compress, but if c.QualityMap
+// applies, it quantizes blocks more finely in the more
+// important regions of m.
+// A nil Map gives every block the default level.
+func (c *Compressor) CompressMap(i image.Image, m roi.Map) []byte {
 	var w bytes.Buffer
 	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dx()))
 	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dy()))
 
 	imageBlocks := blocker.Blocks(i, c.blockSize)
-	reducer := newPCAReducer(imageBlocks, c.basisSize)
+	reducer := c.trainReducer(imageBlocks)
 
 	reducer.WriteTo(&w)
+	c.writeBlocks(&w, reducer, imageBlocks, i.Bounds(), m, c.Quantizer, false)
+	return w.Bytes()
+}
 
+// writeBlocks reduces the blocks of an image with a basis
+// and writes the resulting coefficients to w.
+// If t is non-nil, the coefficients are quantized with
+// it, and t itself is written unless it is shared.
+func (c *Compressor) writeBlocks(w *bytes.Buffer, reducer *pcaReducer,
+	imageBlocks []linalg.Vector, bounds image.Rectangle, m roi.Map,
+	t *quantize.Table, sharedTable bool) {
 	reducedBlocks := make([]linalg.Vector, len(imageBlocks))
+	parallel.For(len(imageBlocks), c.Concurrency, func(i int) {
+		reducedBlocks[i] = reducer.Reduce(imageBlocks[i])
+	})
+
+	if t != nil {
+		// The coefficients must be quantized with the same
+		// precision at which the table is stored.
+		t = t.Rounded()
+		leveled := c.QualityMap && !c.Progressive
+		if leveled {
+			w.WriteByte(modeQuantized | modeLeveled)
+		} else {
+			w.WriteByte(modeQuantized | c.progressiveMode())
+		}
+		if !sharedTable {
+			quantize.WriteTable(w, t)
+		}
+		if c.Progressive {
+			writeProgressiveQuantized(w, t, c.Lambda, reducedBlocks)
+		} else if leveled {
+			levels := roi.BlockLevels(m, bounds, c.blockSize)
+			writeQuantizedBlocks(w, t, c.Lambda, reducedBlocks, levels)
+		} else {
+			writeQuantizedBlocks(w, t, c.Lambda, reducedBlocks, nil)
+		}
+		return
+	}
+
+	w.WriteByte(c.progressiveMode())
+
 	var maxValue float64
 	var minValue float64
-	for i, block := range imageBlocks {
-		reducedBlocks[i] = reducer.Reduce(block)
+	for i := range reducedBlocks {
 		for j, x := range reducedBlocks[i] {
 			if j == 0 && i == 0 {
 				maxValue = x
@@ -67,23 +199,91 @@ func (c *Compressor) Compress(i image.Image) []byte {
 		}
 	}
 
-	binary.Write(&w, encodingEndian, float64(minValue))
-	binary.Write(&w, encodingEndian, float64(maxValue))
+	binary.Write(w, encodingEndian, float64(minValue))
+	binary.Write(w, encodingEndian, float64(maxValue))
+
+	if c.Progressive {
+		writeProgressiveBytes(w, reducedBlocks, minValue, maxValue)
+		return
+	}
 
 	for _, block := range reducedBlocks {
 		for _, x := range block {
-			val := 255.0 * (x - minValue) / (maxValue - minValue)
-			rounded := byte(val + 0.5)
-			w.WriteByte(rounded)
+			w.WriteByte(valueByte(x, minValue, maxValue))
 		}
 	}
+}
 
-	return w.Bytes()
+// CompressChecked is like Compress, but it returns an
+// error if the Compressor is misconfigured or the image
+// is too large to encode.
+func (c *Compressor) CompressChecked(i image.Image) ([]byte, error) {
+	if c.blockSize < 1 {
+		return nil, errors.New("compressor has no block size")
+	}
+	if c.Quantizer != nil {
+		if err := c.Quantizer.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if !(c.Lambda >= 0) || math.IsInf(c.Lambda, 0) {
+		return nil, errors.New("lambda must be non-negative")
+	}
+	b := i.Bounds()
+	if uint64(b.Dx()) > math.MaxUint32 || uint64(b.Dy()) > math.MaxUint32 {
+		return nil, errors.New("image is too large")
+	}
+	return c.Compress(i), nil
 }
 
 // Decompress decodes image data that was encoded
 // by Compress.
 func (c *Compressor) Decompress(b []byte) (image.Image, error) {
+	ri, err := c.decodeReduced(b)
+	if err != nil {
+		return nil, err
+	}
+	return c.expandImage(ri.Bounds, ri.Expander, ri.Blocks, c.blockSize), nil
+}
+
+// DecodeThumbnail decodes image data at a reduced size,
+// shrinking it by a factor of scale in each dimension.
+// The scale must evenly divide the block size; a scale
+// equal to the block size yields one pixel per block.
+//
+// This is much faster than decoding the full image,
+// since blocks are expanded with downsampled principal
+// components.
+func (c *Compressor) DecodeThumbnail(b []byte, scale int) (image.Image, error) {
+	if scale < 1 || c.blockSize%scale != 0 {
+		return nil, errors.New("thumbnail scale must divide the block size")
+	}
+	ri, err := c.decodeReduced(b)
+	if err != nil {
+		return nil, err
+	}
+	bases := blocker.ThumbnailBases(ri.Expander.basis, ri.Bounds.Dx(), ri.Bounds.Dy(),
+		c.blockSize, scale)
+	imageBlocks := make([]linalg.Vector, len(ri.Blocks))
+	parallel.For(len(ri.Blocks), c.Concurrency, func(i int) {
+		small := &pcaExpander{basis: bases[i]}
+		imageBlocks[i] = small.Expand(ri.Blocks[i])
+	})
+	w, h := blocker.ThumbnailSize(ri.Bounds.Dx(), ri.Bounds.Dy(), scale)
+	return blocker.Image(w, h, imageBlocks, c.blockSize/scale), nil
+}
+
+// reducedImage is an image whose blocks have been decoded
+// but not yet expanded.
+type reducedImage struct {
+	Bounds   image.Rectangle
+	Expander *pcaExpander
+	Blocks   []linalg.Vector
+}
+
+// decodeReduced decodes the bounds, the principal
+// components, and the reduced blocks of an image.
+func (c *Compressor) decodeReduced(b []byte) (*reducedImage, error) {
 	r := bytes.NewBuffer(b)
 
 	var width, height uint32
@@ -94,11 +294,64 @@ func (c *Compressor) Decompress(b []byte) (image.Image, error) {
 		return nil, errors.New("failed to read height field: " + err.Error())
 	}
 
-	expander, err := readPCAExpander(r)
+	if err := c.DecodeOptions.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return nil, err
+	}
+	expander, err := readPCAExpander(r, c.blockSize, &c.DecodeOptions)
+	if err != nil {
+		return nil, err
+	}
+	return c.readBlocks(r, int(width), int(height), expander, nil, false)
+}
+
+// readBlocks reads the reduced blocks of an image with
+// the given dimensions and basis.
+// If sharedTable is set, the quantization table is
+// table (which is nil if the blocks must not be
+// quantized) rather than being read from r.
+func (c *Compressor) readBlocks(r *bytes.Buffer, width, height int,
+	expander *pcaExpander, table *quantize.Table, sharedTable bool) (*reducedImage, error) {
+	err := c.DecodeOptions.CheckBlocks(uint64(width), uint64(height), c.blockSize,
+		len(expander.basis))
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, width, height)
+	blockCount := blocker.Count(rect, c.blockSize)
+
+	mode, err := readMode(r)
 	if err != nil {
-		return nil, errors.New("failed to read PCA expander: " + err.Error())
-	} else if len(expander.basis[0]) != c.blockSize*c.blockSize {
-		return nil, errors.New("block size mismatch")
+		return nil, err
+	}
+	if progressive := mode&modeProgressive != 0; progressive && !c.Progressive {
+		return nil, errors.New("progressive data requires a progressive decoder")
+	} else if !progressive && c.Progressive {
+		return nil, errors.New("data is not progressive")
+	}
+	if sharedTable && (mode&modeQuantized != 0) != (table != nil) {
+		return nil, errors.New("mode does not match shared basis")
+	}
+	if mode&modeQuantized != 0 {
+		leveled := mode&modeLeveled != 0
+		if !sharedTable {
+			table, err = quantize.ReadTable(r)
+			if err != nil {
+				return nil, errors.New("failed to read quantization table: " + err.Error())
+			}
+		}
+		var reducedBlocks []linalg.Vector
+		if c.Progressive {
+			reducedBlocks, err = readProgressiveQuantized(r, table, blockCount,
+				len(expander.basis))
+		} else {
+			reducedBlocks, err = readQuantizedBlocks(r, table, blockCount,
+				len(expander.basis), leveled)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &reducedImage{rect, expander, reducedBlocks}, nil
 	}
 
 	var minValue, maxValue float64
@@ -109,10 +362,17 @@ func (c *Compressor) Decompress(b []byte) (image.Image, error) {
 		return nil, errors.New("failed to read max value: " + err.Error())
 	}
 
-	rect := image.Rect(0, 0, int(width), int(height))
-	blockCount := blocker.Count(rect, c.blockSize)
-	imageBlocks := make([]linalg.Vector, blockCount)
-	for i := range imageBlocks {
+	if c.Progressive {
+		reducedBlocks, err := readProgressiveBytes(r, blockCount, len(expander.basis),
+			minValue, maxValue)
+		if err != nil {
+			return nil, err
+		}
+		return &reducedImage{rect, expander, reducedBlocks}, nil
+	}
+
+	reducedBlocks := make([]linalg.Vector, blockCount)
+	for i := range reducedBlocks {
 		reducedBlock := make(linalg.Vector, len(expander.basis))
 		for j := range reducedBlock {
 			if val, err := r.ReadByte(); err != nil {
@@ -122,8 +382,153 @@ func (c *Compressor) Decompress(b []byte) (image.Image, error) {
 				reducedBlock[j] = num
 			}
 		}
-		imageBlocks[i] = expander.Expand(reducedBlock)
+		reducedBlocks[i] = reducedBlock
+	}
+
+	return &reducedImage{rect, expander, reducedBlocks}, nil
+}
+
+// progressiveMode returns modeProgressive if c stores
+// progressive data, or 0 otherwise.
+func (c *Compressor) progressiveMode() byte {
+	if c.Progressive {
+		return modeProgressive
+	}
+	return 0
+}
+
+// readMode reads a mode byte and checks that it only uses
+// known flags.
+func readMode(r io.ByteReader) (byte, error) {
+	mode, err := r.ReadByte()
+	if err != nil {
+		return 0, errors.New("failed to read mode: " + err.Error())
+	} else if mode&^(modeQuantized|modeLeveled|modeProgressive) != 0 ||
+		(mode&modeLeveled != 0 && mode&(modeQuantized|modeProgressive) != modeQuantized) {
+		return 0, fmt.Errorf("unknown mode: 0x%x", mode)
+	}
+	return mode, nil
+}
+
+// UsesQualityMap returns whether CompressMap takes its
+// Map into account, which requires QualityMap and a
+// Quantizer, and is not supported for progressive data.
+func (c *Compressor) UsesQualityMap() bool {
+	return c.leveled()
+}
+
+// leveled returns whether blocks have quality levels.
+func (c *Compressor) leveled() bool {
+	return c.QualityMap && c.Quantizer != nil && !c.Progressive
+}
+
+// trainReducer finds the principal components of some
+// blocks, taking SampleSize and PowerIterations into
+// account.
+func (c *Compressor) trainReducer(blocks []linalg.Vector) *pcaReducer {
+	if len(blocks) == 0 {
+		// Any basis can represent an empty image.
+		return newPCAReducerBasis(standardBasis(c.blockSize*c.blockSize, c.basisSize))
+	}
+	trainingBlocks := sampleBlocks(blocks, c.SampleSize)
+	if c.PowerIterations > 0 {
+		return newRandomizedPCAReducer(trainingBlocks, c.basisSize, c.PowerIterations,
+			c.Concurrency)
+	}
+	return newPCAReducer(trainingBlocks, c.basisSize, c.Concurrency)
+}
+
+// valueByte quantizes a coefficient between minValue
+// and maxValue to 8 bits.
+func valueByte(x, minValue, maxValue float64) byte {
+	if maxValue == minValue {
+		// Every coefficient decodes to minValue (e.g. for a
+		// solid image), regardless of the byte.
+		return 0
+	}
+	val := 255.0 * (x - minValue) / (maxValue - minValue)
+	return byte(val + 0.5)
+}
+
+func (c *Compressor) expandImage(rect image.Rectangle, expander *pcaExpander,
+	reducedBlocks []linalg.Vector, blockSize int) image.Image {
+	imageBlocks := make([]linalg.Vector, len(reducedBlocks))
+	parallel.For(len(reducedBlocks), c.Concurrency, func(i int) {
+		imageBlocks[i] = expander.Expand(reducedBlocks[i])
+	})
+	return blocker.Image(rect.Dx(), rect.Dy(), imageBlocks, blockSize)
+}
+
+// writeQuantizedBlocks writes the quantized coefficients
+// of the blocks.
+// If levels is non-nil, it specifies the quality level
+// of each spatial block, which is written once before
+// the first of its three color channels.
+func writeQuantizedBlocks(w *bytes.Buffer, t *quantize.Table, lambda float64,
+	blocks []linalg.Vector, levels []int) {
+	var tables []*quantize.Table
+	if levels != nil {
+		tables = roi.ScaledTables(t)
+	}
+	bw := quantize.NewBitWriter(w)
+	blockTable := t
+	for i, block := range blocks {
+		if levels != nil && i%3 == 0 {
+			bw.WriteBits(uint64(levels[i/3]), roi.LevelBits)
+			blockTable = tables[levels[i/3]]
+		}
+		writeQuantizedBlock(bw, blockTable, lambda, block)
+	}
+	bw.Flush()
+}
+
+func writeQuantizedBlock(bw *quantize.BitWriter, t *quantize.Table, lambda float64,
+	block linalg.Vector) error {
+	// The PCA basis is orthonormal, so coefficient errors
+	// are already pixel errors.
+	for _, q := range t.QuantizeBlock(block, nil, nil, lambda) {
+		if err := t.WriteCoeff(bw, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readQuantizedBlocks(r *bytes.Buffer, table *quantize.Table, count, basisSize int,
+	leveled bool) ([]linalg.Vector, error) {
+	var tables []*quantize.Table
+	if leveled {
+		tables = roi.ScaledTables(table)
+	}
+	br := quantize.NewBitReader(r)
+	reducedBlocks := make([]linalg.Vector, count)
+	blockTable := table
+	for i := range reducedBlocks {
+		if leveled && i%3 == 0 {
+			level, err := br.ReadBits(roi.LevelBits)
+			if err != nil {
+				return nil, errors.New("failed to read block level: " + err.Error())
+			}
+			blockTable = tables[level]
+		}
+		var err error
+		reducedBlocks[i], err = readQuantizedBlock(br, blockTable, basisSize)
+		if err != nil {
+			return nil, err
+		}
 	}
+	return reducedBlocks, nil
+}
 
-	return blocker.Image(rect.Dx(), rect.Dy(), imageBlocks, c.blockSize), nil
+func readQuantizedBlock(br *quantize.BitReader, t *quantize.Table,
+	basisSize int) (linalg.Vector, error) {
+	reducedBlock := make(linalg.Vector, basisSize)
+	for j := range reducedBlock {
+		q, err := t.ReadCoeff(br)
+		if err != nil {
+			return nil, errors.New("failed to read data: " + err.Error())
+		}
+		reducedBlock[j] = t.Dequantize(j, q)
+	}
+	return reducedBlock, nil
 }
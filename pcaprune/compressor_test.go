@@ -0,0 +1,203 @@
+package pcaprune
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
+	"github.com/unixpickle/imagecompress/strip"
+)
+
+func TestCompressConcurrency(t *testing.T) {
+	configs := map[string]func(c *Compressor){
+		"default": func(c *Compressor) {},
+		"quantized": func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.Lambda = 1e-4
+		},
+		"randomized": func(c *Compressor) {
+			c.PowerIterations = 3
+			c.SampleSize = 50
+		},
+		"progressive": func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.Progressive = true
+		},
+	}
+	img := testutil.Image(70, 50)
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			trainer := NewCompressor(0.3)
+			config(trainer)
+			basis := trainer.TrainSharedBasis([]image.Image{img})
+			testutil.CheckConcurrency(t, img, func(concurrency int) testutil.Codec {
+				c := NewCompressor(0.3)
+				config(c)
+				c.Concurrency = concurrency
+				return &sharedCodec{c: c, basis: basis}
+			})
+		})
+	}
+}
+
+// sharedCodec compresses images with a fixed basis from
+// TrainSharedBasis.
+//
+// The eigensolver races two solvers against each other,
+// so a freshly trained basis may differ in its last bits
+// from run to run; a fixed basis isolates the effect of
+// Concurrency on the blocks.
+type sharedCodec struct {
+	c     *Compressor
+	basis []byte
+}
+
+func (s *sharedCodec) Compress(i image.Image) []byte {
+	data, err := s.c.CompressShared(s.basis, i)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *sharedCodec) Decompress(d []byte) (image.Image, error) {
+	return s.c.DecompressShared(s.basis, d)
+}
+
+func TestDecodeThumbnailEdges(t *testing.T) {
+	testutil.CheckDecodeThumbnail(t, NewCompressorBlockSize(0.5, 8), 4)
+}
+
+func TestQuantizedMode(t *testing.T) {
+	plain := NewCompressorBlockSize(0.5, 4)
+	quantized := NewCompressorBlockSize(0.5, 4)
+	quantized.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	testutil.CheckDecoders(t, testutil.Image(21, 13), plain, quantized)
+}
+
+func TestProgressive(t *testing.T) {
+	img := testutil.Image(21, 13)
+	for _, quantizer := range []*quantize.Table{nil, quantize.Lookup(quantize.StandardTableID)} {
+		progressive := NewCompressorBlockSize(0.5, 4)
+		progressive.Quantizer = quantizer
+		progressive.Progressive = true
+		plain := NewCompressorBlockSize(0.5, 4)
+		plain.Quantizer = quantizer
+		testutil.CheckProgressive(t, progressive, plain, img)
+	}
+}
+
+func TestCompressStripedOptions(t *testing.T) {
+	for name, config := range map[string]func(c *Compressor){
+		"progressive": func(c *Compressor) { c.Progressive = true },
+		"quality map": func(c *Compressor) { c.QualityMap = true },
+	} {
+		c := NewCompressorBlockSize(0.5, 4)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		config(c)
+		err := c.CompressStriped(strip.ImageSource(testutil.Image(9, 5)), &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDecompressStripedLimits(t *testing.T) {
+	c := NewCompressorBlockSize(0.5, 4)
+	c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	testutil.CheckStripedLimits(t, c)
+}
+
+func TestDegenerateImages(t *testing.T) {
+	for name, img := range testutil.DegenerateImages() {
+		for _, quantizer := range []*quantize.Table{nil, quantize.Lookup(quantize.StandardTableID)} {
+			c := NewCompressorBlockSize(1, 4)
+			c.Quantizer = quantizer
+			errs := testutil.CoefficientErrors(img, c.blockSize, c.Quantizer, nil)
+			t.Run(fmt.Sprintf("%s/quantized=%v", name, quantizer != nil), func(t *testing.T) {
+				testutil.CheckRoundTrip(t, c, img, testutil.QuantizationError(errs))
+			})
+		}
+	}
+}
+
+func TestCompressStripedDegenerate(t *testing.T) {
+	for name, img := range testutil.DegenerateImages() {
+		c := NewCompressorBlockSize(1, 4)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		var buf bytes.Buffer
+		if err := c.CompressStriped(strip.ImageSource(img), &buf); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		decoded, err := c.Decompress(buf.Bytes())
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if decoded.Bounds().Size() != img.Bounds().Size() {
+			t.Errorf("%s: expected size %v but got %v", name, img.Bounds().Size(),
+				decoded.Bounds().Size())
+		}
+	}
+}
+
+func TestCheckedConstructors(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Quality   float64
+		BlockSize int
+	}{
+		{"zero block size", 0.5, 0},
+		{"negative block size", 0.5, -2},
+		{"negative quality", -0.1, 4},
+		{"large quality", 1.5, 4},
+		{"NaN quality", math.NaN(), 4},
+	}
+	for _, c := range cases {
+		if _, err := NewCompressorBlockSizeChecked(c.Quality, c.BlockSize); err == nil {
+			t.Errorf("%s: expected an error", c.Name)
+		}
+	}
+	for _, quality := range []float64{0, 0.5, 1} {
+		c, err := NewCompressorBlockSizeChecked(quality, 3)
+		if err != nil {
+			t.Errorf("quality %f: %s", quality, err)
+		} else if c.basisSize < 1 || c.basisSize > 9 {
+			t.Errorf("quality %f: bad basis size %d", quality, c.basisSize)
+		}
+	}
+
+	if _, err := (&Compressor{}).CompressChecked(testutil.Image(3, 3)); err == nil {
+		t.Error("CompressChecked: zero Compressor: expected an error")
+	}
+	c := NewCompressor(0.5)
+	c.Lambda = math.NaN()
+	if _, err := c.CompressChecked(testutil.Image(3, 3)); err == nil {
+		t.Error("CompressChecked: NaN Lambda: expected an error")
+	}
+}
+
+func TestCompressMap(t *testing.T) {
+	img := testutil.Image(32, 24)
+	fine, coarse := image.Rect(16, 0, 32, 24), image.Rect(0, 0, 16, 24)
+	m := &roi.RectMap{Rects: []image.Rectangle{fine}}
+	c := NewCompressorBlockSize(1, 4)
+	c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	c.QualityMap = true
+
+	// The data says that it has levels, so a Compressor
+	// without QualityMap can decode it.
+	decoded, err := NewCompressorBlockSize(1, 4).Decompress(c.CompressMap(img, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fineErr := testutil.MeanError(img, decoded, fine)
+	coarseErr := testutil.MeanError(img, decoded, coarse)
+	if fineErr >= coarseErr {
+		t.Errorf("important region has error %f, but the rest has %f", fineErr, coarseErr)
+	}
+}
@@ -3,3 +3,19 @@ package pcaprune
 import "encoding/binary"
 
 var encodingEndian = binary.LittleEndian
+
+// These flags make up the mode byte at the start of the
+// coefficients of an image, which says how they are
+// stored.
+const (
+	modeQuantized = 1 << iota
+
+	// modeLeveled means that each block starts with a
+	// quality level, which requires modeQuantized.
+	modeLeveled
+
+	// modeProgressive means that the coefficients are
+	// stored one principal component at a time, which
+	// rules out modeLeveled.
+	modeProgressive
+)
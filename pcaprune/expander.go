@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 
+	"github.com/unixpickle/imagecompress/limits"
 	"github.com/unixpickle/num-analysis/linalg"
 )
 
@@ -12,17 +13,27 @@ type pcaExpander struct {
 	basis []linalg.Vector
 }
 
-func readPCAExpander(r io.Reader) (*pcaExpander, error) {
+// readPCAExpander reads a basis of principal components
+// for blocks with the given side length.
+//
+// The opts are checked before the basis is allocated.
+func readPCAExpander(r io.Reader, blockSize int, opts *limits.DecodeOptions) (*pcaExpander, error) {
 	var count, dimension uint32
 	if err := binary.Read(r, encodingEndian, &count); err != nil {
-		return nil, err
+		return nil, errors.New("failed to read PCA expander: " + err.Error())
 	}
 	if err := binary.Read(r, encodingEndian, &dimension); err != nil {
-		return nil, err
+		return nil, errors.New("failed to read PCA expander: " + err.Error())
 	}
 
 	if count == 0 || dimension == 0 {
 		return nil, errors.New("basis must not be empty")
+	} else if int(dimension) != blockSize*blockSize {
+		return nil, errors.New("block size mismatch")
+	} else if count > dimension {
+		return nil, errors.New("too many principal components")
+	} else if err := opts.CheckBasisSize(uint64(count)); err != nil {
+		return nil, err
 	}
 
 	res := &pcaExpander{basis: make([]linalg.Vector, count)}
@@ -32,7 +43,7 @@ func readPCAExpander(r io.Reader) (*pcaExpander, error) {
 		for j := 0; j < int(dimension); j++ {
 			var val float32
 			if err := binary.Read(r, encodingEndian, &val); err != nil {
-				return nil, err
+				return nil, errors.New("failed to read PCA expander: " + err.Error())
 			}
 			vec[j] = float64(val)
 		}
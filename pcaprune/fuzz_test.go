@@ -0,0 +1,69 @@
+package pcaprune
+
+import (
+	"image"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+)
+
+func fuzzCompressors(quality float64) []*Compressor {
+	configs := []func(c *Compressor){
+		func(c *Compressor) {},
+		func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		},
+		func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.Progressive = true
+		},
+		func(c *Compressor) {
+			c.Progressive = true
+		},
+		func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.QualityMap = true
+		},
+	}
+	res := make([]*Compressor, len(configs))
+	for i, config := range configs {
+		res[i] = NewCompressorBlockSize(quality, 4)
+		res[i].DecodeOptions = testutil.FuzzOptions
+		config(res[i])
+	}
+	return res
+}
+
+func FuzzDecompress(f *testing.F) {
+	var codecs []testutil.Codec
+	for _, c := range fuzzCompressors(0.5) {
+		codecs = append(codecs, c)
+	}
+	testutil.FuzzCodecs(f, codecs)
+}
+
+func FuzzDecompressShared(f *testing.F) {
+	var codecs []testutil.SharedCodec
+	for _, c := range fuzzCompressors(0.5) {
+		codecs = append(codecs, c)
+	}
+	testutil.FuzzSharedCodecs(f, codecs)
+}
+
+func TestQualityOne(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {7, 5}, {40, 33}} {
+		img := testutil.Image(size.X, size.Y)
+		for _, c := range fuzzCompressors(1) {
+			if c.QualityMap {
+				// Quality maps deliberately coarsen the
+				// quantization of unimportant blocks.
+				continue
+			}
+			// The principal components are orthonormal, and
+			// component i uses step i.
+			errs := testutil.CoefficientErrors(img, c.blockSize, c.Quantizer, nil)
+			testutil.CheckRoundTrip(t, c, img, testutil.QuantizationError(errs))
+		}
+	}
+}
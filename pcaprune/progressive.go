@@ -0,0 +1,96 @@
+package pcaprune
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// writeProgressiveBytes writes 8-bit coefficients one
+// principal component at a time.
+func writeProgressiveBytes(w *bytes.Buffer, blocks []linalg.Vector, minValue, maxValue float64) {
+	if len(blocks) == 0 {
+		return
+	}
+	for j := range blocks[0] {
+		for _, block := range blocks {
+			w.WriteByte(valueByte(block[j], minValue, maxValue))
+		}
+	}
+}
+
+// readProgressiveBytes performs the inverse of
+// writeProgressiveBytes.
+// If the data ends after a component, the coefficients
+// of the missing components are left at zero.
+func readProgressiveBytes(r *bytes.Buffer, count, basisSize int,
+	minValue, maxValue float64) ([]linalg.Vector, error) {
+	reducedBlocks := newReducedBlocks(count, basisSize)
+	for j := 0; j < basisSize; j++ {
+		if r.Len() == 0 {
+			return reducedBlocks, nil
+		}
+		for _, block := range reducedBlocks {
+			val, err := r.ReadByte()
+			if err != nil {
+				return nil, errors.New("failed to read data: " + err.Error())
+			}
+			block[j] = ((float64(val) / 255.0) * (maxValue - minValue)) + minValue
+		}
+	}
+	return reducedBlocks, nil
+}
+
+// writeProgressiveQuantized writes the quantized
+// coefficients one principal component at a time,
+// starting each component on a byte boundary.
+func writeProgressiveQuantized(w *bytes.Buffer, t *quantize.Table, lambda float64,
+	blocks []linalg.Vector) {
+	quantized := make([][]int, len(blocks))
+	for i, block := range blocks {
+		quantized[i] = t.QuantizeBlock(block, nil, nil, lambda)
+	}
+	bw := quantize.NewBitWriter(w)
+	if len(blocks) > 0 {
+		for j := range blocks[0] {
+			for _, block := range quantized {
+				t.WriteCoeff(bw, block[j])
+			}
+			bw.Flush()
+		}
+	}
+}
+
+// readProgressiveQuantized performs the inverse of
+// writeProgressiveQuantized.
+// If the data ends after a component, the coefficients
+// of the missing components are left at zero.
+func readProgressiveQuantized(r *bytes.Buffer, table *quantize.Table,
+	count, basisSize int) ([]linalg.Vector, error) {
+	br := quantize.NewBitReader(r)
+	reducedBlocks := newReducedBlocks(count, basisSize)
+	for j := 0; j < basisSize; j++ {
+		br.Align()
+		if r.Len() == 0 {
+			return reducedBlocks, nil
+		}
+		for _, block := range reducedBlocks {
+			q, err := table.ReadCoeff(br)
+			if err != nil {
+				return nil, errors.New("failed to read data: " + err.Error())
+			}
+			block[j] = table.Dequantize(j, q)
+		}
+	}
+	return reducedBlocks, nil
+}
+
+func newReducedBlocks(count, basisSize int) []linalg.Vector {
+	res := make([]linalg.Vector, count)
+	for i := range res {
+		res[i] = make(linalg.Vector, basisSize)
+	}
+	return res
+}
@@ -0,0 +1,134 @@
+package pcaprune
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	// oversampling is the number of extra directions that
+	// randomized PCA tracks beyond the ones it keeps, which
+	// speeds up convergence of the top components.
+	oversampling = 8
+
+	randomSeed = 1337
+)
+
+// newRandomizedPCAReducer is like newPCAReducer, but it
+// only finds the top basisSize components using block
+// power iteration from a random starting subspace.
+//
+// It never forms the full covariance matrix, so the cost
+// of each iteration is linear in the dimension of the
+// blocks and in basisSize.
+// More iterations yield more accurate components.
+func newRandomizedPCAReducer(vecs []linalg.Vector, basisSize, iterations,
+	concurrency int) *pcaReducer {
+	dim := len(vecs[0])
+	numDirs := basisSize + oversampling
+	if numDirs > dim {
+		numDirs = dim
+	}
+
+	r := rand.New(rand.NewSource(randomSeed))
+	subspace := make([]linalg.Vector, numDirs)
+	for i := range subspace {
+		subspace[i] = randomVector(r, dim)
+	}
+	orthonormalize(subspace, r)
+
+	var product []linalg.Vector
+	for i := 0; i < iterations; i++ {
+		product = covarianceProduct(vecs, subspace, concurrency)
+		subspace = product
+		orthonormalize(subspace, r)
+	}
+	product = covarianceProduct(vecs, subspace, concurrency)
+
+	// Find the best components within the subspace using
+	// the covariance matrix projected onto it.
+	projected := linalg.NewMatrix(numDirs, numDirs)
+	for i, u := range subspace {
+		for j := 0; j <= i; j++ {
+			val := (u.Dot(product[j]) + subspace[j].Dot(product[i])) / 2
+			projected.Set(i, j, val)
+			projected.Set(j, i, val)
+		}
+	}
+	vals, smallVecs := eigs(projected)
+	sort.Sort(&eigenSorter{vals: vals, vecs: smallVecs})
+
+	basis := make([]linalg.Vector, basisSize)
+	for i := range basis {
+		vec := make(linalg.Vector, dim)
+		for j, coeff := range smallVecs[i] {
+			vec.Add(subspace[j].Copy().Scale(coeff))
+		}
+		basis[i] = vec.Scale(1 / vec.Mag())
+	}
+
+	return newPCAReducerBasis(basis)
+}
+
+// covarianceProduct multiplies each vector in dirs by the
+// (uncentered) covariance matrix of vecs.
+func covarianceProduct(vecs, dirs []linalg.Vector, concurrency int) []linalg.Vector {
+	res := make([]linalg.Vector, len(dirs))
+	parallel.For(len(dirs), concurrency, func(i int) {
+		sum := make(linalg.Vector, len(dirs[i]))
+		for _, vec := range vecs {
+			dot := vec.Dot(dirs[i])
+			for j, x := range vec {
+				sum[j] += x * dot
+			}
+		}
+		res[i] = sum
+	})
+	return res
+}
+
+// orthonormalize performs modified Gram-Schmidt on a list
+// of vectors in place.
+// Vectors which are linearly dependent on the previous
+// ones are replaced with random vectors.
+func orthonormalize(vecs []linalg.Vector, r *rand.Rand) {
+	for i := range vecs {
+		for attempt := 0; ; attempt++ {
+			for j := 0; j < i; j++ {
+				vecs[i].Add(vecs[j].Copy().Scale(-vecs[j].Dot(vecs[i])))
+			}
+			mag := vecs[i].Mag()
+			if mag > 1e-10 || attempt > 10 {
+				vecs[i].Scale(1 / math.Max(mag, 1e-10))
+				break
+			}
+			vecs[i] = randomVector(r, len(vecs[i]))
+		}
+	}
+}
+
+// sampleBlocks chooses a random subset of at most
+// count blocks.
+func sampleBlocks(vecs []linalg.Vector, count int) []linalg.Vector {
+	if count <= 0 || count >= len(vecs) {
+		return vecs
+	}
+	r := rand.New(rand.NewSource(randomSeed))
+	res := make([]linalg.Vector, count)
+	for i, j := range r.Perm(len(vecs))[:count] {
+		res[i] = vecs[j]
+	}
+	return res
+}
+
+func randomVector(r *rand.Rand, dim int) linalg.Vector {
+	res := make(linalg.Vector, dim)
+	for i := range res {
+		res[i] = r.NormFloat64()
+	}
+	return res
+}
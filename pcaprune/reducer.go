@@ -5,6 +5,7 @@ import (
 	"io"
 	"sort"
 
+	"github.com/unixpickle/imagecompress/parallel"
 	"github.com/unixpickle/num-analysis/kahan"
 	"github.com/unixpickle/num-analysis/linalg"
 	"github.com/unixpickle/num-analysis/linalg/eigen"
@@ -18,9 +19,9 @@ type pcaReducer struct {
 	basis  []linalg.Vector
 }
 
-func newPCAReducer(vecs []linalg.Vector, basisSize int) *pcaReducer {
+func newPCAReducer(vecs []linalg.Vector, basisSize, concurrency int) *pcaReducer {
 	normalMat := linalg.NewMatrix(len(vecs[0]), len(vecs[0]))
-	for i := 0; i < normalMat.Rows; i++ {
+	parallel.For(normalMat.Rows, concurrency, func(i int) {
 		for j := 0; j <= i; j++ {
 			s := kahan.NewSummer64()
 			for _, vec := range vecs {
@@ -29,17 +30,22 @@ func newPCAReducer(vecs []linalg.Vector, basisSize int) *pcaReducer {
 			normalMat.Set(i, j, s.Sum())
 			normalMat.Set(j, i, s.Sum())
 		}
-	}
+	})
 	vals, vecs := eigs(normalMat)
 	sorter := &eigenSorter{vals: vals, vecs: vecs}
 	sort.Sort(sorter)
 
+	return newPCAReducerBasis(vecs[:basisSize])
+}
+
+// newPCAReducerBasis creates a reducer for a basis of
+// principal components, sorted by significance.
+func newPCAReducerBasis(basis []linalg.Vector) *pcaReducer {
 	res := &pcaReducer{
-		basis: make([]linalg.Vector, basisSize),
+		basis: make([]linalg.Vector, len(basis)),
 	}
-	copy(res.basis, vecs)
+	copy(res.basis, basis)
 	res.solver = leastsquares.NewSolver(matrixWithColumns(res.basis))
-
 	return res
 }
 
@@ -103,6 +109,17 @@ func eigs(m *linalg.Matrix) ([]float64, []linalg.Vector) {
 	}
 }
 
+// standardBasis returns the first count standard basis
+// vectors of the given dimension.
+func standardBasis(dim, count int) []linalg.Vector {
+	res := make([]linalg.Vector, count)
+	for i := range res {
+		res[i] = make(linalg.Vector, dim)
+		res[i][i] = 1
+	}
+	return res
+}
+
 func matrixWithColumns(c []linalg.Vector) *linalg.Matrix {
 	res := linalg.NewMatrix(len(c[0]), len(c))
 	for i := 0; i < res.Rows; i++ {
@@ -0,0 +1,123 @@
+package pcaprune
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// TrainSharedBasis finds the principal components of the
+// blocks of several images, such as the frames of an
+// animation, and returns an encoding of them along with
+// the Quantizer (if there is one).
+//
+// The basis can be passed to CompressShared and
+// DecompressShared so that it is only stored once for all
+// of the images.
+func (c *Compressor) TrainSharedBasis(images []image.Image) []byte {
+	var blocks []linalg.Vector
+	for _, img := range images {
+		blocks = append(blocks, blocker.Blocks(img, c.blockSize)...)
+	}
+	var w bytes.Buffer
+	c.trainReducer(blocks).WriteTo(&w)
+	if c.Quantizer != nil {
+		w.WriteByte(modeQuantized)
+		quantize.WriteTable(&w, c.Quantizer)
+	} else {
+		w.WriteByte(0)
+	}
+	return w.Bytes()
+}
+
+// CompressShared is like Compress, but it uses a basis
+// from TrainSharedBasis rather than storing a basis in
+// the compressed data.
+// The coefficients are quantized with the table in the
+// basis rather than with c.Quantizer.
+func (c *Compressor) CompressShared(basis []byte, i image.Image) ([]byte, error) {
+	expander, table, err := c.readSharedBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+	b := i.Bounds()
+	if uint64(b.Dx()) > math.MaxUint32 || uint64(b.Dy()) > math.MaxUint32 {
+		return nil, errors.New("image is too large")
+	}
+	reducer := newPCAReducerBasis(expander.basis)
+
+	var w bytes.Buffer
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dx()))
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dy()))
+	var m roi.Map
+	if c.QualityMap && !c.Progressive && table != nil {
+		m = roi.Saliency(i, c.blockSize)
+	}
+	c.writeBlocks(&w, reducer, blocker.Blocks(i, c.blockSize), i.Bounds(), m, table, true)
+	return w.Bytes(), nil
+}
+
+// DecompressShared decodes image data that was encoded by
+// CompressShared with the same basis.
+func (c *Compressor) DecompressShared(basis, d []byte) (image.Image, error) {
+	expander, table, err := c.readSharedBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewBuffer(d)
+	var width, height uint32
+	if err := binary.Read(r, encodingEndian, &width); err != nil {
+		return nil, errors.New("failed to read width field: " + err.Error())
+	}
+	if err := binary.Read(r, encodingEndian, &height); err != nil {
+		return nil, errors.New("failed to read height field: " + err.Error())
+	}
+	if err := c.DecodeOptions.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return nil, err
+	}
+	ri, err := c.readBlocks(r, int(width), int(height), expander, table, true)
+	if err != nil {
+		return nil, err
+	}
+	return c.expandImage(ri.Bounds, ri.Expander, ri.Blocks, c.blockSize), nil
+}
+
+// readSharedBasis decodes a basis and quantization table
+// from TrainSharedBasis.
+//
+// The encoder uses the decoded basis as well, so that it
+// reduces blocks with exactly the same (rounded) basis
+// that the decoder expands them with.
+func (c *Compressor) readSharedBasis(basis []byte) (*pcaExpander, *quantize.Table, error) {
+	r := bytes.NewReader(basis)
+	expander, err := readPCAExpander(r, c.blockSize, &c.DecodeOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	mode, err := readMode(r)
+	if err != nil {
+		return nil, nil, err
+	} else if mode&(modeLeveled|modeProgressive) != 0 {
+		return nil, nil, fmt.Errorf("unknown mode: 0x%x", mode)
+	}
+	var table *quantize.Table
+	if mode&modeQuantized != 0 {
+		table, err = quantize.ReadTable(r)
+		if err != nil {
+			return nil, nil, errors.New("failed to read quantization table: " + err.Error())
+		}
+	}
+	if r.Len() != 0 {
+		return nil, nil, errors.New("unexpected data after shared basis")
+	}
+	return expander, table, nil
+}
@@ -0,0 +1,167 @@
+package pcaprune
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"math/rand"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/strip"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// DefaultStripedSampleSize is the number of blocks used
+// to train the basis in CompressStriped if SampleSize is 0.
+const DefaultStripedSampleSize = 50000
+
+// CompressStriped is like Compress, but it reads the image
+// one row of blocks at a time and writes the compressed
+// data to w as it goes, so the image never has to be in
+// memory all at once.
+//
+// It makes two passes over the source: the first pass
+// trains the basis on a random sample of blocks, and the
+// second pass reduces every block.
+//
+// The Compressor must have a Quantizer, since the 8-bit
+// quantization depends on every coefficient in the image.
+// Progressive and QualityMap are not supported, since
+// they also need the whole image.
+// The output can be decoded with Decompress as well as
+// with DecompressStriped.
+func (c *Compressor) CompressStriped(src strip.Source, w io.Writer) error {
+	if c.Quantizer == nil {
+		return errors.New("striped compression requires a quantizer")
+	} else if c.Progressive {
+		return errors.New("striped compression does not support progressive data")
+	} else if c.QualityMap {
+		return errors.New("striped compression does not support quality maps")
+	}
+
+	sampleSize := c.SampleSize
+	if sampleSize == 0 {
+		sampleSize = DefaultStripedSampleSize
+	}
+	sample, err := reservoirSample(src, c.blockSize, sampleSize)
+	if err != nil {
+		return err
+	}
+	reducer := c.trainReducer(sample)
+
+	bufWriter := bufio.NewWriter(w)
+	binary.Write(bufWriter, encodingEndian, uint32(src.Bounds().Dx()))
+	binary.Write(bufWriter, encodingEndian, uint32(src.Bounds().Dy()))
+	reducer.WriteTo(bufWriter)
+	bufWriter.WriteByte(modeQuantized)
+	table := c.Quantizer.Rounded()
+	if err := quantize.WriteTable(bufWriter, table); err != nil {
+		return err
+	}
+
+	bw := quantize.NewBitWriter(bufWriter)
+	err = strip.BlockRows(src, c.blockSize, func(row int, blocks []linalg.Vector) error {
+		reduced := make([]linalg.Vector, len(blocks))
+		parallel.For(len(blocks), c.Concurrency, func(i int) {
+			reduced[i] = reducer.Reduce(blocks[i])
+		})
+		for _, block := range reduced {
+			if err := writeQuantizedBlock(bw, table, c.Lambda, block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+// DecompressStriped decodes data that was compressed with
+// a Quantizer, passing each row of blocks to sink as soon
+// as it has been decoded.
+func (c *Compressor) DecompressStriped(r io.Reader, sink strip.Sink) error {
+	br := bufio.NewReader(r)
+	var width, height uint32
+	if err := binary.Read(br, encodingEndian, &width); err != nil {
+		return errors.New("failed to read width field: " + err.Error())
+	}
+	if err := binary.Read(br, encodingEndian, &height); err != nil {
+		return errors.New("failed to read height field: " + err.Error())
+	}
+	if err := c.DecodeOptions.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return err
+	}
+	expander, err := readPCAExpander(br, c.blockSize, &c.DecodeOptions)
+	if err != nil {
+		return err
+	}
+	if mode, err := readMode(br); err != nil {
+		return err
+	} else if mode != modeQuantized {
+		return errors.New("striped decompression requires quantized data")
+	}
+	table, err := quantize.ReadTable(br)
+	if err != nil {
+		return errors.New("failed to read quantization table: " + err.Error())
+	}
+
+	err = c.DecodeOptions.CheckStrip(uint64(width), c.blockSize, len(expander.basis))
+	if err != nil {
+		return err
+	}
+
+	w, h := int(width), int(height)
+	if err := sink.Start(image.Rect(0, 0, w, h)); err != nil {
+		return err
+	}
+	bitReader := quantize.NewBitReader(br)
+	rowBlocks := 3 * ((w + c.blockSize - 1) / c.blockSize)
+	numRows := (h + c.blockSize - 1) / c.blockSize
+	for row := 0; row < numRows; row++ {
+		reduced := make([]linalg.Vector, rowBlocks)
+		for i := range reduced {
+			reduced[i], err = readQuantizedBlock(bitReader, table, len(expander.basis))
+			if err != nil {
+				return err
+			}
+		}
+		blocks := make([]linalg.Vector, rowBlocks)
+		parallel.For(rowBlocks, c.Concurrency, func(i int) {
+			blocks[i] = expander.Expand(reduced[i])
+		})
+		bounds := strip.StripBounds(w, h, c.blockSize, row)
+		if err := sink.WriteStrip(blocker.ImageRect(bounds, blocks, c.blockSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reservoirSample chooses up to count random blocks from
+// a source while only keeping the sample in memory.
+func reservoirSample(src strip.Source, blockSize, count int) ([]linalg.Vector, error) {
+	r := rand.New(rand.NewSource(randomSeed))
+	var res []linalg.Vector
+	var seen int
+	err := strip.BlockRows(src, blockSize, func(row int, blocks []linalg.Vector) error {
+		for _, block := range blocks {
+			if len(res) < count {
+				res = append(res, block)
+			} else if j := r.Intn(seen + 1); j < count {
+				res[j] = block
+			}
+			seen++
+		}
+		return nil
+	})
+	return res, err
+}
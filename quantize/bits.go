@@ -0,0 +1,86 @@
+package quantize
+
+import (
+	"errors"
+	"io"
+)
+
+// A BitWriter writes a stream of bits, most significant
+// bit first.
+type BitWriter struct {
+	w     io.ByteWriter
+	cur   byte
+	count uint
+}
+
+// NewBitWriter creates a BitWriter that writes bytes
+// to w.
+func NewBitWriter(w io.ByteWriter) *BitWriter {
+	return &BitWriter{w: w}
+}
+
+// WriteBits writes the low n bits of val.
+func (b *BitWriter) WriteBits(val uint64, n int) error {
+	for i := n - 1; i >= 0; i-- {
+		b.cur = (b.cur << 1) | byte((val>>uint(i))&1)
+		b.count++
+		if b.count == 8 {
+			if err := b.w.WriteByte(b.cur); err != nil {
+				return err
+			}
+			b.cur = 0
+			b.count = 0
+		}
+	}
+	return nil
+}
+
+// Flush pads the last partial byte with zeros and
+// writes it.
+func (b *BitWriter) Flush() error {
+	if b.count == 0 {
+		return nil
+	}
+	err := b.w.WriteByte(b.cur << (8 - b.count))
+	b.cur = 0
+	b.count = 0
+	return err
+}
+
+// A BitReader reads bits written by a BitWriter.
+type BitReader struct {
+	r     io.ByteReader
+	cur   byte
+	count uint
+}
+
+// NewBitReader creates a BitReader that reads bytes
+// from r.
+func NewBitReader(r io.ByteReader) *BitReader {
+	return &BitReader{r: r}
+}
+
+// ReadBits reads n bits and returns them as the low
+// bits of an integer.
+func (b *BitReader) ReadBits(n int) (uint64, error) {
+	var res uint64
+	for i := 0; i < n; i++ {
+		if b.count == 0 {
+			next, err := b.r.ReadByte()
+			if err != nil {
+				return 0, errors.New("unexpected end of bit stream")
+			}
+			b.cur = next
+			b.count = 8
+		}
+		b.count--
+		res = (res << 1) | uint64((b.cur>>b.count)&1)
+	}
+	return res, nil
+}
+
+// Align discards the rest of the current byte, if one
+// has been partially read.
+func (b *BitReader) Align() {
+	b.count = 0
+}
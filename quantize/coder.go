@@ -0,0 +1,161 @@
+package quantize
+
+import (
+	"errors"
+	"math"
+)
+
+// maxGolombValue is the largest magnitude that can be
+// stored with the variable-length code.
+const maxGolombValue = 1<<30 - 1
+
+// WriteCoeff writes a quantized coefficient to w using
+// the bit depth of the Table.
+func (t *Table) WriteCoeff(w *BitWriter, q int) error {
+	q = t.Clamp(q)
+	if t.Bits != 0 {
+		mask := uint64(1)<<uint(t.Bits) - 1
+		return w.WriteBits(uint64(int64(q))&mask, t.Bits)
+	}
+	code := golombIndex(q) + 1
+	n := bitLength(code)
+	if err := w.WriteBits(0, n-1); err != nil {
+		return err
+	}
+	return w.WriteBits(code, n)
+}
+
+// ReadCoeff reads a quantized coefficient that was
+// written with WriteCoeff.
+func (t *Table) ReadCoeff(r *BitReader) (int, error) {
+	if t.Bits != 0 {
+		val, err := r.ReadBits(t.Bits)
+		if err != nil {
+			return 0, err
+		}
+		shift := uint(64 - t.Bits)
+		return int(int64(val<<shift) >> shift), nil
+	}
+
+	zeros := 0
+	for {
+		bit, err := r.ReadBits(1)
+		if err != nil {
+			return 0, err
+		} else if bit == 1 {
+			break
+		}
+		zeros++
+		if zeros > 32 {
+			return 0, errors.New("invalid variable-length coefficient")
+		}
+	}
+	rest, err := r.ReadBits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	code := (uint64(1) << uint(zeros)) | rest
+	idx := code - 1
+	if idx%2 == 1 {
+		return int((idx + 1) / 2), nil
+	}
+	return -int(idx / 2), nil
+}
+
+// Rate returns the number of bits that WriteCoeff uses
+// to store a quantized coefficient.
+func (t *Table) Rate(q int) int {
+	if t.Bits != 0 {
+		return t.Bits
+	}
+	return 2*bitLength(golombIndex(t.Clamp(q))+1) - 1
+}
+
+// golombIndex maps signed integers to unsigned ones
+// so that values near zero get small indices.
+func golombIndex(q int) uint64 {
+	if q > 0 {
+		return uint64(q)*2 - 1
+	}
+	return uint64(-q) * 2
+}
+
+func bitLength(x uint64) int {
+	var n int
+	for x != 0 {
+		x >>= 1
+		n++
+	}
+	return n
+}
+
+// QuantizeRD quantizes a coefficient for the given basis
+// index by minimizing the rate-distortion cost
+//
+//	weight*(val-Dequantize(idx, q))^2 + lambda*Rate(q)
+//
+// where weight scales the squared coefficient error into
+// the squared error of the reconstruction (e.g. the squared
+// norm of the basis vector).
+//
+// Coefficients in the deadzone are always quantized
+// to zero, and if lambda is 0, this is equivalent to
+// Quantize.
+func (t *Table) QuantizeRD(idx int, val, lambda, weight float64) int {
+	q := t.Quantize(idx, val)
+	if lambda == 0 || q == 0 {
+		return q
+	}
+
+	// The cheapest values are always closer to zero than
+	// the rounded value, so we only consider values that
+	// shrink its magnitude.
+	sign := 1
+	if val < 0 {
+		sign = -1
+	}
+	rounded := t.Clamp(sign * int(math.Floor(math.Abs(val)/t.Step(idx)+0.5)))
+	bestQ := 0
+	bestCost := math.Inf(1)
+	for _, candidate := range []int{rounded, q, rounded - sign, 0} {
+		if candidate*sign < 0 {
+			continue
+		}
+		diff := val - t.Dequantize(idx, candidate)
+		cost := weight*diff*diff + lambda*float64(t.Rate(candidate))
+		if cost < bestCost {
+			bestCost = cost
+			bestQ = candidate
+		}
+	}
+	return bestQ
+}
+
+// QuantizeBlock runs rate-distortion optimized
+// quantization over a block of coefficients.
+//
+// The indices slice gives the basis index of each
+// coefficient, or it may be nil if the i-th coefficient
+// corresponds to the i-th basis index.
+// The weights slice works likewise, where nil weights
+// are all treated as 1.
+//
+// Since the rate of each coefficient is independent of
+// the others, choosing the best value for each
+// coefficient minimizes the cost of the block.
+func (t *Table) QuantizeBlock(block []float64, indices []int, weights []float64,
+	lambda float64) []int {
+	res := make([]int, len(block))
+	for i, val := range block {
+		idx := i
+		if indices != nil {
+			idx = indices[i]
+		}
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		res[i] = t.QuantizeRD(idx, val, lambda, weight)
+	}
+	return res
+}
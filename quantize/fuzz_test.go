@@ -0,0 +1,68 @@
+package quantize
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzReadTable(f *testing.F) {
+	for _, t := range []*Table{Lookup(StandardTableID), Linear(5, 0.1, 0.5, 0), Uniform(0.01, 7)} {
+		var buf bytes.Buffer
+		if err := WriteTable(&buf, t); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		table, err := ReadTable(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if err := table.Validate(); err != nil {
+			t.Fatalf("decoded an invalid table: %s", err)
+		}
+	})
+}
+
+func FuzzReadCoeff(f *testing.F) {
+	for _, bits := range []uint8{0, 5} {
+		var buf bytes.Buffer
+		w := NewBitWriter(&buf)
+		table := &Table{Steps: []float64{1}, Bits: int(bits)}
+		for _, q := range []int{0, 1, -1, 7, -13} {
+			if err := table.WriteCoeff(w, q); err != nil {
+				f.Fatal(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(bits, buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, bits uint8, data []byte) {
+		table := &Table{Steps: []float64{1}, Bits: int(bits % 33)}
+		r := NewBitReader(bytes.NewReader(data))
+		for {
+			q, err := table.ReadCoeff(r)
+			if err != nil {
+				return
+			}
+			// Anything that decodes must survive a round
+			// trip once it is clamped.
+			var buf bytes.Buffer
+			w := NewBitWriter(&buf)
+			if err := table.WriteCoeff(w, table.Clamp(q)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			q1, err := table.ReadCoeff(NewBitReader(&buf))
+			if err != nil {
+				t.Fatal(err)
+			} else if q1 != table.Clamp(q) {
+				t.Fatalf("coefficient %d decoded as %d", table.Clamp(q), q1)
+			}
+		}
+	})
+}
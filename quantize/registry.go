@@ -0,0 +1,47 @@
+package quantize
+
+import "fmt"
+
+// These are the IDs of the built-in tables.
+const (
+	StandardTableID = 1
+	CoarseTableID   = 2
+)
+
+var registry = map[uint8]*Table{}
+
+func init() {
+	RegisterTable(&Table{
+		ID:       StandardTableID,
+		Steps:    Linear(64, 1.0/256, 1.0/32, 0).Steps,
+		Deadzone: 0.6,
+	})
+	RegisterTable(&Table{
+		ID:       CoarseTableID,
+		Steps:    Linear(64, 1.0/64, 1.0/8, 0).Steps,
+		Deadzone: 0.75,
+	})
+}
+
+// RegisterTable makes a Table available to ReadTable
+// under its ID, so that streams can reference it
+// without storing it inline.
+//
+// The ID must be non-zero and must not already be in
+// use, or else RegisterTable panics.
+func RegisterTable(t *Table) {
+	if t.ID == 0 {
+		panic("cannot register table with ID 0")
+	} else if registry[t.ID] != nil {
+		panic(fmt.Sprintf("table ID %d is already registered", t.ID))
+	} else if err := t.Validate(); err != nil {
+		panic(err)
+	}
+	registry[t.ID] = t
+}
+
+// Lookup finds the registered Table with the given ID.
+// It returns nil if no such table exists.
+func Lookup(id uint8) *Table {
+	return registry[id]
+}
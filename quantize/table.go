@@ -0,0 +1,240 @@
+package quantize
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const maxTableSize = 1 << 16
+
+var encodingEndian = binary.LittleEndian
+
+// A Table quantizes basis coefficients using a separate
+// step size for each basis index.
+//
+// Much like a JPEG quantization table, a Table can give
+// low-energy basis vectors coarser steps than the basis
+// vectors that carry most of an image.
+type Table struct {
+	// ID identifies a registered Table.
+	// If ID is 0, the table is stored inline in the
+	// stream rather than being referenced by ID.
+	ID uint8
+
+	// Steps contains the step size for each basis index.
+	// Indices past the end of Steps use the last step.
+	Steps []float64
+
+	// Deadzone is the width (in steps) of the region on
+	// either side of zero that quantizes to zero.
+	// Values at or below 0.5 amount to plain rounding.
+	Deadzone float64
+
+	// Bits is the number of bits used to store each
+	// quantized coefficient.
+	// If Bits is 0, coefficients are stored with a
+	// variable-length code, so that small values take
+	// up fewer bits than large ones.
+	Bits int
+}
+
+// Uniform creates an inline Table which uses the same
+// step for every basis index.
+func Uniform(step float64, bits int) *Table {
+	return &Table{Steps: []float64{step}, Deadzone: 0.5, Bits: bits}
+}
+
+// Linear creates an inline Table whose steps increase
+// linearly from first to last over size basis indices.
+func Linear(size int, first, last float64, bits int) *Table {
+	res := &Table{Steps: make([]float64, size), Deadzone: 0.5, Bits: bits}
+	for i := range res.Steps {
+		if size == 1 {
+			res.Steps[i] = first
+		} else {
+			res.Steps[i] = first + (last-first)*float64(i)/float64(size-1)
+		}
+	}
+	return res
+}
+
+// Scale creates an inline copy of t whose steps are all
+// multiplied by s.
+func (t *Table) Scale(s float64) *Table {
+	res := &Table{Steps: make([]float64, len(t.Steps)), Deadzone: t.Deadzone, Bits: t.Bits}
+	for i, step := range t.Steps {
+		res.Steps[i] = step * s
+	}
+	return res
+}
+
+// Rounded returns a copy of t whose steps and deadzone
+// have the float32 precision that WriteTable stores, so
+// that an encoder which quantizes with it uses exactly
+// the steps that the decoder reads back.
+// Registered tables are stored by ID, so they are
+// returned as is.
+func (t *Table) Rounded() *Table {
+	if t.ID != 0 {
+		return t
+	}
+	res := &Table{
+		Steps:    make([]float64, len(t.Steps)),
+		Deadzone: float64(float32(t.Deadzone)),
+		Bits:     t.Bits,
+	}
+	for i, step := range t.Steps {
+		res.Steps[i] = float64(float32(step))
+	}
+	return res
+}
+
+// Validate checks that the Table can be used to code
+// coefficients.
+func (t *Table) Validate() error {
+	if len(t.Steps) == 0 {
+		return errors.New("quantization table has no steps")
+	} else if len(t.Steps) > maxTableSize {
+		return errors.New("quantization table is too large")
+	}
+	for _, s := range t.Steps {
+		if !(s > 0) || math.IsInf(s, 0) {
+			return fmt.Errorf("invalid quantization step: %f", s)
+		}
+	}
+	if math.IsNaN(t.Deadzone) || math.IsInf(t.Deadzone, 0) || t.Deadzone < 0 {
+		return fmt.Errorf("invalid deadzone: %f", t.Deadzone)
+	}
+	if t.Bits < 0 || t.Bits > 32 || t.Bits == 1 {
+		return fmt.Errorf("invalid bits per coefficient: %d", t.Bits)
+	}
+	return nil
+}
+
+// Step returns the step size for a basis index.
+func (t *Table) Step(idx int) float64 {
+	if idx >= len(t.Steps) {
+		return t.Steps[len(t.Steps)-1]
+	} else if idx < 0 {
+		return t.Steps[0]
+	}
+	return t.Steps[idx]
+}
+
+// Quantize converts a coefficient for the given basis
+// index into an integer.
+func (t *Table) Quantize(idx int, val float64) int {
+	step := t.Step(idx)
+	mag := math.Abs(val) / step
+	if mag < t.Deadzone || math.IsNaN(mag) {
+		return 0
+	}
+	q := math.Max(1, math.Floor(mag+0.5))
+	q = math.Min(q, float64(t.maxValue()))
+	if val < 0 {
+		return -int(q)
+	}
+	return int(q)
+}
+
+// Dequantize performs the inverse of Quantize.
+func (t *Table) Dequantize(idx int, q int) float64 {
+	return float64(q) * t.Step(idx)
+}
+
+// Clamp limits a quantized value to the range that the
+// Table can store.
+func (t *Table) Clamp(q int) int {
+	max := t.maxValue()
+	if q > max {
+		return max
+	} else if q < -max {
+		return -max
+	}
+	return q
+}
+
+func (t *Table) maxValue() int {
+	if t.Bits == 0 {
+		return maxGolombValue
+	}
+	return 1<<uint(t.Bits-1) - 1
+}
+
+// WriteTable encodes a Table.
+// Registered tables are encoded by ID.
+func WriteTable(w io.Writer, t *Table) error {
+	if _, err := w.Write([]byte{t.ID}); err != nil {
+		return err
+	}
+	if t.ID != 0 {
+		return nil
+	}
+	fields := []interface{}{
+		uint8(t.Bits),
+		float32(t.Deadzone),
+		uint32(len(t.Steps)),
+	}
+	for _, f := range fields {
+		if err := binary.Write(w, encodingEndian, f); err != nil {
+			return err
+		}
+	}
+	for _, s := range t.Steps {
+		if err := binary.Write(w, encodingEndian, float32(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTable decodes a Table that was encoded with
+// WriteTable.
+func ReadTable(r io.Reader) (*Table, error) {
+	var id, bits uint8
+	if err := binary.Read(r, encodingEndian, &id); err != nil {
+		return nil, errors.New("missing table ID")
+	}
+	if id != 0 {
+		t := Lookup(id)
+		if t == nil {
+			return nil, fmt.Errorf("unknown table ID: %d", id)
+		}
+		return t, nil
+	}
+
+	var deadzone float32
+	var count uint32
+	if err := binary.Read(r, encodingEndian, &bits); err != nil {
+		return nil, errors.New("missing table bit depth")
+	}
+	if err := binary.Read(r, encodingEndian, &deadzone); err != nil {
+		return nil, errors.New("missing table deadzone")
+	}
+	if err := binary.Read(r, encodingEndian, &count); err != nil {
+		return nil, errors.New("missing table size")
+	}
+	if count == 0 || count > maxTableSize {
+		return nil, fmt.Errorf("invalid table size: %d", count)
+	}
+
+	res := &Table{
+		Steps:    make([]float64, count),
+		Deadzone: float64(deadzone),
+		Bits:     int(bits),
+	}
+	for i := range res.Steps {
+		var step float32
+		if err := binary.Read(r, encodingEndian, &step); err != nil {
+			return nil, errors.New("missing table step")
+		}
+		res.Steps[i] = float64(step)
+	}
+	if err := res.Validate(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,34 @@
+package quantize
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRounded(t *testing.T) {
+	table := &Table{Steps: []float64{0.1, 1.0 / 3, 0.7}, Deadzone: 0.6, Bits: 0}
+	var buf bytes.Buffer
+	if err := WriteTable(&buf, table); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ReadTable(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounded := table.Rounded()
+	if !reflect.DeepEqual(rounded, decoded) {
+		t.Errorf("expected %+v but got %+v", decoded, rounded)
+	}
+	if !reflect.DeepEqual(rounded.Rounded(), rounded) {
+		t.Error("rounding is not idempotent")
+	}
+	if rounded.Scale(0.25).Step(1) != decoded.Scale(0.25).Step(1) {
+		t.Error("scaled steps differ")
+	}
+
+	standard := Lookup(StandardTableID)
+	if standard.Rounded() != standard {
+		t.Error("registered tables should be returned as is")
+	}
+}
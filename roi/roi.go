@@ -0,0 +1,128 @@
+// Package roi describes which regions of an image are
+// important, so that encoders can spend more bits on
+// those regions.
+package roi
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/unixpickle/imagecompress/quantize"
+)
+
+const (
+	// Levels is the number of per-block quality levels.
+	// Level 0 has the finest quantization.
+	Levels = 8
+
+	// LevelBits is the number of bits used to store a
+	// level.
+	LevelBits = 3
+
+	// DefaultLevel is the level whose quantization matches
+	// the unscaled quantization table.
+	DefaultLevel = 2
+)
+
+// A Map assigns an importance between 0 and 1 to the
+// pixels of an image, using the coordinates of the image.
+type Map interface {
+	Importance(x, y int) float64
+}
+
+// ImageMap uses the brightness of a grayscale image as a
+// Map, so that white pixels are the most important.
+// Pixels outside of the image have no importance.
+type ImageMap struct {
+	Image image.Image
+}
+
+func (i *ImageMap) Importance(x, y int) float64 {
+	if !(image.Point{x, y}).In(i.Image.Bounds()) {
+		return 0
+	}
+	return float64(color.Gray16Model.Convert(i.Image.At(x, y)).(color.Gray16).Y) / 0xffff
+}
+
+// RectMap is a Map in which the pixels inside any of the
+// rectangles have an importance of 1, and the rest have
+// an importance of Background.
+type RectMap struct {
+	Rects      []image.Rectangle
+	Background float64
+}
+
+func (r *RectMap) Importance(x, y int) float64 {
+	p := image.Point{x, y}
+	for _, rect := range r.Rects {
+		if p.In(rect) {
+			return 1
+		}
+	}
+	return r.Background
+}
+
+// Level converts an importance to a quality level.
+// Importances outside of [0, 1] are clamped, and NaN
+// gives the DefaultLevel.
+func Level(importance float64) int {
+	if math.IsNaN(importance) {
+		return DefaultLevel
+	}
+	importance = math.Min(math.Max(importance, 0), 1)
+	return int(math.Floor((1-importance)*(Levels-1) + 0.5))
+}
+
+// StepScale returns the factor by which a level scales
+// quantization steps.
+// Every level is sqrt(2) coarser than the level before.
+func StepScale(level int) float64 {
+	return math.Pow(2, float64(level-DefaultLevel)/2)
+}
+
+// ScaledTables creates a quantization table for every
+// level by scaling the steps of t.
+func ScaledTables(t *quantize.Table) []*quantize.Table {
+	res := make([]*quantize.Table, Levels)
+	for i := range res {
+		res[i] = t.Scale(StepScale(i))
+	}
+	return res
+}
+
+// BlockLevels computes the quality level of every block
+// of an image, in the order used by blocker.Blocks.
+// There is one level per spatial block, which is shared
+// by the three color channels of the block.
+//
+// A block's level is based on the mean importance of the
+// pixels in the block.
+// If m is nil, every block gets the DefaultLevel.
+func BlockLevels(m Map, bounds image.Rectangle, blockSize int) []int {
+	cols := (bounds.Dx() + blockSize - 1) / blockSize
+	rows := (bounds.Dy() + blockSize - 1) / blockSize
+	res := make([]int, 0, rows*cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			level := DefaultLevel
+			if m != nil {
+				rect := image.Rect(0, 0, blockSize, blockSize).Add(bounds.Min)
+				rect = rect.Add(image.Pt(col*blockSize, row*blockSize)).Intersect(bounds)
+				level = Level(meanImportance(m, rect))
+			}
+			res = append(res, level)
+		}
+	}
+	return res
+}
+
+func meanImportance(m Map, r image.Rectangle) float64 {
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += m.Importance(x, y)
+		}
+	}
+	return sum / float64(r.Dx()*r.Dy())
+}
@@ -0,0 +1,104 @@
+package roi
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestImageMap(t *testing.T) {
+	img := image.NewGray(image.Rect(2, 3, 4, 4))
+	img.SetGray(2, 3, color.Gray{Y: 0xff})
+	img.SetGray(3, 3, color.Gray{Y: 0x33})
+	m := &ImageMap{Image: img}
+	points := []struct {
+		X, Y       int
+		Importance float64
+	}{
+		{2, 3, 1},
+		{3, 3, 0.2},
+		{0, 0, 0},
+		{4, 3, 0},
+	}
+	for _, p := range points {
+		if actual := m.Importance(p.X, p.Y); actual != p.Importance {
+			t.Errorf("(%d, %d): expected %f but got %f", p.X, p.Y, p.Importance, actual)
+		}
+	}
+}
+
+func TestRectMap(t *testing.T) {
+	m := &RectMap{
+		Rects:      []image.Rectangle{image.Rect(0, 0, 2, 2), image.Rect(5, 5, 6, 7)},
+		Background: 0.25,
+	}
+	points := []struct {
+		X, Y       int
+		Importance float64
+	}{
+		{0, 0, 1},
+		{1, 1, 1},
+		{2, 1, 0.25},
+		{5, 6, 1},
+		{5, 7, 0.25},
+		{-1, 0, 0.25},
+	}
+	for _, p := range points {
+		if actual := m.Importance(p.X, p.Y); actual != p.Importance {
+			t.Errorf("(%d, %d): expected %f but got %f", p.X, p.Y, p.Importance, actual)
+		}
+	}
+}
+
+func TestBlockLevels(t *testing.T) {
+	bounds := image.Rect(10, 20, 19, 24)
+
+	// Three columns and one row of blocks, the last of
+	// which is cut off by the edge of the image.
+	if levels := BlockLevels(nil, bounds, 4); !reflect.DeepEqual(levels, []int{2, 2, 2}) {
+		t.Errorf("nil map: unexpected levels %v", levels)
+	}
+
+	m := &RectMap{
+		Rects: []image.Rectangle{
+			// The first block.
+			image.Rect(10, 20, 14, 24),
+
+			// Half of the second block.
+			image.Rect(14, 20, 16, 24),
+
+			// The entire (single column) last block.
+			image.Rect(18, 20, 19, 24),
+		},
+	}
+	expected := []int{0, Level(0.5), 0}
+	if levels := BlockLevels(m, bounds, 4); !reflect.DeepEqual(levels, expected) {
+		t.Errorf("expected levels %v but got %v", expected, levels)
+	}
+
+	if levels := BlockLevels(m, image.Rect(0, 0, 0, 0), 4); len(levels) != 0 {
+		t.Errorf("empty image: unexpected levels %v", levels)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	if Level(1) != 0 || Level(2) != 0 {
+		t.Error("full importance should give level 0")
+	}
+	if Level(0) != Levels-1 || Level(-1) != Levels-1 {
+		t.Errorf("no importance should give level %d", Levels-1)
+	}
+	for i := 1; i < 100; i++ {
+		if Level(float64(i)/100) > Level(float64(i-1)/100) {
+			t.Fatalf("level increases with importance at %d%%", i)
+		}
+	}
+	if Level(math.NaN()) != DefaultLevel {
+		t.Errorf("NaN should give level %d", DefaultLevel)
+	}
+	if Level(math.Inf(1)) != 0 || Level(math.Inf(-1)) != Levels-1 {
+		t.Error("infinite importances should be clamped")
+	}
+}
@@ -0,0 +1,107 @@
+package roi
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// edgeThreshold is the gradient magnitude (with
+	// luminance between 0 and 1) above which a pixel is
+	// considered part of an edge.
+	edgeThreshold = 0.08
+
+	// fullContrast is the standard deviation of luminance
+	// at which a block is considered to have full contrast.
+	fullContrast = 0.1
+)
+
+// A BlockMap is a Map with one importance for each
+// square block of an image.
+type BlockMap struct {
+	Bounds    image.Rectangle
+	BlockSize int
+
+	// Values contains the importance of each block, row
+	// by row.
+	Values []float64
+}
+
+func (b *BlockMap) Importance(x, y int) float64 {
+	if !(image.Point{x, y}).In(b.Bounds) {
+		return 0
+	}
+	cols := (b.Bounds.Dx() + b.BlockSize - 1) / b.BlockSize
+	col := (x - b.Bounds.Min.X) / b.BlockSize
+	row := (y - b.Bounds.Min.Y) / b.BlockSize
+	return b.Values[row*cols+col]
+}
+
+// Saliency estimates the perceptual importance of each
+// block of an image.
+//
+// Errors are most visible in flat regions and along
+// isolated edges, while busy textures mask them.
+// Thus, blocks with high contrast but few edge pixels get
+// the highest importance, flat blocks get an importance
+// corresponding to the DefaultLevel, and blocks that are
+// dense with edges get the lowest importance.
+func Saliency(img image.Image, blockSize int) *BlockMap {
+	b := img.Bounds()
+	lum := luminance(img)
+	cols := (b.Dx() + blockSize - 1) / blockSize
+	rows := (b.Dy() + blockSize - 1) / blockSize
+	res := &BlockMap{Bounds: b, BlockSize: blockSize, Values: make([]float64, rows*cols)}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			rect := image.Rect(col*blockSize, row*blockSize, (col+1)*blockSize,
+				(row+1)*blockSize).Intersect(image.Rect(0, 0, b.Dx(), b.Dy()))
+			res.Values[row*cols+col] = blockSaliency(lum, b.Dx(), b.Dy(), rect)
+		}
+	}
+	return res
+}
+
+func blockSaliency(lum []float64, w, h int, r image.Rectangle) float64 {
+	var sum, sqSum float64
+	var edges int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			val := lum[y*w+x]
+			sum += val
+			sqSum += val * val
+			var dx, dy float64
+			if x+1 < w {
+				dx = lum[y*w+x+1] - val
+			}
+			if y+1 < h {
+				dy = lum[(y+1)*w+x] - val
+			}
+			if math.Abs(dx)+math.Abs(dy) > edgeThreshold {
+				edges++
+			}
+		}
+	}
+	n := float64(r.Dx() * r.Dy())
+	mean := sum / n
+	contrast := math.Sqrt(math.Max(sqSum/n-mean*mean, 0))
+
+	masking := math.Min(2*float64(edges)/n, 1)
+	structure := math.Min(contrast/fullContrast, 1) * (1 - masking)
+	defaultImportance := 1 - float64(DefaultLevel)/(Levels-1)
+	return math.Min(math.Max(defaultImportance+0.3*structure-0.4*masking, 0), 1)
+}
+
+// luminance computes the luminance of every pixel of an
+// image, between 0 and 1, row by row.
+func luminance(img image.Image) []float64 {
+	b := img.Bounds()
+	res := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			res = append(res, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/0xffff)
+		}
+	}
+	return res
+}
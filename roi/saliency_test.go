@@ -0,0 +1,70 @@
+package roi
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSaliency(t *testing.T) {
+	// Three 8x8 blocks, starting away from the origin: a
+	// checkerboard, which is all edges, a block with a
+	// single vertical edge, and a flat block.
+	// Gradients look right and down, so the blocks are
+	// arranged such that no edge crosses into the last two.
+	img := image.NewGray(image.Rect(5, 7, 29, 15))
+	for y := 7; y < 15; y++ {
+		for x := 5; x < 29; x++ {
+			val := uint8(0xff)
+			if x < 13 && (x+y)%2 == 0 {
+				val = 0
+			} else if x >= 13 && x < 17 {
+				val = 0x80
+			}
+			img.SetGray(x, y, color.Gray{Y: val})
+		}
+	}
+	m := Saliency(img, 8)
+	if m.Bounds != img.Bounds() || m.BlockSize != 8 || len(m.Values) != 3 {
+		t.Fatalf("unexpected map layout: %v %d %d", m.Bounds, m.BlockSize, len(m.Values))
+	}
+	levels := BlockLevels(m, img.Bounds(), 8)
+	if levels[0] <= DefaultLevel {
+		t.Errorf("checkerboard should be coarser than %d but got %d", DefaultLevel,
+			levels[0])
+	}
+	if levels[1] >= DefaultLevel {
+		t.Errorf("block with an edge should be finer than %d but got %d", DefaultLevel,
+			levels[1])
+	}
+	if levels[2] != DefaultLevel {
+		t.Errorf("flat block should have level %d but got %d", DefaultLevel, levels[2])
+	}
+}
+
+func TestBlockMap(t *testing.T) {
+	m := &BlockMap{
+		Bounds:    image.Rect(3, 4, 8, 7),
+		BlockSize: 2,
+		Values:    []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6},
+	}
+	points := []struct {
+		X, Y       int
+		Importance float64
+	}{
+		{3, 4, 0.1},
+		{4, 5, 0.1},
+		{5, 4, 0.2},
+		{7, 5, 0.3},
+		{3, 6, 0.4},
+		{7, 6, 0.6},
+		{8, 6, 0},
+		{2, 4, 0},
+		{3, 7, 0},
+	}
+	for _, p := range points {
+		if actual := m.Importance(p.X, p.Y); actual != p.Importance {
+			t.Errorf("(%d, %d): expected %f but got %f", p.X, p.Y, p.Importance, actual)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package sequence
+
+import (
+	"image"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/smallbasis"
+	"github.com/unixpickle/imagecompress/vq"
+)
+
+func fuzzCompressors() []*Compressor {
+	shared := smallbasis.NewCompressorBlockSize(0.5, 4)
+	shared.DecodeOptions = testutil.FuzzOptions
+	plain := vq.NewCompressorSize(16, 4)
+	plain.Iterations = 2
+	plain.DecodeOptions = testutil.FuzzOptions
+	var res []*Compressor
+	for _, codec := range []Codec{shared, plain} {
+		c := NewCompressor(codec)
+		c.MotionBlockSize = 4
+		c.SearchRange = 2
+		c.KeyInterval = 2
+		c.DecodeOptions = testutil.FuzzOptions
+		res = append(res, c)
+	}
+	return res
+}
+
+func FuzzDecompress(f *testing.F) {
+	compressors := fuzzCompressors()
+	img := testutil.Image(13, 10)
+	seq := &Sequence{
+		Frames: []image.Image{img, shiftImage(img, 1, 0), shiftImage(img, 1, 2)},
+		Delays: []int{3, 4, 5},
+	}
+	for i, c := range compressors {
+		data, err := c.Compress(seq)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(uint8(i), data)
+	}
+	f.Fuzz(func(t *testing.T, which uint8, data []byte) {
+		c := compressors[int(which)%len(compressors)]
+		s, err := c.Decompress(data)
+		if err != nil {
+			return
+		}
+		if len(s.Frames) != len(s.Delays) {
+			t.Fatalf("got %d frames but %d delays", len(s.Frames), len(s.Delays))
+		}
+		for _, frame := range s.Frames {
+			testutil.CheckFuzzBounds(t, frame.Bounds())
+		}
+	})
+}
+
+// shiftImage moves the contents of an image, wrapping
+// around at the edges.
+func shiftImage(img image.Image, dx, dy int) image.Image {
+	b := img.Bounds()
+	res := image.NewRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			res.Set(x, y, img.At((x+dx)%b.Dx(), (y+dy)%b.Dy()))
+		}
+	}
+	return res
+}
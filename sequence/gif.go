@@ -0,0 +1,66 @@
+package sequence
+
+import (
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+)
+
+// FromGIF renders the frames of an animated GIF, taking
+// the disposal method of each frame into account.
+func FromGIF(g *gif.GIF) *Sequence {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(bounds)
+	res := &Sequence{Delays: make([]int, len(g.Image))}
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var saved *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			saved = image.NewRGBA(bounds)
+			copy(saved.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		rendered := image.NewRGBA(bounds)
+		copy(rendered.Pix, canvas.Pix)
+		res.Frames = append(res.Frames, rendered)
+		if i < len(g.Delay) {
+			res.Delays[i] = g.Delay[i]
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+	return res
+}
+
+// GIF converts a sequence to an animated GIF, dithering
+// each frame to a standard palette.
+func (s *Sequence) GIF() *gif.GIF {
+	res := &gif.GIF{}
+	for i, frame := range s.Frames {
+		b := frame.Bounds()
+		paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, b.Min)
+		res.Image = append(res.Image, paletted)
+		var delay int
+		if s.Delays != nil {
+			delay = s.Delays[i]
+		}
+		res.Delay = append(res.Delay, delay)
+	}
+	return res
+}
@@ -0,0 +1,79 @@
+package sequence
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestFromGIF(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	pal := color.Palette{color.RGBA{}, red, blue, green, white}
+	frame := func(r image.Rectangle, c color.Color) *image.Paletted {
+		res := image.NewPaletted(r, pal)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				res.Set(x, y, c)
+			}
+		}
+		return res
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			frame(image.Rect(0, 0, 4, 4), red),
+			frame(image.Rect(0, 0, 2, 2), blue),
+			frame(image.Rect(2, 2, 4, 4), green),
+			frame(image.Rect(3, 3, 4, 4), white),
+		},
+		Delay: []int{10, 20, 30, 40},
+		Disposal: []byte{
+			gif.DisposalNone,
+			gif.DisposalBackground,
+			gif.DisposalPrevious,
+			gif.DisposalNone,
+		},
+		Config: image.Config{Width: 4, Height: 4},
+	}
+	s := FromGIF(g)
+	if len(s.Frames) != 4 {
+		t.Fatalf("expected 4 frames but got %d", len(s.Frames))
+	}
+	checks := []struct {
+		Frame int
+		X, Y  int
+		Color color.RGBA
+	}{
+		{0, 0, 0, red},
+		{0, 3, 3, red},
+
+		{1, 0, 0, blue},
+		{1, 2, 2, red},
+
+		// The blue frame was cleared to the background.
+		{2, 0, 0, color.RGBA{}},
+		{2, 2, 2, green},
+		{2, 3, 0, red},
+
+		// The green frame was undone, but the background
+		// disposal of the blue frame was not.
+		{3, 0, 0, color.RGBA{}},
+		{3, 2, 2, red},
+		{3, 3, 3, white},
+	}
+	for _, c := range checks {
+		actual := color.RGBAModel.Convert(s.Frames[c.Frame].At(c.X, c.Y))
+		if actual != c.Color {
+			t.Errorf("frame %d (%d, %d): expected %v but got %v", c.Frame, c.X, c.Y, c.Color,
+				actual)
+		}
+	}
+	for i, delay := range []int{10, 20, 30, 40} {
+		if s.Delays[i] != delay {
+			t.Errorf("frame %d: expected delay %d but got %d", i, delay, s.Delays[i])
+		}
+	}
+}
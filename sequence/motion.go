@@ -0,0 +1,262 @@
+package sequence
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/unixpickle/imagecompress/parallel"
+)
+
+// intraLevel is the prediction for every channel of an
+// intra block, which makes the residual of the block
+// equal to the block itself.
+const intraLevel = 128
+
+// A motionVector specifies how a block of a frame is
+// predicted.
+//
+// Inter blocks are copied from the previous frame, offset
+// by (DX, DY), while intra blocks are not predicted at all.
+type motionVector struct {
+	Intra  bool
+	DX, DY int
+}
+
+func motionBlockCount(width, height, blockSize int) int {
+	cols := (width + blockSize - 1) / blockSize
+	rows := (height + blockSize - 1) / blockSize
+	return cols * rows
+}
+
+// searchMotion finds a motion vector for every block of a
+// frame, given the previous frame.
+//
+// Each block is compared against every offset within the
+// search range, preferring smaller offsets on ties.
+// A block is coded as intra if it varies less on its own
+// than the best residual does.
+func searchMotion(cur, prev *image.RGBA, blockSize, searchRange, concurrency int) []motionVector {
+	b := cur.Bounds()
+	cols := (b.Dx() + blockSize - 1) / blockSize
+	res := make([]motionVector, motionBlockCount(b.Dx(), b.Dy(), blockSize))
+	parallel.For(len(res), concurrency, func(i int) {
+		rect := image.Rect(0, 0, blockSize, blockSize).Add(image.Pt(i%cols, i/cols).Mul(blockSize))
+		rect = rect.Intersect(b)
+
+		var best motionVector
+		bestCost := -1
+		for radius := 0; radius <= searchRange; radius++ {
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if dx != -radius && dx != radius && dy != -radius && dy != radius {
+						// Only visit the ring at this radius.
+						continue
+					}
+					cost := blockSAD(cur, prev, rect, dx, dy, bestCost)
+					if bestCost < 0 || cost < bestCost {
+						best = motionVector{DX: dx, DY: dy}
+						bestCost = cost
+					}
+				}
+			}
+		}
+
+		interCost := residualVariation(cur, prev, rect, best.DX, best.DY)
+		if variation(cur, rect) < interCost {
+			best = motionVector{Intra: true}
+		}
+		res[i] = best
+	})
+	return res
+}
+
+// blockSAD computes the sum of absolute differences
+// between a block and an offset block of a reference.
+// It stops early once the sum exceeds limit, if limit is
+// non-negative.
+func blockSAD(cur, ref *image.RGBA, rect image.Rectangle, dx, dy, limit int) int {
+	var sum int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			p1 := pixel(cur, x, y)
+			p2 := pixel(ref, x+dx, y+dy)
+			for c := 0; c < 3; c++ {
+				sum += absInt(int(p1[c]) - int(p2[c]))
+			}
+		}
+		if limit >= 0 && sum > limit {
+			break
+		}
+	}
+	return sum
+}
+
+// residualVariation measures how expensive the residual
+// of an inter block is likely to be, as the sum of the
+// absolute deviations of the residual from its mean.
+func residualVariation(cur, ref *image.RGBA, rect image.Rectangle, dx, dy int) int {
+	diff := func(x, y, c int) int {
+		return int(pixel(cur, x, y)[c]) - int(pixel(ref, x+dx, y+dy)[c])
+	}
+	return deviation(rect, diff)
+}
+
+// variation is like residualVariation, but for the block
+// itself rather than a residual.
+func variation(cur *image.RGBA, rect image.Rectangle) int {
+	return deviation(rect, func(x, y, c int) int {
+		return int(pixel(cur, x, y)[c])
+	})
+}
+
+func deviation(rect image.Rectangle, f func(x, y, c int) int) int {
+	n := rect.Dx() * rect.Dy()
+	var res int
+	for c := 0; c < 3; c++ {
+		var sum int
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				sum += f(x, y, c)
+			}
+		}
+		mean := sum / n
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				res += absInt(f(x, y, c) - mean)
+			}
+		}
+	}
+	return res
+}
+
+// predictFrame builds the prediction of a frame from the
+// previous frame and the motion vectors of its blocks.
+func predictFrame(prev *image.RGBA, vectors []motionVector, blockSize int) *image.RGBA {
+	b := prev.Bounds()
+	res := image.NewRGBA(b)
+	cols := (b.Dx() + blockSize - 1) / blockSize
+	for i, v := range vectors {
+		rect := image.Rect(0, 0, blockSize, blockSize).Add(image.Pt(i%cols, i/cols).Mul(blockSize))
+		rect = rect.Intersect(b)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				dst := pixel(res, x, y)
+				if v.Intra {
+					dst[0], dst[1], dst[2] = intraLevel, intraLevel, intraLevel
+				} else {
+					copy(dst[:3], pixel(prev, x+v.DX, y+v.DY))
+				}
+				dst[3] = 0xff
+			}
+		}
+	}
+	return res
+}
+
+// residualImage encodes the difference between a frame
+// and its prediction as an image, offset by intraLevel.
+//
+// Differences outside of the range of a byte are clipped.
+// The next predicted frame sees the clipped result in its
+// reference and corrects it, but nothing corrects the
+// last frame or a frame right before a key frame.
+func residualImage(frame, pred *image.RGBA) *image.RGBA {
+	res := image.NewRGBA(frame.Bounds())
+	for i := 0; i < len(res.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			diff := int(frame.Pix[i+c]) - int(pred.Pix[i+c]) + intraLevel
+			res.Pix[i+c] = clampByte(diff)
+		}
+		res.Pix[i+3] = 0xff
+	}
+	return res
+}
+
+// applyResidual inverts residualImage.
+func applyResidual(pred, residual *image.RGBA) *image.RGBA {
+	res := image.NewRGBA(pred.Bounds())
+	for i := 0; i < len(res.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			val := int(pred.Pix[i+c]) + int(residual.Pix[i+c]) - intraLevel
+			res.Pix[i+c] = clampByte(val)
+		}
+		res.Pix[i+3] = 0xff
+	}
+	return res
+}
+
+func writeMotionVectors(w *bytes.Buffer, vectors []motionVector) {
+	for _, v := range vectors {
+		if v.Intra {
+			w.Write([]byte{1, 0, 0})
+		} else {
+			w.Write([]byte{0, byte(int8(v.DX)), byte(int8(v.DY))})
+		}
+	}
+}
+
+func readMotionVectors(r *bytes.Reader, count, searchRange int) ([]motionVector, error) {
+	// Each vector takes three bytes, which bounds the
+	// count before anything is allocated.
+	if count > r.Len()/3 {
+		return nil, errors.New("failed to read motion vectors: unexpected EOF")
+	}
+	res := make([]motionVector, 0, count)
+	var data [3]byte
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(r, data[:]); err != nil {
+			return nil, errors.New("failed to read motion vectors: " + err.Error())
+		}
+		v := motionVector{Intra: data[0] == 1, DX: int(int8(data[1])), DY: int(int8(data[2]))}
+		if data[0] > 1 || absInt(v.DX) > searchRange || absInt(v.DY) > searchRange {
+			return nil, errors.New("invalid motion vector")
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// pixel returns the RGBA components of a pixel, clamping
+// the coordinates to the bounds of the image.
+func pixel(img *image.RGBA, x, y int) []uint8 {
+	b := img.Bounds()
+	x = clampInt(x, b.Min.X, b.Max.X-1)
+	y = clampInt(y, b.Min.Y, b.Max.Y-1)
+	idx := img.PixOffset(x, y)
+	return img.Pix[idx : idx+4]
+}
+
+// toRGBA converts an image to an opaque RGBA image with
+// its top-left corner at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Bounds(), img, b.Min, draw.Src)
+	for i := 3; i < len(res.Pix); i += 4 {
+		res.Pix[i] = 0xff
+	}
+	return res
+}
+
+func clampByte(x int) uint8 {
+	return uint8(clampInt(x, 0, 0xff))
+}
+
+func clampInt(x, min, max int) int {
+	if x < min {
+		return min
+	} else if x > max {
+		return max
+	}
+	return x
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
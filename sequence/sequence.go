@@ -0,0 +1,366 @@
+// Package sequence compresses animations and other image
+// sequences by predicting each frame from the previous
+// one.
+package sequence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/unixpickle/imagecompress/batch"
+	"github.com/unixpickle/imagecompress/limits"
+)
+
+const (
+	DefaultMotionBlockSize = 16
+	DefaultSearchRange     = 7
+	DefaultKeyInterval     = 30
+
+	// MaxSearchRange is the largest supported SearchRange,
+	// since motion vectors are stored as signed bytes.
+	MaxSearchRange = 127
+)
+
+const fileMagic = "ICSQ"
+
+const (
+	keyFrame       = 0
+	predictedFrame = 1
+)
+
+var encodingEndian = binary.LittleEndian
+
+// A Codec compresses individual frames.
+type Codec interface {
+	Compress(i image.Image) []byte
+	Decompress(d []byte) (image.Image, error)
+}
+
+// A Sequence is a list of frames with the same
+// dimensions.
+type Sequence struct {
+	Frames []image.Image
+
+	// Delays, if non-nil, contains the time that each frame
+	// is displayed for, in hundredths of a second, as in
+	// the image/gif package.
+	Delays []int
+}
+
+// A Compressor compresses image sequences.
+//
+// Key frames are compressed with the Codec on their own.
+// Every other frame is predicted from the previous
+// (decoded) frame by moving its blocks around, and only
+// the motion vectors and the residual of the prediction
+// are stored, with the residual compressed by the Codec.
+//
+// If the Codec is also a batch.SharedCodec, a single
+// basis is trained on the whole sequence and stored once.
+//
+// Residuals are stored around a gray level of 128, so the
+// residual of a pixel that brightens or darkens by more
+// than that is clipped.
+// The next predicted frame corrects the clipped pixels,
+// but the last frame and the frames right before key
+// frames keep the error.
+//
+// Frames are stored as opaque RGB images; any alpha
+// channel is discarded.
+type Compressor struct {
+	Codec Codec
+
+	// MotionBlockSize is the side length of the blocks that
+	// are moved to predict frames.
+	MotionBlockSize int
+
+	// SearchRange is the largest horizontal or vertical
+	// offset that blocks are moved by, up to MaxSearchRange.
+	SearchRange int
+
+	// KeyInterval is the number of frames between key
+	// frames.
+	// If it is 0, only the first frame is a key frame.
+	KeyInterval int
+
+	// Concurrency is the maximum number of goroutines used
+	// for the motion search.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	Concurrency int
+
+	// DecodeOptions limits the size of decoded sequences.
+	// Frames themselves are decoded by the Codec, which
+	// should enforce its own limits.
+	DecodeOptions limits.DecodeOptions
+}
+
+// NewCompressor creates a Compressor with the default
+// motion search and key frame settings.
+func NewCompressor(codec Codec) *Compressor {
+	return &Compressor{
+		Codec:           codec,
+		MotionBlockSize: DefaultMotionBlockSize,
+		SearchRange:     DefaultSearchRange,
+		KeyInterval:     DefaultKeyInterval,
+	}
+}
+
+type header struct {
+	Width       uint32
+	Height      uint32
+	FrameCount  uint32
+	BlockSize   uint32
+	SearchRange uint32
+	BasisLength uint32
+}
+
+type frameHeader struct {
+	Kind  uint8
+	Delay uint32
+}
+
+// minFrameSize is the size of a frame header and the
+// length of the frame data.
+const minFrameSize = 1 + 4 + 4
+
+// Compress compresses a sequence.
+func (c *Compressor) Compress(s *Sequence) ([]byte, error) {
+	if err := c.checkSequence(s); err != nil {
+		return nil, err
+	}
+	frames := make([]*image.RGBA, len(s.Frames))
+	for i, frame := range s.Frames {
+		frames[i] = toRGBA(frame)
+	}
+
+	var basis []byte
+	if shared, ok := c.Codec.(batch.SharedCodec); ok {
+		basis = shared.TrainSharedBasis(trainingImages(frames))
+	}
+
+	b := frames[0].Bounds()
+	h := header{
+		Width:       uint32(b.Dx()),
+		Height:      uint32(b.Dy()),
+		FrameCount:  uint32(len(frames)),
+		BlockSize:   uint32(c.MotionBlockSize),
+		SearchRange: uint32(c.SearchRange),
+		BasisLength: uint32(len(basis)),
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	binary.Write(&buf, encodingEndian, h)
+	buf.Write(basis)
+
+	var prev *image.RGBA
+	for i, frame := range frames {
+		fh := frameHeader{Kind: keyFrame}
+		if s.Delays != nil {
+			fh.Delay = uint32(s.Delays[i])
+		}
+		var vectors []motionVector
+		var pred *image.RGBA
+		target := frame
+		if !c.isKeyFrame(i) {
+			fh.Kind = predictedFrame
+			vectors = searchMotion(frame, prev, c.MotionBlockSize, c.SearchRange, c.Concurrency)
+			pred = predictFrame(prev, vectors, c.MotionBlockSize)
+			target = residualImage(frame, pred)
+		}
+
+		data, err := c.compressFrame(basis, target)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := c.decompressFrame(basis, data, b.Dx(), b.Dy())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %s", i, err)
+		}
+		if pred == nil {
+			prev = decoded
+		} else {
+			prev = applyResidual(pred, decoded)
+		}
+
+		binary.Write(&buf, encodingEndian, fh)
+		writeMotionVectors(&buf, vectors)
+		binary.Write(&buf, encodingEndian, uint32(len(data)))
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decodes a sequence that was encoded by
+// Compress.
+func (c *Compressor) Decompress(d []byte) (*Sequence, error) {
+	r := bytes.NewReader(d)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return nil, errors.New("not an image sequence")
+	}
+	var h header
+	if err := binary.Read(r, encodingEndian, &h); err != nil {
+		return nil, errors.New("failed to read header: " + err.Error())
+	}
+	if h.FrameCount == 0 {
+		return nil, errors.New("sequence has no frames")
+	} else if h.BlockSize == 0 {
+		return nil, errors.New("invalid motion block size")
+	} else if h.SearchRange > MaxSearchRange {
+		return nil, errors.New("invalid search range")
+	}
+	w, ht := uint64(h.Width), uint64(h.Height)
+	if err := c.DecodeOptions.CheckPixels(w, ht); err != nil {
+		return nil, err
+	}
+	frameBytes := limits.Product(w, ht, 4)
+	err := c.DecodeOptions.CheckMemory(limits.Product(frameBytes, uint64(h.FrameCount)))
+	if err != nil {
+		return nil, err
+	}
+	if int64(h.BasisLength) > int64(r.Len()) {
+		return nil, errors.New("failed to read shared basis: unexpected EOF")
+	}
+	var basis []byte
+	if h.BasisLength > 0 {
+		if _, ok := c.Codec.(batch.SharedCodec); !ok {
+			return nil, errors.New("sequence requires a shared codec")
+		}
+		basis = make([]byte, h.BasisLength)
+		io.ReadFull(r, basis)
+	}
+	if int64(h.FrameCount)*minFrameSize > int64(r.Len()) {
+		return nil, errors.New("frame count exceeds data size")
+	}
+
+	width, height := int(h.Width), int(h.Height)
+	blockSize := int(h.BlockSize)
+	res := &Sequence{
+		Frames: make([]image.Image, h.FrameCount),
+		Delays: make([]int, h.FrameCount),
+	}
+	var prev *image.RGBA
+	for i := range res.Frames {
+		var fh frameHeader
+		if err := binary.Read(r, encodingEndian, &fh); err != nil {
+			return nil, fmt.Errorf("failed to read frame %d: %s", i, err)
+		}
+		res.Delays[i] = int(fh.Delay)
+
+		var vectors []motionVector
+		if fh.Kind == predictedFrame {
+			if prev == nil {
+				return nil, errors.New("first frame is not a key frame")
+			}
+			count := motionBlockCount(width, height, blockSize)
+			vectors, err = readMotionVectors(r, count, int(h.SearchRange))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read frame %d: %s", i, err)
+			}
+		} else if fh.Kind != keyFrame {
+			return nil, fmt.Errorf("unknown type for frame %d", i)
+		}
+
+		var length uint32
+		if err := binary.Read(r, encodingEndian, &length); err != nil {
+			return nil, fmt.Errorf("failed to read frame %d: %s", i, err)
+		}
+		if int64(length) > int64(r.Len()) {
+			return nil, fmt.Errorf("failed to read frame %d: unexpected EOF", i)
+		}
+		data := make([]byte, length)
+		io.ReadFull(r, data)
+		decoded, err := c.decompressFrame(basis, data, width, height)
+		if _, ok := err.(*limits.LimitError); ok {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode frame %d: %s", i, err)
+		}
+
+		if vectors == nil {
+			prev = decoded
+		} else {
+			prev = applyResidual(predictFrame(prev, vectors, blockSize), decoded)
+		}
+		res.Frames[i] = prev
+	}
+	return res, nil
+}
+
+func (c *Compressor) checkSequence(s *Sequence) error {
+	if len(s.Frames) == 0 {
+		return errors.New("sequence has no frames")
+	} else if s.Delays != nil && len(s.Delays) != len(s.Frames) {
+		return errors.New("delay count does not match frame count")
+	} else if c.MotionBlockSize < 1 {
+		return errors.New("motion block size must be positive")
+	} else if c.SearchRange < 0 || c.SearchRange > MaxSearchRange {
+		return errors.New("search range out of bounds")
+	} else if c.KeyInterval < 0 {
+		return errors.New("key interval must not be negative")
+	}
+	b := s.Frames[0].Bounds()
+	if uint64(b.Dx()) > math.MaxUint32 || uint64(b.Dy()) > math.MaxUint32 {
+		return errors.New("frames are too large")
+	}
+	for _, frame := range s.Frames[1:] {
+		if frame.Bounds().Size() != b.Size() {
+			return errors.New("frames have different dimensions")
+		}
+	}
+	for _, delay := range s.Delays {
+		if delay < 0 || uint64(delay) > math.MaxUint32 {
+			return errors.New("delay out of bounds")
+		}
+	}
+	return nil
+}
+
+func (c *Compressor) isKeyFrame(idx int) bool {
+	if c.KeyInterval == 0 {
+		return idx == 0
+	}
+	return idx%c.KeyInterval == 0
+}
+
+func (c *Compressor) compressFrame(basis []byte, img image.Image) ([]byte, error) {
+	if basis != nil {
+		return c.Codec.(batch.SharedCodec).CompressShared(basis, img)
+	}
+	return c.Codec.Compress(img), nil
+}
+
+// decompressFrame decodes a frame or residual and checks
+// its dimensions.
+func (c *Compressor) decompressFrame(basis, data []byte, width, height int) (*image.RGBA, error) {
+	var img image.Image
+	var err error
+	if basis != nil {
+		img, err = c.Codec.(batch.SharedCodec).DecompressShared(basis, data)
+	} else {
+		img, err = c.Codec.Decompress(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+		return nil, errors.New("unexpected frame dimensions")
+	}
+	return toRGBA(img), nil
+}
+
+// trainingImages approximates the images that are
+// compressed for a sequence, namely the frames themselves
+// and the residuals between consecutive frames.
+func trainingImages(frames []*image.RGBA) []image.Image {
+	res := []image.Image{frames[0]}
+	for i := 1; i < len(frames); i++ {
+		res = append(res, frames[i], residualImage(frames[i], frames[i-1]))
+	}
+	return res
+}
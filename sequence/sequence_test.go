@@ -0,0 +1,108 @@
+package sequence
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+	"github.com/unixpickle/imagecompress/vq"
+)
+
+func TestRoundTrip(t *testing.T) {
+	shared := smallbasis.NewCompressorBlockSize(0.5, 4)
+	shared.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	plain := vq.NewCompressorSize(256, 4)
+	codecs := map[string]Codec{"shared": shared, "plain": plain}
+
+	img := testutil.Image(37, 29)
+	seq := &Sequence{
+		Frames: []image.Image{
+			img,
+			shiftImage(img, 1, 0),
+			shiftImage(img, 2, 1),
+			shiftImage(img, 4, 1),
+			shiftImage(img, 5, 3),
+		},
+		Delays: []int{1, 2, 3, 4, 5},
+	}
+	for name, codec := range codecs {
+		for _, keyInterval := range []int{0, 1, 3} {
+			t.Run(fmt.Sprintf("%s/%d", name, keyInterval), func(t *testing.T) {
+				c := NewCompressor(codec)
+				c.MotionBlockSize = 8
+				c.KeyInterval = keyInterval
+				data, err := c.Compress(seq)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := c.Decompress(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(decoded.Frames) != len(seq.Frames) {
+					t.Fatalf("expected %d frames but got %d", len(seq.Frames),
+						len(decoded.Frames))
+				}
+				for i, frame := range decoded.Frames {
+					if decoded.Delays[i] != seq.Delays[i] {
+						t.Errorf("frame %d: expected delay %d but got %d", i, seq.Delays[i],
+							decoded.Delays[i])
+					}
+					if frame.Bounds() != img.Bounds() {
+						t.Fatalf("frame %d: bad bounds %v", i, frame.Bounds())
+					}
+					// Predicted frames should not drift away from
+					// the original frames.
+					if e := testutil.MeanError(seq.Frames[i], frame, img.Bounds()); e > 6 {
+						t.Errorf("frame %d: mean error %f", i, e)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSearchMotion(t *testing.T) {
+	const blockSize = 8
+	prev := toRGBA(testutil.Image(40, 32))
+	cur := toRGBA(shiftImage(prev, 3, 2))
+	vectors := searchMotion(cur, prev, blockSize, 4, 0)
+	cols := 40 / blockSize
+	for i, v := range vectors {
+		col, row := i%cols, i/cols
+		if (col+1)*blockSize+3 > 40 || (row+1)*blockSize+2 > 32 {
+			// The block wraps around the edge of the image.
+			continue
+		}
+		if v != (motionVector{DX: 3, DY: 2}) {
+			t.Errorf("block (%d, %d): expected offset (3, 2) but got %+v", col, row, v)
+		}
+	}
+
+	// The offset blocks predict the shifted frame exactly.
+	pred := predictFrame(prev, vectors, blockSize)
+	if e := testutil.MeanError(cur, pred, image.Rect(0, 0, 32, 24)); e != 0 {
+		t.Errorf("prediction has mean error %f", e)
+	}
+}
+
+func TestReadMotionVectorsCount(t *testing.T) {
+	data := bytes.NewReader([]byte{0, 1, 1, 1, 0, 0})
+	if _, err := readMotionVectors(data, 1<<30, 1); err == nil {
+		t.Error("expected an error for a count larger than the data")
+	}
+	data = bytes.NewReader([]byte{0, 1, 0xff, 1, 0, 0})
+	vectors, err := readMotionVectors(data, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []motionVector{{DX: 1, DY: -1}, {Intra: true}}
+	if !reflect.DeepEqual(vectors, expected) {
+		t.Errorf("expected %v but got %v", expected, vectors)
+	}
+}
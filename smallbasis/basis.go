@@ -1,8 +1,10 @@
 package smallbasis
 
 import (
+	"errors"
 	"math"
 
+	"github.com/unixpickle/imagecompress/blocker"
 	"github.com/unixpickle/num-analysis/linalg"
 )
 
@@ -39,6 +41,9 @@ func BasisMatrix(size int) *linalg.Matrix {
 // generating a new orthogonal matrix [A -A; A A].
 // As a base case, OrthoBasis(1) is the 1x1 identity.
 func OrthoBasis(size int) *linalg.Matrix {
+	if !isPowerOfTwo(size) {
+		panic("size is not a power of two")
+	}
 	if size == 1 {
 		res := linalg.NewMatrix(1, 1)
 		res.Set(0, 0, 1)
@@ -46,9 +51,6 @@ func OrthoBasis(size int) *linalg.Matrix {
 	}
 
 	half := size / 2
-	if half*2 != size {
-		panic("size is not a power of two")
-	}
 	subMatrix := OrthoBasis(half)
 
 	res := linalg.NewMatrix(size, size)
@@ -65,6 +67,113 @@ func OrthoBasis(size int) *linalg.Matrix {
 	return res
 }
 
+// OrthoBasisChecked is like OrthoBasis, but it returns an
+// error if the size is not a power of two.
+func OrthoBasisChecked(size int) (*linalg.Matrix, error) {
+	if !isPowerOfTwo(size) {
+		return nil, errors.New("size is not a power of two")
+	}
+	return OrthoBasis(size), nil
+}
+
+// DCTBasis generates an orthonormal basis of 2D DCT-II
+// functions for blocks with the given side-length.
+//
+// The rows of the matrix are laid out in the order used
+// by blocker.Blocks, and the columns are sorted from the
+// lowest horizontal and vertical frequencies to the
+// highest, starting with the constant function.
+func DCTBasis(blockSize int) *linalg.Matrix {
+	oneD := make([][]float64, blockSize)
+	for u := range oneD {
+		oneD[u] = make([]float64, blockSize)
+		scale := math.Sqrt(2 / float64(blockSize))
+		if u == 0 {
+			scale = math.Sqrt(1 / float64(blockSize))
+		}
+		for x := range oneD[u] {
+			arg := math.Pi * float64((2*x+1)*u) / float64(2*blockSize)
+			oneD[u][x] = scale * math.Cos(arg)
+		}
+	}
+	return separableBasis(blockSize, oneD)
+}
+
+// HaarBasis generates an orthonormal basis of 2D Haar
+// wavelets for blocks with the given side-length.
+// The side-length must be a power of two.
+//
+// The rows of the matrix are laid out in the order used
+// by blocker.Blocks, and the first column is the
+// constant function.
+func HaarBasis(blockSize int) *linalg.Matrix {
+	if !isPowerOfTwo(blockSize) {
+		panic("size is not a power of two")
+	}
+
+	// The 1D basis starts with the constant function and
+	// is followed by wavelets at increasing resolutions.
+	oneD := [][]float64{make([]float64, blockSize)}
+	for x := range oneD[0] {
+		oneD[0][x] = 1 / math.Sqrt(float64(blockSize))
+	}
+	for width := blockSize; width > 1; width /= 2 {
+		scale := 1 / math.Sqrt(float64(width))
+		for start := 0; start < blockSize; start += width {
+			vec := make([]float64, blockSize)
+			for x := 0; x < width; x++ {
+				if x < width/2 {
+					vec[start+x] = scale
+				} else {
+					vec[start+x] = -scale
+				}
+			}
+			oneD = append(oneD, vec)
+		}
+	}
+	return separableBasis(blockSize, oneD)
+}
+
+// HaarBasisChecked is like HaarBasis, but it returns an
+// error if the side-length is not a power of two.
+func HaarBasisChecked(blockSize int) (*linalg.Matrix, error) {
+	if !isPowerOfTwo(blockSize) {
+		return nil, errors.New("size is not a power of two")
+	}
+	return HaarBasis(blockSize), nil
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// separableBasis generates a 2D basis from the products
+// of pairs of 1D basis functions.
+//
+// Columns are ordered by the sum of the two 1D indices,
+// so that basis functions of similar scales are nearby.
+func separableBasis(blockSize int, oneD [][]float64) *linalg.Matrix {
+	size := blockSize * blockSize
+	res := linalg.NewMatrix(size, size)
+	col := 0
+	for sum := 0; sum <= 2*(blockSize-1); sum++ {
+		for v := 0; v < blockSize; v++ {
+			u := sum - v
+			if u < 0 || u >= blockSize {
+				continue
+			}
+			for y := 0; y < blockSize; y++ {
+				for x := 0; x < blockSize; x++ {
+					row := blocker.PixelIndex(x, y, blockSize)
+					res.Set(row, col, oneD[u][x]*oneD[v][y])
+				}
+			}
+			col++
+		}
+	}
+	return res
+}
+
 func normalizeColumns(m *linalg.Matrix) {
 	vec := make(linalg.Vector, m.Rows)
 	for col := 0; col < m.Rows; col++ {
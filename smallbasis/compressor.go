@@ -7,6 +7,10 @@ import (
 	"sort"
 
 	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
 	"github.com/unixpickle/num-analysis/linalg"
 	"github.com/unixpickle/num-analysis/linalg/cholesky"
 	"github.com/unixpickle/num-analysis/linalg/ludecomp"
@@ -14,13 +18,79 @@ import (
 
 const DefaultBlockSize = 16
 
+// rankChunkSize is the number of blocks whose coefficients
+// are summed together by a single goroutine.
+const rankChunkSize = 64
+
 // A Compressor compresses and decompresses images by changing
 // each block of an image into a different linear basis and
 // then removing basis vectors that aren't used very heavily.
 type Compressor struct {
-	quality float64
-	basis   *linalg.Matrix
-	basisLU *ludecomp.LU
+	// Quantizer, if non-nil, specifies a quantization table
+	// to use for basis coefficients instead of the default
+	// 8-bit uniform quantization.
+	//
+	// The steps of the table are assigned to basis vectors
+	// in order of spatial frequency (see BasisFrequencies),
+	// so the first step is used for the constant vector.
+	//
+	// The table itself is stored in (or referenced by) the
+	// compressed data, along with a flag saying that the
+	// data is quantized, so decoding does not depend on
+	// Quantizer.
+	Quantizer *quantize.Table
+
+	// Lambda controls rate-distortion optimized quantization
+	// when Quantizer is set.
+	// Larger values trade more squared pixel error (with
+	// pixel values between 0 and 1) for each saved bit.
+	// If Lambda is 0, coefficients are simply rounded.
+	Lambda float64
+
+	// Concurrency is the maximum number of goroutines used
+	// to process blocks.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	// The compressed data does not depend on Concurrency.
+	Concurrency int
+
+	// Progressive, if true, stores the coefficients of the
+	// most significant basis vector for every block, followed
+	// by those of the next most significant vector, etc.
+	// Each vector's coefficients form a layer, and
+	// Decompress can render a coarse preview from data that
+	// is truncated after any layer (past the header).
+	// The data is flagged as progressive, and a Compressor
+	// must have Progressive set to decode it.
+	Progressive bool
+
+	// QualityMap, if true, stores a quality level for each
+	// block, which scales the steps of the Quantizer.
+	// CompressMap chooses finer levels for the blocks that
+	// are most important, and Compress estimates the
+	// importance of blocks with roi.Saliency.
+	//
+	// QualityMap only applies when Quantizer is set and
+	// Progressive is not, and CompressStriped rejects it.
+	// The compressed data says whether it has levels, so
+	// any Compressor with the same basis can decode it.
+	QualityMap bool
+
+	// Weighting, if non-nil, scales the total coefficients
+	// of each basis vector by the visibility of its spatial
+	// frequency (see BasisFrequencies) before the basis
+	// vectors are ranked, so that the pruned basis keeps the
+	// components viewers are most likely to notice.
+	// The weighting only affects compression.
+	Weighting Weighting
+
+	// DecodeOptions limits the resources used to decode
+	// images, which is important for untrusted data.
+	DecodeOptions limits.DecodeOptions
+
+	quality   float64
+	basis     *linalg.Matrix
+	basisLU   *ludecomp.LU
+	stepRanks []int
 
 	blockSize int
 }
@@ -48,10 +118,43 @@ func NewCompressorBasis(quality float64, blockSize int, basis *linalg.Matrix) *C
 		quality:   quality,
 		basis:     basis,
 		basisLU:   ludecomp.Decompose(basis),
+		stepRanks: frequencyRanks(basis, blockSize),
 		blockSize: blockSize,
 	}
 }
 
+// NewCompressorBasisChecked is like NewCompressorBasis,
+// but it returns an error if the arguments are invalid
+// rather than panicking or failing later on.
+func NewCompressorBasisChecked(quality float64, blockSize int,
+	basis *linalg.Matrix) (*Compressor, error) {
+	if blockSize < 1 {
+		return nil, errors.New("block size must be positive")
+	} else if !(quality >= 0 && quality <= 1) {
+		return nil, errors.New("quality must be between 0 and 1")
+	} else if !basis.Square() {
+		return nil, errors.New("basis must be square")
+	} else if basis.Rows != blockSize*blockSize {
+		return nil, errors.New("basis size does not match block size")
+	}
+	for _, x := range basis.Data {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, errors.New("basis must be finite")
+		}
+	}
+	return NewCompressorBasis(quality, blockSize, basis), nil
+}
+
+// NewCompressorBlockSizeChecked is like
+// NewCompressorBlockSize, but it returns an error if the
+// arguments are invalid.
+func NewCompressorBlockSizeChecked(quality float64, blockSize int) (*Compressor, error) {
+	if blockSize < 1 {
+		return nil, errors.New("block size must be positive")
+	}
+	return NewCompressorBasisChecked(quality, blockSize, BasisMatrix(blockSize*blockSize))
+}
+
 // NewCompressorBlockSize is like NewCompressionBasis, but it
 // uses a basis generated by BasisMatrix.
 func NewCompressorBlockSize(quality float64, blockSize int) *Compressor {
@@ -67,73 +170,265 @@ func NewCompressor(quality float64) *Compressor {
 
 // Compress compresses an image and returns binary data
 // representing the result.
+//
+// It panics if the image cannot be encoded; use
+// CompressChecked to get an error instead.
 func (c *Compressor) Compress(i image.Image) []byte {
+	return mustEncode(c.compress(i))
+}
+
+// compress is like Compress, but it returns an error if
+// the image cannot be encoded.
+func (c *Compressor) compress(i image.Image) ([]byte, error) {
+	if c.leveled() {
+		return c.compressMap(i, roi.Saliency(i, c.blockSize))
+	}
+	return c.compressMap(i, nil)
+}
+
+// CompressMap is like Compress, but if c.QualityMap
+// applies, it quantizes blocks more finely in the more
+// important regions of m.
+// A nil Map gives every block the default level.
+func (c *Compressor) CompressMap(i image.Image, m roi.Map) []byte {
+	return mustEncode(c.compressMap(i, m))
+}
+
+// compressMap is like CompressMap, but it returns an
+// error if the image cannot be encoded.
+func (c *Compressor) compressMap(i image.Image, m roi.Map) ([]byte, error) {
 	blocks := blocker.Blocks(i, c.blockSize)
-	r := &RankedVectors{
-		BasisIndices: make([]int, c.blockSize*c.blockSize),
-		CoeffTotal:   make([]float64, c.blockSize*c.blockSize),
+	r := c.newRankedVectors()
+	r.addTotals(c.coefficientTotals(blocks))
+	usedBasis := c.selectBasis(r)
+	if c.Progressive {
+		usedBasis = rankedBasis(r, len(usedBasis))
 	}
-	for i := range r.BasisIndices {
-		r.BasisIndices[i] = i
+
+	compressed := c.projectImage(usedBasis, blocks, i.Bounds())
+	if c.Progressive {
+		return compressed.EncodeProgressive()
 	}
-	for _, block := range blocks {
-		solution := c.basisLU.Solve(block)
-		for i, coeff := range solution {
-			r.CoeffTotal[i] += math.Abs(coeff)
-		}
+	if c.leveled() {
+		compressed.Levels = roi.BlockLevels(m, i.Bounds(), c.blockSize)
 	}
+	return compressed.Encode()
+}
 
-	sort.Sort(r)
-	basisCount := roundFloat(c.quality * float64(c.blockSize*c.blockSize))
-	usedBasis := make([]int, basisCount)
-	copy(usedBasis, r.BasisIndices)
-	sort.Ints(usedBasis)
+// mustEncode panics if an image could not be encoded.
+func mustEncode(data []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
 
+// projectImage projects the blocks of an image onto a
+// pruned basis.
+//
+// The Quantizer is rounded to the precision at which it
+// is stored, so that the coefficients are quantized with
+// the same steps that the decoder will use.
+func (c *Compressor) projectImage(usedBasis []int, blocks []linalg.Vector,
+	bounds image.Rectangle) *compressedImage {
 	basisVectors := c.basisVectors(usedBasis)
-
-	projBlocks := c.projectionBlocks(basisVectors, blocks)
-
-	compressed := &compressedImage{
+	res := &compressedImage{
 		UsedBasis: usedBasis,
-		Blocks:    projBlocks,
+		Blocks:    c.projectionBlocks(basisVectors, blocks),
 		BlockSize: c.blockSize,
-		Width:     i.Bounds().Dx(),
-		Height:    i.Bounds().Dy(),
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		StepRanks: c.stepRanks,
+		Lambda:    c.Lambda,
+		Weights:   squaredNorms(basisVectors),
 	}
-	return compressed.Encode()
+	if c.Quantizer != nil {
+		res.Quantizer = c.Quantizer.Rounded()
+	}
+	return res
+}
+
+// CompressChecked is like Compress, but it returns an
+// error if the Compressor is misconfigured or the image
+// is too large to encode.
+func (c *Compressor) CompressChecked(i image.Image) ([]byte, error) {
+	if c.basis == nil {
+		return nil, errors.New("compressor has no basis")
+	}
+	if c.Quantizer != nil {
+		if err := c.Quantizer.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if !(c.Lambda >= 0) || math.IsInf(c.Lambda, 0) {
+		return nil, errors.New("lambda must be non-negative")
+	}
+	if err := checkBounds(i.Bounds()); err != nil {
+		return nil, err
+	}
+	return c.compress(i)
 }
 
 // Decompress decodes the binary data of a compressed image,
 // turning it back into a usable image.
 func (c *Compressor) Decompress(d []byte) (image.Image, error) {
-	ci, err := decodeCompressedImage(d, c.blockSize)
+	ci, err := c.decodeCoefficients(d)
+	if err != nil {
+		return nil, err
+	}
+	basisVectors := c.basisVectors(ci.UsedBasis)
+	blockList := c.combineBlocks(basisVectors, ci.Blocks, c.blockSize)
+	return blocker.Image(ci.Width, ci.Height, blockList, c.blockSize), nil
+}
+
+// DecodeThumbnail decodes a compressed image at a
+// reduced size, shrinking it by a factor of scale in
+// each dimension.
+// The scale must evenly divide the block size; a scale
+// equal to the block size yields one pixel per block.
+//
+// This is much faster than decoding the full image,
+// since each block is built directly from downsampled
+// basis vectors.
+func (c *Compressor) DecodeThumbnail(d []byte, scale int) (image.Image, error) {
+	if scale < 1 || c.blockSize%scale != 0 {
+		return nil, errors.New("thumbnail scale must divide the block size")
+	}
+	ci, err := c.decodeCoefficients(d)
+	if err != nil {
+		return nil, err
+	}
+	bases := blocker.ThumbnailBases(c.basisVectors(ci.UsedBasis), ci.Width, ci.Height,
+		c.blockSize, scale)
+	smallSize := c.blockSize / scale
+	blockList := make([]linalg.Vector, len(ci.Blocks))
+	parallel.For(len(ci.Blocks), c.Concurrency, func(i int) {
+		blockList[i] = c.combineBlock(bases[i], ci.Blocks[i], smallSize)
+	})
+	w, h := blocker.ThumbnailSize(ci.Width, ci.Height, scale)
+	return blocker.Image(w, h, blockList, smallSize), nil
+}
+
+// decodeCoefficients decodes and validates the basis
+// and the coefficients of a compressed image.
+func (c *Compressor) decodeCoefficients(d []byte) (*compressedImage, error) {
+	var ci *compressedImage
+	var err error
+	if c.Progressive {
+		ci, err = decodeProgressiveImage(d, c.blockSize, c.stepRanks, &c.DecodeOptions)
+	} else {
+		ci, err = decodeCompressedImage(d, c.blockSize, c.stepRanks, &c.DecodeOptions)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// decodeCompressedImage does not verify the basis list.
+	// The decoders do not verify the basis list.
 	// We must verify the basis to prevent a possible panic().
-	if !sort.IntsAreSorted(ci.UsedBasis) {
-		return nil, errors.New("unsorted basis vectors in decoded image")
+	if c.Progressive {
+		err = c.checkRankedBasis(ci.UsedBasis)
+	} else {
+		err = c.checkBasis(ci.UsedBasis)
 	}
-	for _, x := range ci.UsedBasis {
-		if x >= c.basis.Rows || x < 0 {
-			return nil, errors.New("overflowing basis vectors in decoded image")
+	if err != nil {
+		return nil, err
+	}
+	return ci, nil
+}
+
+// combineBlocks computes the linear combination of the
+// basis vectors for each block of coefficients.
+// The basis vectors represent blocks with a side length
+// of blockSize.
+func (c *Compressor) combineBlocks(basisVectors []linalg.Vector, coeffs [][]float64,
+	blockSize int) []linalg.Vector {
+	blockList := make([]linalg.Vector, len(coeffs))
+	parallel.For(len(coeffs), c.Concurrency, func(i int) {
+		blockList[i] = c.combineBlock(basisVectors, coeffs[i], blockSize)
+	})
+	return blockList
+}
+
+// combineBlock computes the linear combination of the
+// basis vectors for a single block of coefficients.
+func (c *Compressor) combineBlock(basisVectors []linalg.Vector, coeffs []float64,
+	blockSize int) linalg.Vector {
+	if len(basisVectors) > 0 {
+		return linalg.Vector(linearCombination(basisVectors, coeffs))
+	}
+	return make(linalg.Vector, blockSize*blockSize)
+}
+
+// UsesQualityMap returns whether CompressMap takes its
+// Map into account, which requires QualityMap and a
+// Quantizer, and is not supported for progressive data.
+func (c *Compressor) UsesQualityMap() bool {
+	return c.leveled()
+}
+
+// leveled returns whether blocks have quality levels.
+func (c *Compressor) leveled() bool {
+	return c.QualityMap && c.Quantizer != nil && !c.Progressive
+}
+
+func (c *Compressor) newRankedVectors() *RankedVectors {
+	r := &RankedVectors{
+		BasisIndices: make([]int, c.blockSize*c.blockSize),
+		CoeffTotal:   make([]float64, c.blockSize*c.blockSize),
+	}
+	for i := range r.BasisIndices {
+		r.BasisIndices[i] = i
+	}
+	return r
+}
+
+// selectBasis sorts the ranked basis vectors and returns
+// the (sorted) indices of the ones that should be kept.
+func (c *Compressor) selectBasis(r *RankedVectors) []int {
+	if c.Weighting != nil {
+		freqs := BasisFrequencies(c.basis, c.blockSize)
+		for i, idx := range r.BasisIndices {
+			r.CoeffTotal[i] *= c.Weighting(freqs[idx])
 		}
 	}
+	sort.Sort(r)
+	basisCount := roundFloat(c.quality * float64(c.blockSize*c.blockSize))
+	usedBasis := make([]int, basisCount)
+	copy(usedBasis, r.BasisIndices)
+	sort.Ints(usedBasis)
+	return usedBasis
+}
 
-	basisVectors := c.basisVectors(ci.UsedBasis)
+func (c *Compressor) checkBasis(usedBasis []int) error {
+	if !sort.IntsAreSorted(usedBasis) {
+		return errors.New("unsorted basis vectors in decoded image")
+	}
+	for _, x := range usedBasis {
+		if x >= c.basis.Rows || x < 0 {
+			return errors.New("overflowing basis vectors in decoded image")
+		}
+	}
+	return nil
+}
 
-	blockList := make([]linalg.Vector, len(ci.Blocks))
-	for i, encodedBlock := range ci.Blocks {
-		if len(basisVectors) > 0 {
-			blockList[i] = linalg.Vector(linearCombination(basisVectors, encodedBlock))
-		} else {
-			blockList[i] = make(linalg.Vector, c.blockSize*c.blockSize)
+// checkRankedBasis is like checkBasis, but for a basis
+// list in order of significance rather than sorted order.
+func (c *Compressor) checkRankedBasis(usedBasis []int) error {
+	sorted := append([]int{}, usedBasis...)
+	sort.Ints(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			return errors.New("duplicate basis vectors in decoded image")
 		}
 	}
+	return c.checkBasis(sorted)
+}
 
-	return blocker.Image(ci.Width, ci.Height, blockList, c.blockSize), nil
+// rankedBasis returns the indices of the count most
+// significant basis vectors of a sorted RankedVectors,
+// in order of significance.
+func rankedBasis(r *RankedVectors, count int) []int {
+	return append([]int{}, r.BasisIndices[:count]...)
 }
 
 func (c *Compressor) basisVectors(indices []int) []linalg.Vector {
@@ -154,6 +449,12 @@ func (c *Compressor) basisVectors(indices []int) []linalg.Vector {
 // original block as possible (i.e. that arrive at an
 // orthogonal projection).
 func (c *Compressor) projectionBlocks(basis, blocks []linalg.Vector) [][]float64 {
+	return c.projectWith(basis, projectionSolver(basis), blocks)
+}
+
+// projectionSolver decomposes the matrix (A^T)A, which is
+// explained in projectionBlocks.
+func projectionSolver(basis []linalg.Vector) *cholesky.Cholesky {
 	// If we have an equation Ax=b where A is the matrix with
 	// our pruned basis for columns, then we would like to find
 	// the x which minimizes the magnitude ||Ax-b||. To do this,
@@ -169,27 +470,69 @@ func (c *Compressor) projectionBlocks(basis, blocks []linalg.Vector) [][]float64
 		}
 	}
 
-	projLeftLU := cholesky.Decompose(projLeft)
+	return cholesky.Decompose(projLeft)
+}
 
+// projectWith is like projectionBlocks, but it uses a
+// precomputed decomposition of (A^T)A.
+func (c *Compressor) projectWith(basis []linalg.Vector, projLeftLU *cholesky.Cholesky,
+	blocks []linalg.Vector) [][]float64 {
 	res := make([][]float64, len(blocks))
-	for i, block := range blocks {
+	parallel.For(len(blocks), c.Concurrency, func(i int) {
 		// blockDot corresponds to (A^T)b in the explanation above.
 		blockDot := make(linalg.Vector, len(basis))
 		for k := range blockDot {
-			blockDot[k] = basis[k].Dot(block)
+			blockDot[k] = basis[k].Dot(blocks[i])
 		}
 		solution := projLeftLU.Solve(blockDot)
 		res[i] = []float64(solution)
-	}
+	})
 
 	return res
 }
 
+// coefficientTotals sums the absolute coefficients of the
+// blocks in the full basis.
+//
+// The blocks are split into fixed-size chunks which are
+// summed separately, so that the floating-point results
+// do not depend on c.Concurrency.
+func (c *Compressor) coefficientTotals(blocks []linalg.Vector) [][]float64 {
+	numChunks := (len(blocks) + rankChunkSize - 1) / rankChunkSize
+	res := make([][]float64, numChunks)
+	parallel.For(numChunks, c.Concurrency, func(chunk int) {
+		totals := make([]float64, c.blockSize*c.blockSize)
+		end := (chunk + 1) * rankChunkSize
+		if end > len(blocks) {
+			end = len(blocks)
+		}
+		for _, block := range blocks[chunk*rankChunkSize : end] {
+			solution := c.basisLU.Solve(block)
+			for i, coeff := range solution {
+				totals[i] += math.Abs(coeff)
+			}
+		}
+		res[chunk] = totals
+	})
+	return res
+}
+
 type RankedVectors struct {
 	BasisIndices []int
 	CoeffTotal   []float64
 }
 
+// addTotals adds coefficient totals (as returned by
+// coefficientTotals) to r.CoeffTotal.
+// It must be called before r is sorted.
+func (r *RankedVectors) addTotals(totals [][]float64) {
+	for _, chunk := range totals {
+		for i, total := range chunk {
+			r.CoeffTotal[i] += total
+		}
+	}
+}
+
 func (r *RankedVectors) Len() int {
 	return len(r.BasisIndices)
 }
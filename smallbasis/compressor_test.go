@@ -0,0 +1,202 @@
+package smallbasis
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
+	"github.com/unixpickle/imagecompress/strip"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+func TestCompressConcurrency(t *testing.T) {
+	configs := map[string]func(c *Compressor){
+		"default": func(c *Compressor) {},
+		"quantized": func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.Lambda = 1e-4
+		},
+		"progressive": func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.Progressive = true
+		},
+		"roi": func(c *Compressor) {
+			c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+			c.QualityMap = true
+		},
+		"csf": func(c *Compressor) {
+			c.Weighting = CSFWeighting(DefaultPixelsPerDegree)
+		},
+	}
+	img := testutil.Image(70, 50)
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			testutil.CheckConcurrency(t, img, func(concurrency int) testutil.Codec {
+				c := NewCompressor(0.3)
+				config(c)
+				c.Concurrency = concurrency
+				return c
+			})
+		})
+	}
+}
+
+func TestDecodeThumbnailEdges(t *testing.T) {
+	testutil.CheckDecodeThumbnail(t, NewCompressorBlockSize(0.5, 8), 4)
+}
+
+func TestQuantizedMode(t *testing.T) {
+	plain := NewCompressorBlockSize(0.5, 4)
+	quantized := NewCompressorBlockSize(0.5, 4)
+	quantized.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	testutil.CheckDecoders(t, testutil.Image(21, 13), plain, quantized)
+}
+
+func TestProgressive(t *testing.T) {
+	img := testutil.Image(21, 13)
+	for _, quantizer := range []*quantize.Table{nil, quantize.Lookup(quantize.StandardTableID)} {
+		progressive := NewCompressorBlockSize(0.5, 4)
+		progressive.Quantizer = quantizer
+		progressive.Progressive = true
+		plain := NewCompressorBlockSize(0.5, 4)
+		plain.Quantizer = quantizer
+		testutil.CheckProgressive(t, progressive, plain, img)
+	}
+}
+
+func TestCompressStripedOptions(t *testing.T) {
+	for name, config := range map[string]func(c *Compressor){
+		"progressive": func(c *Compressor) { c.Progressive = true },
+		"quality map": func(c *Compressor) { c.QualityMap = true },
+	} {
+		c := NewCompressorBlockSize(0.5, 4)
+		c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+		config(c)
+		err := c.CompressStriped(strip.ImageSource(testutil.Image(9, 5)), &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestDecompressStripedLimits(t *testing.T) {
+	c := NewCompressorBlockSize(0.5, 4)
+	c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	testutil.CheckStripedLimits(t, c)
+}
+
+func TestDegenerateImages(t *testing.T) {
+	for name, img := range testutil.DegenerateImages() {
+		for _, quantizer := range []*quantize.Table{nil, quantize.Lookup(quantize.StandardTableID)} {
+			c := NewCompressorBlockSize(1, 4)
+			c.Quantizer = quantizer
+			errs := testutil.CoefficientErrors(img, c.blockSize, c.Quantizer, c.stepRanks)
+			t.Run(fmt.Sprintf("%s/quantized=%v", name, quantizer != nil), func(t *testing.T) {
+				testutil.CheckRoundTrip(t, c, img, testutil.QuantizationError(errs))
+			})
+		}
+	}
+}
+
+func TestCheckedConstructors(t *testing.T) {
+	nonSquare := linalg.NewMatrix(16, 15)
+	infinite := BasisMatrix(16)
+	infinite.Data[3] = math.Inf(1)
+	basisCases := []struct {
+		Name      string
+		Quality   float64
+		BlockSize int
+		Basis     *linalg.Matrix
+	}{
+		{"zero block size", 0.5, 0, BasisMatrix(1)},
+		{"negative quality", -0.1, 4, BasisMatrix(16)},
+		{"large quality", 1.5, 4, BasisMatrix(16)},
+		{"NaN quality", math.NaN(), 4, BasisMatrix(16)},
+		{"non-square basis", 0.5, 4, nonSquare},
+		{"basis size mismatch", 0.5, 4, BasisMatrix(9)},
+		{"infinite basis", 0.5, 4, infinite},
+	}
+	for _, c := range basisCases {
+		if _, err := NewCompressorBasisChecked(c.Quality, c.BlockSize, c.Basis); err == nil {
+			t.Errorf("NewCompressorBasisChecked: %s: expected an error", c.Name)
+		}
+	}
+	if _, err := NewCompressorBasisChecked(0.5, 4, BasisMatrix(16)); err != nil {
+		t.Errorf("NewCompressorBasisChecked: %s", err)
+	}
+
+	for _, blockSize := range []int{-1, 0} {
+		if _, err := NewCompressorBlockSizeChecked(0.5, blockSize); err == nil {
+			t.Errorf("NewCompressorBlockSizeChecked: block size %d: expected an error", blockSize)
+		}
+	}
+	if _, err := NewCompressorBlockSizeChecked(2, 4); err == nil {
+		t.Error("NewCompressorBlockSizeChecked: quality 2: expected an error")
+	}
+	if _, err := NewCompressorBlockSizeChecked(0.5, 3); err != nil {
+		t.Errorf("NewCompressorBlockSizeChecked: %s", err)
+	}
+
+	for _, size := range []int{-4, 0, 3, 6} {
+		if _, err := OrthoBasisChecked(size); err == nil {
+			t.Errorf("OrthoBasisChecked: size %d: expected an error", size)
+		}
+		if _, err := HaarBasisChecked(size); err == nil {
+			t.Errorf("HaarBasisChecked: size %d: expected an error", size)
+		}
+	}
+	for _, size := range []int{1, 2, 8} {
+		if _, err := OrthoBasisChecked(size); err != nil {
+			t.Errorf("OrthoBasisChecked: size %d: %s", size, err)
+		}
+		if _, err := HaarBasisChecked(size); err != nil {
+			t.Errorf("HaarBasisChecked: size %d: %s", size, err)
+		}
+	}
+
+	if _, err := (&Compressor{}).CompressChecked(testutil.Image(3, 3)); err == nil {
+		t.Error("CompressChecked: zero Compressor: expected an error")
+	}
+	c := NewCompressor(0.5)
+	c.Lambda = -1
+	if _, err := c.CompressChecked(testutil.Image(3, 3)); err == nil {
+		t.Error("CompressChecked: negative Lambda: expected an error")
+	}
+}
+
+func TestCompressMap(t *testing.T) {
+	img := testutil.Image(32, 24)
+	fine, coarse := image.Rect(0, 0, 16, 24), image.Rect(16, 0, 32, 24)
+	m := &roi.RectMap{Rects: []image.Rectangle{fine}}
+	c := NewCompressorBlockSize(1, 4)
+	c.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	c.QualityMap = true
+	data := c.CompressMap(img, m)
+
+	// The levels are flagged in the data, so they can be
+	// decoded without QualityMap.
+	decoder := NewCompressorBlockSize(1, 4)
+	ci, err := decoder.decodeCoefficients(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := roi.BlockLevels(m, img.Bounds(), 4)
+	if !reflect.DeepEqual(ci.Levels, expected) {
+		t.Fatalf("expected levels %v but got %v", expected, ci.Levels)
+	}
+	decoded, err := decoder.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fineErr := testutil.MeanError(img, decoded, fine)
+	coarseErr := testutil.MeanError(img, decoded, coarse)
+	if fineErr >= coarseErr {
+		t.Errorf("important region has error %f, but the rest has %f", fineErr, coarseErr)
+	}
+}
@@ -5,7 +5,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"image"
+	"io"
 	"math"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
 )
 
 const (
@@ -13,8 +20,29 @@ const (
 	basisHeadingDense  = 1
 )
 
+// These flags make up the mode byte at the start of the
+// body of an image, which says how the coefficients are
+// stored.
+const (
+	modeQuantized = 1 << iota
+
+	// modeLeveled means that every block has a quality
+	// level, which only applies to quantized images.
+	modeLeveled
+
+	// modeProgressive means that the coefficients are
+	// grouped by basis vector rather than by block, which
+	// rules out quality levels.
+	modeProgressive
+)
+
 var encodedByteOrder = binary.LittleEndian
 
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
 type compressedImage struct {
 	// UsedBasis contains the indices of the basis
 	// vectors that are used in this compressedImage.
@@ -28,6 +56,40 @@ type compressedImage struct {
 	BlockSize int
 	Width     int
 	Height    int
+
+	// Quantizer, if non-nil, is used to quantize the
+	// coefficients in place of the 8-bit uniform
+	// quantization.
+	Quantizer *quantize.Table
+
+	// SharedTable, if true, means that Quantizer is stored
+	// with a shared basis instead of before the
+	// coefficients.
+	// A nil Quantizer then means that the coefficients
+	// must not be quantized.
+	SharedTable bool
+
+	// StepRanks, if non-nil, maps each basis index to the
+	// index of its step in Quantizer, so that the lowest
+	// frequencies get the first (finest) steps.
+	StepRanks []int
+
+	// Lambda is the rate-distortion tradeoff used when
+	// quantizing coefficients with Quantizer.
+	Lambda float64
+
+	// Weights contains the squared magnitude of each
+	// used basis vector, which converts coefficient
+	// errors into pixel errors for Lambda.
+	Weights []float64
+
+	// Levels, if non-nil, contains a quality level for
+	// each spatial block (as from roi.BlockLevels), which
+	// scales the steps of Quantizer for all three of its
+	// color channels.
+	// Each level is stored before the coefficients of its
+	// first channel.
+	Levels []int
 }
 
 // decodeCompressedImage unpacks a binary representation
@@ -35,9 +97,63 @@ type compressedImage struct {
 // You must know the block size ahead of time to decode the
 // file, but this is reasonable since you must also know the
 // basis ahead of time to actually utilize the compressedImage.
-func decodeCompressedImage(data []byte, blockSize int) (*compressedImage, error) {
+// The stepRanks are used as the StepRanks of the result.
+//
+// The opts are checked before any large allocations.
+func decodeCompressedImage(data []byte, blockSize int, stepRanks []int,
+	opts *limits.DecodeOptions) (*compressedImage, error) {
 	buf := bytes.NewBuffer(data)
 
+	res, err := decodeHeader(buf, blockSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.StepRanks = stepRanks
+	err = opts.CheckBlocks(uint64(res.Width), uint64(res.Height), blockSize, len(res.UsedBasis))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := res.decodeBody(buf); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeBody reads the blocks of an image whose
+// dimensions and basis are already known.
+func (i *compressedImage) decodeBody(buf *bytes.Buffer) error {
+	blockCount := blocker.Count(image.Rect(0, 0, i.Width, i.Height), i.BlockSize)
+
+	mode, err := decodeMode(buf)
+	if err != nil {
+		return err
+	} else if mode&modeProgressive != 0 {
+		return errors.New("progressive data requires a progressive decoder")
+	}
+	if err := i.checkSharedMode(mode); err != nil {
+		return err
+	}
+	if mode&modeQuantized != 0 {
+		return i.decodeQuantizedBlocks(blockCount, buf, mode&modeLeveled != 0)
+	}
+
+	var maxCoeff float64
+	if err := binary.Read(buf, encodedByteOrder, &maxCoeff); err != nil {
+		return errors.New("missing maximum coefficient value")
+	}
+	for j := 0; j < blockCount; j++ {
+		if err := i.decodeNextBlock(maxCoeff, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeHeader reads the dimensions and the basis of a
+// compressedImage, leaving its blocks empty.
+func decodeHeader(buf byteReader, blockSize int,
+	opts *limits.DecodeOptions) (*compressedImage, error) {
 	res := &compressedImage{
 		BlockSize: blockSize,
 	}
@@ -50,52 +166,131 @@ func decodeCompressedImage(data []byte, blockSize int) (*compressedImage, error)
 		return nil, errors.New("missing height field")
 	}
 
+	if err := opts.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return nil, err
+	}
 	res.Width = int(width)
 	res.Height = int(height)
 
+	if err := res.decodeBasis(buf, opts); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeBasis performs the inverse of encodeBasis.
+func (i *compressedImage) decodeBasis(buf byteReader, opts *limits.DecodeOptions) error {
 	if b, err := buf.ReadByte(); err != nil {
-		return nil, errors.New("missing basis heading")
+		return errors.New("missing basis heading")
 	} else if b == basisHeadingSparse {
-		if err := res.decodeSparseBasis(buf); err != nil {
-			return nil, err
-		}
+		return i.decodeSparseBasis(buf, opts)
 	} else if b == basisHeadingDense {
-		if err := res.decodeDenseBasis(buf); err != nil {
-			return nil, err
+		if err := i.decodeDenseBasis(buf); err != nil {
+			return err
 		}
+		return opts.CheckBasisSize(uint64(len(i.UsedBasis)))
 	} else {
-		return nil, fmt.Errorf("unknown basis heading type: 0x%x", b)
+		return fmt.Errorf("unknown basis heading type: 0x%x", b)
 	}
+}
 
-	var maxCoeff float64
-	if err := binary.Read(buf, encodedByteOrder, &maxCoeff); err != nil {
-		return nil, errors.New("missing maximum coefficient value")
+// Encode generates a binary representation of this image.
+func (i *compressedImage) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+
+	i.encodeHeader(&buf)
+	if err := i.encodeBody(&buf); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	horizBlockCount := res.Width / blockSize
-	vertBlockCount := res.Height / blockSize
-	if res.Width%blockSize != 0 {
-		horizBlockCount++
+// encodeBody writes the blocks of the image, without
+// its dimensions or basis.
+func (i *compressedImage) encodeBody(buf *bytes.Buffer) error {
+	buf.WriteByte(i.mode())
+	if i.Quantizer != nil {
+		return i.encodeQuantizedBlocks(buf)
 	}
-	if res.Height%blockSize != 0 {
-		vertBlockCount++
+
+	maxCoeff := i.maxCoefficient()
+	binary.Write(buf, encodedByteOrder, maxCoeff)
+
+	for _, block := range i.Blocks {
+		for _, blockValue := range block {
+			buf.WriteByte(coefficientByte(blockValue, maxCoeff))
+		}
 	}
-	for i := 0; i < horizBlockCount*vertBlockCount*3; i++ {
-		if err := res.decodeNextBlock(maxCoeff, buf); err != nil {
-			return nil, err
+	return nil
+}
+
+// encodeHeader writes the dimensions and the basis of
+// the image.
+func (i *compressedImage) encodeHeader(buf *bytes.Buffer) {
+	binary.Write(buf, encodedByteOrder, uint32(i.Width))
+	binary.Write(buf, encodedByteOrder, uint32(i.Height))
+	i.encodeBasis(buf)
+}
+
+// mode computes the mode byte of the image.
+func (i *compressedImage) mode() byte {
+	var res byte
+	if i.Quantizer != nil {
+		res |= modeQuantized
+		if i.Levels != nil {
+			res |= modeLeveled
 		}
 	}
+	return res
+}
 
-	return res, nil
+// decodeMode reads a mode byte and checks that it only
+// uses known flags.
+func decodeMode(r io.ByteReader) (byte, error) {
+	mode, err := r.ReadByte()
+	if err != nil {
+		return 0, errors.New("missing mode field")
+	} else if mode&^(modeQuantized|modeLeveled|modeProgressive) != 0 ||
+		(mode&modeLeveled != 0 && mode&(modeQuantized|modeProgressive) != modeQuantized) {
+		return 0, fmt.Errorf("unknown mode: 0x%x", mode)
+	}
+	return mode, nil
 }
 
-// Encode generates a binary representation of this image.
-func (i *compressedImage) Encode() []byte {
-	var buf bytes.Buffer
+// checkSharedMode checks that the mode of an image
+// agrees with its shared quantization table, if it has
+// one.
+func (i *compressedImage) checkSharedMode(mode byte) error {
+	if i.SharedTable && (mode&modeQuantized != 0) != (i.Quantizer != nil) {
+		return errors.New("mode does not match shared basis")
+	}
+	return nil
+}
 
-	binary.Write(&buf, encodedByteOrder, uint32(i.Width))
-	binary.Write(&buf, encodedByteOrder, uint32(i.Height))
+// encodeTable writes the quantization table, unless it
+// is shared.
+func (i *compressedImage) encodeTable(buf *bytes.Buffer) {
+	if !i.SharedTable {
+		quantize.WriteTable(buf, i.Quantizer)
+	}
+}
 
+// decodeTable performs the inverse of encodeTable.
+func (i *compressedImage) decodeTable(r io.Reader) error {
+	if i.SharedTable {
+		return nil
+	}
+	table, err := quantize.ReadTable(r)
+	if err != nil {
+		return errors.New("could not read quantization table: " + err.Error())
+	}
+	i.Quantizer = table
+	return nil
+}
+
+// encodeBasis writes the used basis as a list or as a
+// bitmap, whichever is smaller.
+func (i *compressedImage) encodeBasis(buf *bytes.Buffer) {
 	fullBasisSize := i.BlockSize * i.BlockSize
 	sparseBasisSize := len(i.UsedBasis) * 32
 	if sparseBasisSize < fullBasisSize {
@@ -105,21 +300,47 @@ func (i *compressedImage) Encode() []byte {
 		buf.WriteByte(basisHeadingDense)
 		buf.Write(i.encodeDenseBasis())
 	}
+}
 
-	maxCoeff := i.maxCoefficient()
-	binary.Write(&buf, encodedByteOrder, maxCoeff)
-
-	for _, block := range i.Blocks {
-		for _, blockValue := range block {
-			blockValue += maxCoeff
-			blockValue /= maxCoeff * 2
-			blockValue *= 0xff
-			num := roundFloat(blockValue)
-			buf.WriteByte(byte(num))
+// encodeQuantizedBlocks writes the quantization table
+// (unless it is shared) followed by the quantized
+// coefficients of every block.
+func (i *compressedImage) encodeQuantizedBlocks(buf *bytes.Buffer) error {
+	i.encodeTable(buf)
+	var tables []*quantize.Table
+	if i.Levels != nil {
+		tables = roi.ScaledTables(i.Quantizer)
+	}
+	w := quantize.NewBitWriter(buf)
+	t := i.Quantizer
+	stepIndices := i.stepIndices()
+	for j, block := range i.Blocks {
+		// The channels of a spatial block share its level.
+		if i.Levels != nil && j%3 == 0 {
+			if err := w.WriteBits(uint64(i.Levels[j/3]), roi.LevelBits); err != nil {
+				return err
+			}
+			t = tables[i.Levels[j/3]]
+		}
+		if err := i.encodeQuantizedBlock(w, t, stepIndices, block); err != nil {
+			return err
 		}
 	}
+	return w.Flush()
+}
 
-	return buf.Bytes()
+// encodeQuantizedBlock writes the quantized coefficients
+// of a single block using the table t and the step
+// indices from stepIndices.
+func (i *compressedImage) encodeQuantizedBlock(w *quantize.BitWriter, t *quantize.Table,
+	stepIndices []int, block []float64) error {
+	quantized := t.QuantizeBlock(block, stepIndices, i.Weights, i.Lambda)
+	for _, q := range quantized {
+		if err := t.WriteCoeff(w, q); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // encodeSparseBasis generates a list of basis element
@@ -159,6 +380,20 @@ func (i *compressedImage) encodeDenseBasis() []byte {
 	return res
 }
 
+// coefficientByte quantizes a coefficient between
+// -maxCoeff and maxCoeff to 8 bits.
+func coefficientByte(coeff, maxCoeff float64) byte {
+	if maxCoeff == 0 {
+		// Every coefficient decodes to 0 (e.g. for a black
+		// image), regardless of the byte.
+		return 0
+	}
+	coeff += maxCoeff
+	coeff /= maxCoeff * 2
+	coeff *= 0xff
+	return byte(roundFloat(coeff))
+}
+
 // maxCoefficient gets the basis coefficient with the
 // biggest magnitude in any block of the image.
 func (i *compressedImage) maxCoefficient() float64 {
@@ -173,11 +408,17 @@ func (i *compressedImage) maxCoefficient() float64 {
 
 // decodeSparseBasis performs the inverse of
 // encodeSparseBasis.
-func (i *compressedImage) decodeSparseBasis(r *bytes.Buffer) error {
+func (i *compressedImage) decodeSparseBasis(r byteReader, opts *limits.DecodeOptions) error {
 	var count uint32
 	if err := binary.Read(r, encodedByteOrder, &count); err != nil {
 		return errors.New("missing sparse vector count")
 	}
+	if err := opts.CheckBasisSize(uint64(count)); err != nil {
+		return err
+	}
+	if int(count) > i.BlockSize*i.BlockSize {
+		return errors.New("too many basis vectors in decoded image")
+	}
 
 	i.UsedBasis = make([]int, int(count))
 	for index := range i.UsedBasis {
@@ -193,7 +434,7 @@ func (i *compressedImage) decodeSparseBasis(r *bytes.Buffer) error {
 
 // decodeDenseBasis performs the inverse of
 // encodeDenseBasis.
-func (i *compressedImage) decodeDenseBasis(r *bytes.Buffer) error {
+func (i *compressedImage) decodeDenseBasis(r byteReader) error {
 	bitCount := i.BlockSize * i.BlockSize
 	byteCount := bitCount / 8
 	if bitCount%8 != 0 {
@@ -201,7 +442,7 @@ func (i *compressedImage) decodeDenseBasis(r *bytes.Buffer) error {
 	}
 
 	bytes := make([]byte, byteCount)
-	if n, err := r.Read(bytes); err != nil || n < len(bytes) {
+	if _, err := io.ReadFull(r, bytes); err != nil {
 		return errors.New("could not read basis bitmap")
 	}
 
@@ -227,7 +468,7 @@ func (i *compressedImage) decodeDenseBasis(r *bytes.Buffer) error {
 
 // decodeNextBlock reads a block (i.e. a linear
 // combination of basis vectors) from the buffer.
-func (i *compressedImage) decodeNextBlock(maxCoeff float64, r *bytes.Buffer) error {
+func (i *compressedImage) decodeNextBlock(maxCoeff float64, r byteReader) error {
 	block := make([]float64, len(i.UsedBasis))
 	for k := 0; k < len(i.UsedBasis); k++ {
 		if b, err := r.ReadByte(); err != nil {
@@ -243,3 +484,74 @@ func (i *compressedImage) decodeNextBlock(maxCoeff float64, r *bytes.Buffer) err
 	i.Blocks = append(i.Blocks, block)
 	return nil
 }
+
+// decodeQuantizedBlocks performs the inverse of
+// encodeQuantizedBlocks.
+// If leveled is true, each spatial block is expected to
+// have a quality level before its first channel.
+func (i *compressedImage) decodeQuantizedBlocks(count int, r byteReader, leveled bool) error {
+	if err := i.decodeTable(r); err != nil {
+		return err
+	}
+	table := i.Quantizer
+	var tables []*quantize.Table
+	if leveled {
+		tables = roi.ScaledTables(table)
+		i.Levels = make([]int, 0, count/3)
+	}
+
+	br := quantize.NewBitReader(r)
+	t := table
+	stepIndices := i.stepIndices()
+	for j := 0; j < count; j++ {
+		if leveled && j%3 == 0 {
+			level, err := br.ReadBits(roi.LevelBits)
+			if err != nil {
+				return errors.New("could not read block level")
+			}
+			i.Levels = append(i.Levels, int(level))
+			t = tables[level]
+		}
+		block, err := i.decodeQuantizedBlock(br, t, stepIndices)
+		if err != nil {
+			return err
+		}
+		i.Blocks = append(i.Blocks, block)
+	}
+	return nil
+}
+
+// decodeQuantizedBlock reads the coefficients of a
+// single block using the table t and the step indices
+// from stepIndices.
+func (i *compressedImage) decodeQuantizedBlock(br *quantize.BitReader,
+	t *quantize.Table, stepIndices []int) ([]float64, error) {
+	block := make([]float64, len(i.UsedBasis))
+	for k, stepIdx := range stepIndices {
+		q, err := t.ReadCoeff(br)
+		if err != nil {
+			return nil, errors.New("could not read coefficient data")
+		}
+		block[k] = t.Dequantize(stepIdx, q)
+	}
+	return block, nil
+}
+
+// stepIndices returns the index of the Quantizer step for
+// each used basis vector.
+func (i *compressedImage) stepIndices() []int {
+	if i.StepRanks == nil {
+		return i.UsedBasis
+	}
+	res := make([]int, len(i.UsedBasis))
+	for k, idx := range i.UsedBasis {
+		// The basis of a decoded image is verified later on,
+		// so idx may be out of bounds.
+		if idx >= 0 && idx < len(i.StepRanks) {
+			res[k] = i.StepRanks[idx]
+		} else {
+			res[k] = idx
+		}
+	}
+	return res
+}
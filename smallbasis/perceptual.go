@@ -0,0 +1,114 @@
+package smallbasis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// DefaultPixelsPerDegree approximates a typical display
+// viewed from a typical distance.
+const DefaultPixelsPerDegree = 32
+
+// A Weighting gives the relative visibility of a basis
+// vector with a given spatial frequency, measured in
+// cycles per pixel.
+//
+// Weightings are used to rank basis vectors, so only the
+// ratios between their values matter.
+type Weighting func(freq float64) float64
+
+// FlatWeighting treats all frequencies as equally
+// visible, ranking basis vectors by their total absolute
+// coefficients alone.
+func FlatWeighting(freq float64) float64 {
+	return 1
+}
+
+// CSFWeighting creates a Weighting from the contrast
+// sensitivity function of Mannos and Sakrison, given the
+// number of pixels in one degree of the viewer's visual
+// field.
+//
+// Frequencies below the peak sensitivity get a weight of
+// 1, so that the constant and slowly-varying components
+// of a block are never penalized.
+func CSFWeighting(pixelsPerDegree float64) Weighting {
+	// The function peaks at about 8 cycles per degree.
+	const peakFreq = 8.0
+	csf := func(f float64) float64 {
+		return 2.6 * (0.0192 + 0.114*f) * math.Exp(-math.Pow(0.114*f, 1.1))
+	}
+	peak := csf(peakFreq)
+	return func(freq float64) float64 {
+		f := freq * pixelsPerDegree
+		if f <= peakFreq {
+			return 1
+		}
+		return csf(f) / peak
+	}
+}
+
+// BasisFrequencies estimates the spatial frequency of
+// every column of a basis, in cycles per pixel.
+//
+// The frequency is derived from the energy of the
+// differences between neighboring pixels, which is exact
+// for sinusoids and gives a reasonable measure of scale
+// for other basis functions, such as wavelets.
+func BasisFrequencies(basis *linalg.Matrix, blockSize int) []float64 {
+	res := make([]float64, basis.Cols)
+	for col := range res {
+		var energy, xDiff, yDiff float64
+		for y := 0; y < blockSize; y++ {
+			for x := 0; x < blockSize; x++ {
+				val := basis.Get(blocker.PixelIndex(x, y, blockSize), col)
+				energy += val * val
+				if x+1 < blockSize {
+					d := basis.Get(blocker.PixelIndex(x+1, y, blockSize), col) - val
+					xDiff += d * d
+				}
+				if y+1 < blockSize {
+					d := basis.Get(blocker.PixelIndex(x, y+1, blockSize), col) - val
+					yDiff += d * d
+				}
+			}
+		}
+		if energy == 0 {
+			continue
+		}
+		fx := diffFrequency(xDiff / energy)
+		fy := diffFrequency(yDiff / energy)
+		res[col] = math.Sqrt(fx*fx + fy*fy)
+	}
+	return res
+}
+
+// frequencyRanks sorts the columns of a basis by their
+// spatial frequency and returns the position of each
+// column in the sorted order.
+// Columns with equal frequencies keep their order.
+func frequencyRanks(basis *linalg.Matrix, blockSize int) []int {
+	freqs := BasisFrequencies(basis, blockSize)
+	order := make([]int, len(freqs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return freqs[order[i]] < freqs[order[j]]
+	})
+	res := make([]int, len(order))
+	for rank, col := range order {
+		res[col] = rank
+	}
+	return res
+}
+
+// diffFrequency inverts the relationship between the
+// frequency f of a sinusoid and the relative energy of
+// its differences, which is 2-2*cos(2*pi*f).
+func diffFrequency(ratio float64) float64 {
+	return math.Acos(math.Max(-1, math.Min(1, 1-ratio/2))) / (2 * math.Pi)
+}
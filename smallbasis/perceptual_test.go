@@ -0,0 +1,105 @@
+package smallbasis
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+func TestFrequencyRanks(t *testing.T) {
+	bases := map[string]*linalg.Matrix{
+		"standard": BasisMatrix(64),
+		"dct":      DCTBasis(8),
+		"haar":     HaarBasis(8),
+	}
+	for name, basis := range bases {
+		ranks := frequencyRanks(basis, 8)
+		seen := map[int]bool{}
+		for _, r := range ranks {
+			if r < 0 || r >= len(ranks) || seen[r] {
+				t.Fatalf("%s: ranks are not a permutation: %v", name, ranks)
+			}
+			seen[r] = true
+		}
+		constCol := 0
+		if name == "standard" {
+			constCol = 63
+		}
+		if ranks[constCol] != 0 {
+			t.Errorf("%s: constant vector has rank %d", name, ranks[constCol])
+		}
+	}
+}
+
+func TestQuantizerConstantStep(t *testing.T) {
+	// Only the first step is fine enough to preserve the
+	// brightness of a flat image.
+	table := quantize.Linear(64, 1.0/512, 1, 0)
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 0x93
+	}
+	c := NewCompressorBlockSize(1.0/64, 8)
+	c.Quantizer = table
+	out, err := c.Decompress(c.Compress(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, _, _ := out.At(5, 5).RGBA()
+	if diff := int(r>>8) - 0x93; diff < -1 || diff > 1 {
+		t.Errorf("expected %d but got %d", 0x93, r>>8)
+	}
+}
+
+func TestBasisFrequenciesDCT(t *testing.T) {
+	const blockSize = 8
+	freqs := BasisFrequencies(DCTBasis(blockSize), blockSize)
+
+	// Find the frequency of each pair of 1D indices, using
+	// the column order of DCTBasis.
+	var grid [blockSize][blockSize]float64
+	col := 0
+	for sum := 0; sum <= 2*(blockSize-1); sum++ {
+		for v := 0; v < blockSize; v++ {
+			if u := sum - v; u >= 0 && u < blockSize {
+				grid[u][v] = freqs[col]
+				col++
+			}
+		}
+	}
+
+	for u := 0; u < blockSize; u++ {
+		for v := 0; v < blockSize; v++ {
+			// The DCT-II function with index u has u/2 cycles
+			// over the block.
+			expected := math.Hypot(float64(u), float64(v)) / (2 * blockSize)
+			if math.Abs(grid[u][v]-expected) > 1e-8 {
+				t.Errorf("(%d, %d): expected frequency %f but got %f", u, v, expected,
+					grid[u][v])
+			}
+			if u > 0 && grid[u][v] <= grid[u-1][v] {
+				t.Errorf("(%d, %d): frequency does not increase horizontally", u, v)
+			}
+			if v > 0 && grid[u][v] <= grid[u][v-1] {
+				t.Errorf("(%d, %d): frequency does not increase vertically", u, v)
+			}
+		}
+	}
+}
+
+func TestFlatWeighting(t *testing.T) {
+	img := testutil.Image(37, 21)
+	for _, quality := range []float64{0.1, 0.3, 0.7} {
+		unweighted := NewCompressorBlockSize(quality, 4)
+		weighted := NewCompressorBlockSize(quality, 4)
+		weighted.Weighting = FlatWeighting
+		if !bytes.Equal(weighted.Compress(img), unweighted.Compress(img)) {
+			t.Errorf("quality %f: FlatWeighting changes the basis selection", quality)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package smallbasis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/quantize"
+)
+
+// EncodeProgressive is like Encode, but it groups the
+// coefficients by basis vector instead of by block.
+// Each group is a layer that starts on a byte boundary.
+//
+// The basis vectors are always stored as a list, in the
+// order of i.UsedBasis, so that the most significant
+// coefficients can come first.
+func (i *compressedImage) EncodeProgressive() ([]byte, error) {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, encodedByteOrder, uint32(i.Width))
+	binary.Write(&buf, encodedByteOrder, uint32(i.Height))
+	buf.Write(i.encodeSparseBasis())
+	if err := i.encodeProgressiveBody(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeProgressiveBody writes the coefficients of the
+// image in progressive order, without its dimensions or
+// basis.
+func (i *compressedImage) encodeProgressiveBody(buf *bytes.Buffer) error {
+	buf.WriteByte(i.mode() | modeProgressive)
+	if i.Quantizer != nil {
+		i.encodeTable(buf)
+		stepIndices := i.stepIndices()
+		quantized := make([][]int, len(i.Blocks))
+		for j, block := range i.Blocks {
+			quantized[j] = i.Quantizer.QuantizeBlock(block, stepIndices, i.Weights, i.Lambda)
+		}
+		w := quantize.NewBitWriter(buf)
+		for k := range i.UsedBasis {
+			for _, block := range quantized {
+				if err := i.Quantizer.WriteCoeff(w, block[k]); err != nil {
+					return err
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	maxCoeff := i.maxCoefficient()
+	binary.Write(buf, encodedByteOrder, maxCoeff)
+	for k := range i.UsedBasis {
+		for _, block := range i.Blocks {
+			buf.WriteByte(coefficientByte(block[k], maxCoeff))
+		}
+	}
+	return nil
+}
+
+// decodeProgressiveImage performs the inverse of
+// EncodeProgressive.
+//
+// The header (including the quantization table or the
+// maximum coefficient) must be intact, but the data may
+// be truncated after any layer.
+// The coefficients of missing layers are left at zero.
+func decodeProgressiveImage(data []byte, blockSize int, stepRanks []int,
+	opts *limits.DecodeOptions) (*compressedImage, error) {
+	buf := bytes.NewBuffer(data)
+	res := &compressedImage{BlockSize: blockSize, StepRanks: stepRanks}
+
+	var width, height uint32
+	if err := binary.Read(buf, encodedByteOrder, &width); err != nil {
+		return nil, errors.New("missing width field")
+	}
+	if err := binary.Read(buf, encodedByteOrder, &height); err != nil {
+		return nil, errors.New("missing height field")
+	}
+	if err := opts.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return nil, err
+	}
+	res.Width = int(width)
+	res.Height = int(height)
+
+	if err := res.decodeSparseBasis(buf, opts); err != nil {
+		return nil, err
+	}
+	err := opts.CheckBlocks(uint64(width), uint64(height), blockSize, len(res.UsedBasis))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := res.decodeProgressiveBody(buf); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// decodeProgressiveBody performs the inverse of
+// encodeProgressiveBody, for an image whose dimensions
+// and basis are already known.
+func (i *compressedImage) decodeProgressiveBody(buf *bytes.Buffer) error {
+	mode, err := decodeMode(buf)
+	if err != nil {
+		return err
+	} else if mode&modeProgressive == 0 {
+		return errors.New("data is not progressive")
+	} else if err := i.checkSharedMode(mode); err != nil {
+		return err
+	}
+	blockCount := blocker.Count(image.Rect(0, 0, i.Width, i.Height), i.BlockSize)
+	i.Blocks = make([][]float64, blockCount)
+	for j := range i.Blocks {
+		i.Blocks[j] = make([]float64, len(i.UsedBasis))
+	}
+
+	if mode&modeQuantized != 0 {
+		if err := i.decodeTable(buf); err != nil {
+			return err
+		}
+		table := i.Quantizer
+		br := quantize.NewBitReader(buf)
+		for k, stepIdx := range i.stepIndices() {
+			br.Align()
+			if buf.Len() == 0 {
+				return nil
+			}
+			for _, block := range i.Blocks {
+				q, err := table.ReadCoeff(br)
+				if err != nil {
+					return errors.New("could not read coefficient data")
+				}
+				block[k] = table.Dequantize(stepIdx, q)
+			}
+		}
+		return nil
+	}
+
+	var maxCoeff float64
+	if err := binary.Read(buf, encodedByteOrder, &maxCoeff); err != nil {
+		return errors.New("missing maximum coefficient value")
+	}
+	for k := range i.UsedBasis {
+		if buf.Len() == 0 {
+			return nil
+		}
+		for _, block := range i.Blocks {
+			b, err := buf.ReadByte()
+			if err != nil {
+				return errors.New("could not read coefficient data")
+			}
+			val := float64(b)
+			val /= 0xff
+			val *= maxCoeff * 2
+			val -= maxCoeff
+			block[k] = val
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package smallbasis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/roi"
+)
+
+// TrainSharedBasis ranks the basis vectors over the
+// blocks of several images, such as a set of related
+// photos, and returns an encoding of the basis vectors
+// that are kept, along with the Quantizer (if there is
+// one).
+//
+// The selection can be passed to CompressShared and
+// DecompressShared so that it is only stored once for all
+// of the images.
+func (c *Compressor) TrainSharedBasis(images []image.Image) []byte {
+	r := c.newRankedVectors()
+	for _, img := range images {
+		r.addTotals(c.coefficientTotals(blocker.Blocks(img, c.blockSize)))
+	}
+	usedBasis := c.selectBasis(r)
+
+	var buf bytes.Buffer
+	if c.Progressive {
+		// Only a list can store the basis in ranked order.
+		ci := &compressedImage{UsedBasis: rankedBasis(r, len(usedBasis))}
+		buf.WriteByte(basisHeadingSparse)
+		buf.Write(ci.encodeSparseBasis())
+	} else {
+		ci := &compressedImage{UsedBasis: usedBasis, BlockSize: c.blockSize}
+		ci.encodeBasis(&buf)
+	}
+	if c.Quantizer != nil {
+		buf.WriteByte(modeQuantized)
+		quantize.WriteTable(&buf, c.Quantizer)
+	} else {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// CompressShared is like Compress, but it uses a basis
+// selection from TrainSharedBasis rather than storing a
+// selection in the compressed data.
+// The coefficients are quantized with the table in the
+// basis rather than with c.Quantizer.
+func (c *Compressor) CompressShared(basis []byte, i image.Image) ([]byte, error) {
+	usedBasis, table, err := c.readSharedBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBounds(i.Bounds()); err != nil {
+		return nil, err
+	}
+	compressed := c.projectImage(usedBasis, blocker.Blocks(i, c.blockSize), i.Bounds())
+	compressed.Quantizer = table
+	compressed.SharedTable = true
+
+	var buf bytes.Buffer
+	binary.Write(&buf, encodedByteOrder, uint32(compressed.Width))
+	binary.Write(&buf, encodedByteOrder, uint32(compressed.Height))
+	if c.Progressive {
+		err = compressed.encodeProgressiveBody(&buf)
+	} else {
+		if c.leveled() && table != nil {
+			compressed.Levels = roi.BlockLevels(roi.Saliency(i, c.blockSize), i.Bounds(),
+				c.blockSize)
+		}
+		err = compressed.encodeBody(&buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressShared decodes image data that was encoded by
+// CompressShared with the same basis selection.
+func (c *Compressor) DecompressShared(basis, d []byte) (image.Image, error) {
+	usedBasis, table, err := c.readSharedBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(d)
+	var width, height uint32
+	if err := binary.Read(buf, encodedByteOrder, &width); err != nil {
+		return nil, errors.New("missing width field")
+	}
+	if err := binary.Read(buf, encodedByteOrder, &height); err != nil {
+		return nil, errors.New("missing height field")
+	}
+	if err := c.DecodeOptions.CheckPixels(uint64(width), uint64(height)); err != nil {
+		return nil, err
+	}
+	err = c.DecodeOptions.CheckBlocks(uint64(width), uint64(height), c.blockSize,
+		len(usedBasis))
+	if err != nil {
+		return nil, err
+	}
+
+	ci := &compressedImage{
+		UsedBasis:   usedBasis,
+		StepRanks:   c.stepRanks,
+		BlockSize:   c.blockSize,
+		Width:       int(width),
+		Height:      int(height),
+		Quantizer:   table,
+		SharedTable: true,
+	}
+	if c.Progressive {
+		err = ci.decodeProgressiveBody(buf)
+	} else {
+		err = ci.decodeBody(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	basisVectors := c.basisVectors(ci.UsedBasis)
+	blockList := c.combineBlocks(basisVectors, ci.Blocks, c.blockSize)
+	return blocker.Image(ci.Width, ci.Height, blockList, c.blockSize), nil
+}
+
+// readSharedBasis decodes and validates a basis
+// selection and quantization table from
+// TrainSharedBasis.
+func (c *Compressor) readSharedBasis(basis []byte) ([]int, *quantize.Table, error) {
+	buf := bytes.NewBuffer(basis)
+	ci := &compressedImage{BlockSize: c.blockSize}
+	if err := ci.decodeBasis(buf, &c.DecodeOptions); err != nil {
+		return nil, nil, err
+	}
+	mode, err := decodeMode(buf)
+	if err != nil {
+		return nil, nil, err
+	} else if mode&(modeLeveled|modeProgressive) != 0 {
+		return nil, nil, fmt.Errorf("unknown mode: 0x%x", mode)
+	} else if mode&modeQuantized != 0 {
+		if err := ci.decodeTable(buf); err != nil {
+			return nil, nil, err
+		}
+	}
+	if buf.Len() != 0 {
+		return nil, nil, errors.New("unexpected data after shared basis")
+	}
+	if c.Progressive {
+		err = c.checkRankedBasis(ci.UsedBasis)
+	} else {
+		err = c.checkBasis(ci.UsedBasis)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return ci.UsedBasis, ci.Quantizer, nil
+}
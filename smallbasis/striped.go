@@ -0,0 +1,140 @@
+package smallbasis
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/strip"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// CompressStriped is like Compress, but it reads the image
+// one row of blocks at a time and writes the compressed
+// data to w as it goes, so the image never has to be in
+// memory all at once.
+//
+// It makes two passes over the source: one to rank the
+// basis vectors and one to project the blocks.
+//
+// The Compressor must have a Quantizer, since the 8-bit
+// quantization depends on every coefficient in the image.
+// Progressive and QualityMap are not supported, since
+// they also need the whole image.
+// The output can be decoded with Decompress as well as
+// with DecompressStriped.
+func (c *Compressor) CompressStriped(src strip.Source, w io.Writer) error {
+	if c.Quantizer == nil {
+		return errors.New("striped compression requires a quantizer")
+	} else if c.Progressive {
+		return errors.New("striped compression does not support progressive data")
+	} else if c.QualityMap {
+		return errors.New("striped compression does not support quality maps")
+	}
+
+	r := c.newRankedVectors()
+	err := strip.BlockRows(src, c.blockSize, func(row int, blocks []linalg.Vector) error {
+		r.addTotals(c.coefficientTotals(blocks))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	usedBasis := c.selectBasis(r)
+	basisVectors := c.basisVectors(usedBasis)
+	solver := projectionSolver(basisVectors)
+
+	compressed := &compressedImage{
+		UsedBasis: usedBasis,
+		BlockSize: c.blockSize,
+		Width:     src.Bounds().Dx(),
+		Height:    src.Bounds().Dy(),
+		Quantizer: c.Quantizer.Rounded(),
+		StepRanks: c.stepRanks,
+		Lambda:    c.Lambda,
+		Weights:   squaredNorms(basisVectors),
+	}
+	var header bytes.Buffer
+	compressed.encodeHeader(&header)
+	header.WriteByte(compressed.mode())
+	quantize.WriteTable(&header, compressed.Quantizer)
+	stepIndices := compressed.stepIndices()
+
+	bufWriter := bufio.NewWriter(w)
+	if _, err := bufWriter.Write(header.Bytes()); err != nil {
+		return err
+	}
+	bw := quantize.NewBitWriter(bufWriter)
+	err = strip.BlockRows(src, c.blockSize, func(row int, blocks []linalg.Vector) error {
+		for _, block := range c.projectWith(basisVectors, solver, blocks) {
+			if err := compressed.encodeQuantizedBlock(bw, compressed.Quantizer, stepIndices,
+				block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
+
+// DecompressStriped decodes data that was compressed with
+// a Quantizer, passing each row of blocks to sink as soon
+// as it has been decoded.
+func (c *Compressor) DecompressStriped(r io.Reader, sink strip.Sink) error {
+	br := bufio.NewReader(r)
+	ci, err := decodeHeader(br, c.blockSize, &c.DecodeOptions)
+	if err != nil {
+		return err
+	}
+	if err := c.checkBasis(ci.UsedBasis); err != nil {
+		return err
+	}
+	if mode, err := decodeMode(br); err != nil {
+		return err
+	} else if mode != modeQuantized {
+		return errors.New("striped decompression requires quantized data")
+	}
+	ci.StepRanks = c.stepRanks
+	ci.Quantizer, err = quantize.ReadTable(br)
+	if err != nil {
+		return errors.New("could not read quantization table: " + err.Error())
+	}
+	basisVectors := c.basisVectors(ci.UsedBasis)
+	err = c.DecodeOptions.CheckStrip(uint64(ci.Width), c.blockSize, len(ci.UsedBasis))
+	if err != nil {
+		return err
+	}
+
+	if err := sink.Start(image.Rect(0, 0, ci.Width, ci.Height)); err != nil {
+		return err
+	}
+	bitReader := quantize.NewBitReader(br)
+	stepIndices := ci.stepIndices()
+	rowBlocks := 3 * ((ci.Width + c.blockSize - 1) / c.blockSize)
+	numRows := (ci.Height + c.blockSize - 1) / c.blockSize
+	for row := 0; row < numRows; row++ {
+		coeffs := make([][]float64, rowBlocks)
+		for i := range coeffs {
+			coeffs[i], err = ci.decodeQuantizedBlock(bitReader, ci.Quantizer, stepIndices)
+			if err != nil {
+				return err
+			}
+		}
+		blocks := c.combineBlocks(basisVectors, coeffs, c.blockSize)
+		bounds := strip.StripBounds(ci.Width, ci.Height, c.blockSize, row)
+		if err := sink.WriteStrip(blocker.ImageRect(bounds, blocks, c.blockSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
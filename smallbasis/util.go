@@ -1,6 +1,8 @@
 package smallbasis
 
 import (
+	"errors"
+	"image"
 	"math"
 
 	"github.com/unixpickle/num-analysis/kahan"
@@ -25,6 +27,23 @@ func linearCombination(vecs []linalg.Vector, coeffs []float64) []float64 {
 	return res
 }
 
+func squaredNorms(vecs []linalg.Vector) []float64 {
+	res := make([]float64, len(vecs))
+	for i, v := range vecs {
+		res[i] = v.Dot(v)
+	}
+	return res
+}
+
+// checkBounds makes sure that the dimensions of an
+// image can be encoded.
+func checkBounds(b image.Rectangle) error {
+	if uint64(b.Dx()) > math.MaxUint32 || uint64(b.Dy()) > math.MaxUint32 {
+		return errors.New("image is too large")
+	}
+	return nil
+}
+
 func roundFloat(f float64) int {
 	return int(math.Floor(f + 0.5))
 }
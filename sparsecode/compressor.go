@@ -0,0 +1,219 @@
+package sparsecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	DefaultBlockSize = 8
+
+	// MaxAtoms is the largest number of atoms a Dictionary
+	// may have to be used by a Compressor.
+	MaxAtoms = 1 << 16
+
+	// MaxDimension is the largest atom dimension that
+	// ReadDictionary will accept.
+	MaxDimension = 1 << 16
+
+	// MaxSparsity is the largest number of atoms per
+	// block, since the sparsity is stored in a byte.
+	MaxSparsity = 0xff
+)
+
+// A Compressor codes each block of an image as a sparse
+// linear combination of atoms from an overcomplete
+// dictionary.
+//
+// This generalizes smallbasis, which prunes a square
+// basis: the dictionary may contain several bases, and
+// each block picks its own atoms.
+type Compressor struct {
+	// Sparsity is the maximum number of atoms per block.
+	// It is clamped between 1 and MaxSparsity.
+	Sparsity int
+
+	// MaxError is the squared error (with pixel values
+	// between 0 and 1) at which a block stops gaining
+	// atoms, even if it uses fewer than Sparsity.
+	MaxError float64
+
+	// Quantizer quantizes the coefficient of each atom,
+	// where the basis index of a coefficient is its atom
+	// index.
+	// The table is stored in (or referenced by) the
+	// compressed data.
+	Quantizer *quantize.Table
+
+	// Concurrency is the maximum number of goroutines used
+	// to process blocks.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	// The compressed data does not depend on Concurrency.
+	Concurrency int
+
+	// DecodeOptions limits the resources used to decode
+	// images, which is important for untrusted data.
+	DecodeOptions limits.DecodeOptions
+
+	dict      *Dictionary
+	blockSize int
+}
+
+// DefaultDictionary creates an overcomplete dictionary
+// from the DCT, Haar, and OrthoBasis bases.
+// The block size must be a power of two.
+func DefaultDictionary(blockSize int) *Dictionary {
+	return NewDictionary(
+		smallbasis.DCTBasis(blockSize),
+		smallbasis.HaarBasis(blockSize),
+		smallbasis.OrthoBasis(blockSize*blockSize),
+	)
+}
+
+// NewCompressor creates a Compressor that uses the
+// DefaultDictionary with the DefaultBlockSize.
+//
+// The quality argument ranges from 0 to 1 and indicates
+// the maximum fraction of a block's dimension that may
+// be spent on atoms.
+func NewCompressor(quality float64) *Compressor {
+	dict := DefaultDictionary(DefaultBlockSize)
+	sparsity := int(quality*float64(DefaultBlockSize*DefaultBlockSize) + 0.5)
+	return NewCompressorDictionary(sparsity, DefaultBlockSize, dict)
+}
+
+// NewCompressorDictionary creates a Compressor that uses
+// up to sparsity atoms from a dictionary to code each
+// block.
+//
+// The dictionary must have blockSize*blockSize rows, and
+// the same dictionary is needed to decompress images.
+func NewCompressorDictionary(sparsity, blockSize int, dict *Dictionary) *Compressor {
+	return &Compressor{
+		Sparsity:  clampSparsity(sparsity),
+		Quantizer: quantize.Uniform(1.0/128, 0),
+		dict:      dict,
+		blockSize: blockSize,
+	}
+}
+
+// Compress compresses an image and returns a binary
+// encoding of the result.
+func (c *Compressor) Compress(i image.Image) []byte {
+	var w bytes.Buffer
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dx()))
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dy()))
+	sparsity := clampSparsity(c.Sparsity)
+	w.WriteByte(byte(sparsity))
+	table := c.Quantizer.Rounded()
+	quantize.WriteTable(&w, table)
+
+	bw := quantize.NewBitWriter(&w)
+	countBits := bitLength(sparsity)
+	indexBits := c.dict.indexBits()
+	blocks := blocker.Blocks(i, c.blockSize)
+	indices := make([][]int, len(blocks))
+	coeffs := make([][]float64, len(blocks))
+	parallel.For(len(blocks), c.Concurrency, func(i int) {
+		indices[i], coeffs[i] = OMP(c.dict, blocks[i], sparsity, c.MaxError)
+	})
+	for i, blockIndices := range indices {
+		bw.WriteBits(uint64(len(blockIndices)), countBits)
+		for j, idx := range blockIndices {
+			bw.WriteBits(uint64(idx), indexBits)
+			table.WriteCoeff(bw, table.Quantize(idx, coeffs[i][j]))
+		}
+	}
+	bw.Flush()
+
+	return w.Bytes()
+}
+
+// Decompress decodes image data that was encoded
+// by Compress.
+func (c *Compressor) Decompress(b []byte) (image.Image, error) {
+	if c.dict.Dim() != c.blockSize*c.blockSize {
+		return nil, errors.New("block size mismatch")
+	}
+
+	r := bytes.NewBuffer(b)
+
+	var width, height uint32
+	if err := binary.Read(r, encodingEndian, &width); err != nil {
+		return nil, errors.New("failed to read width field: " + err.Error())
+	}
+	if err := binary.Read(r, encodingEndian, &height); err != nil {
+		return nil, errors.New("failed to read height field: " + err.Error())
+	}
+	// Each block is summed from full-size atoms.
+	err := c.DecodeOptions.CheckBlocks(uint64(width), uint64(height), c.blockSize,
+		c.dict.Dim())
+	if err != nil {
+		return nil, err
+	}
+	sparsity, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("failed to read sparsity")
+	}
+	table, err := quantize.ReadTable(r)
+	if err != nil {
+		return nil, errors.New("failed to read quantization table: " + err.Error())
+	}
+
+	br := quantize.NewBitReader(r)
+	countBits := bitLength(int(sparsity))
+	indexBits := c.dict.indexBits()
+
+	rect := image.Rect(0, 0, int(width), int(height))
+	blocks := make([]linalg.Vector, blocker.Count(rect, c.blockSize))
+	for i := range blocks {
+		count, err := br.ReadBits(countBits)
+		if err != nil {
+			return nil, errors.New("failed to read atom count: " + err.Error())
+		}
+		block := make(linalg.Vector, c.dict.Dim())
+		for j := 0; j < int(count); j++ {
+			idx, err := br.ReadBits(indexBits)
+			if err != nil {
+				return nil, errors.New("failed to read atom index: " + err.Error())
+			} else if int(idx) >= len(c.dict.Atoms) {
+				return nil, errors.New("atom index out of range")
+			}
+			q, err := table.ReadCoeff(br)
+			if err != nil {
+				return nil, errors.New("failed to read coefficient: " + err.Error())
+			}
+			block.Add(c.dict.Atoms[idx].Copy().Scale(table.Dequantize(int(idx), q)))
+		}
+		blocks[i] = block
+	}
+
+	return blocker.Image(rect.Dx(), rect.Dy(), blocks, c.blockSize), nil
+}
+
+func clampSparsity(sparsity int) int {
+	if sparsity < 1 {
+		return 1
+	} else if sparsity > MaxSparsity {
+		return MaxSparsity
+	}
+	return sparsity
+}
+
+func bitLength(x int) int {
+	var n int
+	for x != 0 {
+		x >>= 1
+		n++
+	}
+	return n
+}
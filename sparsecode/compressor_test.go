@@ -0,0 +1,29 @@
+package sparsecode
+
+import (
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+)
+
+func TestCompressSparsityOverflow(t *testing.T) {
+	for _, sparsity := range []int{-3, 0, 1000} {
+		c := NewCompressor(0.5)
+		c.Sparsity = sparsity
+		out, err := c.Decompress(c.Compress(testutil.Image(17, 9)))
+		if err != nil {
+			t.Fatalf("sparsity %d: %s", sparsity, err)
+		}
+		if out.Bounds().Dx() != 17 || out.Bounds().Dy() != 9 {
+			t.Fatalf("sparsity %d: bad bounds %v", sparsity, out.Bounds())
+		}
+	}
+}
+
+func TestCompressConcurrency(t *testing.T) {
+	testutil.CheckConcurrency(t, testutil.Image(40, 30), func(concurrency int) testutil.Codec {
+		c := NewCompressor(0.2)
+		c.Concurrency = concurrency
+		return c
+	})
+}
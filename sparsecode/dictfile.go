@@ -0,0 +1,81 @@
+package sparsecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	dictFileMagic   = "SDIC"
+	dictFileVersion = 1
+)
+
+// WriteDictionaryFile encodes a Dictionary along with
+// the number of training iterations that produced it.
+func WriteDictionaryFile(w io.Writer, d *Dictionary, iteration int) error {
+	if _, err := w.Write([]byte(dictFileMagic)); err != nil {
+		return err
+	}
+	header := []uint32{dictFileVersion, uint32(iteration)}
+	if err := binary.Write(w, encodingEndian, header); err != nil {
+		return err
+	}
+	_, err := d.WriteTo(w)
+	return err
+}
+
+// ReadDictionaryFile decodes a Dictionary that was
+// encoded with WriteDictionaryFile.
+func ReadDictionaryFile(r io.Reader) (d *Dictionary, iteration int, err error) {
+	magic := make([]byte, len(dictFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dictFileMagic {
+		return nil, 0, errors.New("not a dictionary file")
+	}
+	var header [2]uint32
+	if err := binary.Read(r, encodingEndian, &header); err != nil {
+		return nil, 0, errors.New("missing dictionary file header")
+	}
+	if header[0] != dictFileVersion {
+		return nil, 0, fmt.Errorf("unsupported dictionary file version: %d", header[0])
+	}
+	d, err = ReadDictionary(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, int(header[1]), nil
+}
+
+// LoadDictionary reads a dictionary file from a path.
+func LoadDictionary(path string) (d *Dictionary, iteration int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return ReadDictionaryFile(f)
+}
+
+// SaveDictionary writes a dictionary file to a path.
+//
+// The file is written to a temporary path first, so an
+// interrupted save never corrupts an existing checkpoint.
+func SaveDictionary(path string, d *Dictionary, iteration int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := WriteDictionaryFile(tmp, d, iteration); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+package sparsecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+var encodingEndian = binary.LittleEndian
+
+// A Dictionary is a list of unit vectors (atoms) which
+// may contain more vectors than their dimension.
+type Dictionary struct {
+	Atoms []linalg.Vector
+}
+
+// NewDictionary creates a Dictionary containing the
+// columns of every matrix, scaled to unit length.
+//
+// Each matrix must have the same number of rows.
+// Columns with zero magnitude are dropped.
+func NewDictionary(bases ...*linalg.Matrix) *Dictionary {
+	res := &Dictionary{}
+	for _, basis := range bases {
+		for col := 0; col < basis.Cols; col++ {
+			atom := basis.Col(col)
+			mag := atom.Mag()
+			if mag == 0 {
+				continue
+			}
+			res.Atoms = append(res.Atoms, atom.Scale(1/mag))
+		}
+	}
+	return res
+}
+
+// Dim returns the dimension of the atoms.
+func (d *Dictionary) Dim() int {
+	return len(d.Atoms[0])
+}
+
+// WriteTo encodes the dictionary.
+func (d *Dictionary) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+
+	header := []uint32{uint32(len(d.Atoms)), uint32(d.Dim())}
+	if err := binary.Write(w, encodingEndian, header); err != nil {
+		return written, err
+	}
+	written += 8
+
+	for _, atom := range d.Atoms {
+		for _, val := range atom {
+			if err := binary.Write(w, encodingEndian, float32(val)); err != nil {
+				return written, err
+			}
+			written += 4
+		}
+	}
+	return written, nil
+}
+
+// ReadDictionary decodes a Dictionary that was encoded
+// with WriteTo.
+func ReadDictionary(r io.Reader) (*Dictionary, error) {
+	var count, dimension uint32
+	if err := binary.Read(r, encodingEndian, &count); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, encodingEndian, &dimension); err != nil {
+		return nil, err
+	}
+
+	if count == 0 || dimension == 0 {
+		return nil, errors.New("dictionary must not be empty")
+	} else if count > MaxAtoms {
+		return nil, errors.New("dictionary has too many atoms")
+	} else if dimension > MaxDimension {
+		return nil, errors.New("dictionary atoms are too large")
+	}
+
+	res := &Dictionary{Atoms: make([]linalg.Vector, count)}
+	for i := range res.Atoms {
+		atom := make(linalg.Vector, dimension)
+		for j := range atom {
+			var val float32
+			if err := binary.Read(r, encodingEndian, &val); err != nil {
+				return nil, err
+			}
+			atom[j] = float64(val)
+		}
+		res.Atoms[i] = atom
+	}
+
+	return res, nil
+}
+
+// indexBits returns the number of bits needed to store
+// an atom index.
+func (d *Dictionary) indexBits() int {
+	return int(math.Ceil(math.Log2(float64(len(d.Atoms)))))
+}
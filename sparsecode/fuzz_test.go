@@ -0,0 +1,55 @@
+package sparsecode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+)
+
+func FuzzDecompress(f *testing.F) {
+	plain := NewCompressor(0.5)
+	quantized := NewCompressor(0.5)
+	quantized.Quantizer = quantize.Lookup(quantize.StandardTableID)
+	var codecs []testutil.Codec
+	for _, c := range []*Compressor{plain, quantized} {
+		c.DecodeOptions = testutil.FuzzOptions
+		codecs = append(codecs, c)
+	}
+	testutil.FuzzCodecs(f, codecs)
+}
+
+func FuzzReadDictionary(f *testing.F) {
+	var buf bytes.Buffer
+	if _, err := DefaultDictionary(2).WriteTo(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d, err := ReadDictionary(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(d.Atoms) > MaxAtoms || d.Dim() > MaxDimension {
+			t.Fatalf("dictionary is too large: %d atoms of %d", len(d.Atoms), d.Dim())
+		}
+	})
+}
+
+func FuzzReadDictionaryFile(f *testing.F) {
+	var buf bytes.Buffer
+	if err := WriteDictionaryFile(&buf, DefaultDictionary(2), 3); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d, iteration, err := ReadDictionaryFile(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if iteration < 0 || len(data) < 4+8+8+4*len(d.Atoms)*d.Dim() {
+			t.Fatal("dictionary file is larger than its data")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package sparsecode
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/imagecompress/smallbasis"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const powerIterations = 10
+
+// A Trainer learns a Dictionary from a set of training
+// blocks using K-SVD.
+//
+// Each step sparse-codes every block with OMP and then
+// updates each atom (and the coefficients that use it)
+// with the best rank-1 approximation of the error that
+// the atom is responsible for.
+type Trainer struct {
+	Dict     *Dictionary
+	Sparsity int
+	Blocks   []linalg.Vector
+
+	// Iteration counts the steps that have been run,
+	// including steps from previous runs if the Trainer
+	// was resumed from a checkpoint.
+	Iteration int
+}
+
+// NewTrainer creates a Trainer with an initial dictionary
+// of the given size.
+//
+// The initial dictionary starts with the DCT basis for
+// the block size (if it fits) and is then filled with
+// randomly chosen training blocks.
+func NewTrainer(blocks []linalg.Vector, atoms, sparsity int, r *rand.Rand) *Trainer {
+	dim := len(blocks[0])
+	blockSize := int(math.Sqrt(float64(dim)))
+
+	var initial []*linalg.Matrix
+	if blockSize*blockSize == dim && atoms >= dim {
+		initial = append(initial, smallbasis.DCTBasis(blockSize))
+	}
+	dict := NewDictionary(initial...)
+
+	for _, i := range r.Perm(len(blocks)) {
+		if len(dict.Atoms) >= atoms {
+			break
+		}
+		if mag := blocks[i].Mag(); mag > 0 {
+			dict.Atoms = append(dict.Atoms, blocks[i].Copy().Scale(1/mag))
+		}
+	}
+
+	return &Trainer{Dict: dict, Sparsity: sparsity, Blocks: blocks}
+}
+
+// Step runs one iteration of K-SVD.
+//
+// It returns the mean squared error per block of the
+// sparse codes computed at the start of the iteration.
+func (t *Trainer) Step() float64 {
+	indices := make([][]int, len(t.Blocks))
+	coeffs := make([][]float64, len(t.Blocks))
+	residuals := make([]linalg.Vector, len(t.Blocks))
+	users := make([][]int, len(t.Dict.Atoms))
+
+	var loss float64
+	for i, block := range t.Blocks {
+		indices[i], coeffs[i] = OMP(t.Dict, block, t.Sparsity, 0)
+		residual := block.Copy()
+		for j, idx := range indices[i] {
+			residual.Add(t.Dict.Atoms[idx].Copy().Scale(-coeffs[i][j]))
+			users[idx] = append(users[idx], i)
+		}
+		residuals[i] = residual
+		loss += residual.Dot(residual)
+	}
+
+	for k, blockIndices := range users {
+		if len(blockIndices) == 0 {
+			continue
+		}
+		t.updateAtom(k, blockIndices, indices, coeffs, residuals)
+	}
+
+	t.Iteration++
+	return loss / float64(len(t.Blocks))
+}
+
+// updateAtom replaces an atom and its coefficients with
+// the rank-1 approximation of the error matrix whose
+// columns are the residuals of the blocks that use the
+// atom (without the atom's contribution).
+func (t *Trainer) updateAtom(k int, blockIndices []int, indices [][]int,
+	coeffs [][]float64, residuals []linalg.Vector) {
+	atom := t.Dict.Atoms[k]
+	errs := make([]linalg.Vector, len(blockIndices))
+	coeffIdx := make([]int, len(blockIndices))
+	for i, blockIdx := range blockIndices {
+		for j, idx := range indices[blockIdx] {
+			if idx == k {
+				coeffIdx[i] = j
+			}
+		}
+		c := coeffs[blockIdx][coeffIdx[i]]
+		errs[i] = residuals[blockIdx].Copy().Add(atom.Copy().Scale(c))
+	}
+
+	// Find the top left singular vector with power iteration,
+	// starting from the current atom.
+	u := atom.Copy()
+	for iter := 0; iter < powerIterations; iter++ {
+		next := make(linalg.Vector, len(u))
+		for _, e := range errs {
+			next.Add(e.Copy().Scale(e.Dot(u)))
+		}
+		mag := next.Mag()
+		if mag == 0 {
+			return
+		}
+		u = next.Scale(1 / mag)
+	}
+
+	t.Dict.Atoms[k] = u
+	for i, blockIdx := range blockIndices {
+		c := errs[i].Dot(u)
+		coeffs[blockIdx][coeffIdx[i]] = c
+		residuals[blockIdx] = errs[i].Add(u.Copy().Scale(-c))
+	}
+}
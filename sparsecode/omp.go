@@ -0,0 +1,85 @@
+package sparsecode
+
+import (
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/num-analysis/linalg/cholesky"
+)
+
+// OMP approximates a signal as a linear combination of a
+// few dictionary atoms using Orthogonal Matching Pursuit.
+//
+// Atoms are added one at a time until maxAtoms atoms are
+// used or the squared error of the approximation is at
+// most maxError.
+//
+// The result contains the indices of the chosen atoms
+// in the order they were chosen, along with the
+// coefficients of the least-squares fit on those atoms.
+func OMP(d *Dictionary, signal linalg.Vector, maxAtoms int,
+	maxError float64) (indices []int, coeffs []float64) {
+	residual := signal.Copy()
+	used := map[int]bool{}
+
+	for len(indices) < maxAtoms && len(indices) < len(d.Atoms) {
+		if residual.Dot(residual) <= maxError {
+			break
+		}
+
+		bestIdx := -1
+		var bestDot float64
+		for i, atom := range d.Atoms {
+			if used[i] {
+				continue
+			}
+			if dot := math.Abs(atom.Dot(residual)); bestIdx < 0 || dot > bestDot {
+				bestIdx = i
+				bestDot = dot
+			}
+		}
+		if bestDot == 0 {
+			break
+		}
+
+		newIndices := append(append([]int{}, indices...), bestIdx)
+		newCoeffs, ok := leastSquares(d, newIndices, signal)
+		if !ok {
+			// The atom is linearly dependent on the atoms we
+			// already have, so it cannot reduce the error.
+			break
+		}
+		used[bestIdx] = true
+		indices, coeffs = newIndices, newCoeffs
+
+		residual = signal.Copy()
+		for i, idx := range indices {
+			residual.Add(d.Atoms[idx].Copy().Scale(-coeffs[i]))
+		}
+	}
+
+	return
+}
+
+// leastSquares solves for the coefficients of the atoms
+// which bring their linear combination as close to the
+// signal as possible.
+//
+// It fails if the atoms are linearly dependent.
+func leastSquares(d *Dictionary, indices []int, signal linalg.Vector) ([]float64, bool) {
+	gram := linalg.NewMatrix(len(indices), len(indices))
+	rhs := make(linalg.Vector, len(indices))
+	for i, idx1 := range indices {
+		for j, idx2 := range indices {
+			gram.Set(i, j, d.Atoms[idx1].Dot(d.Atoms[idx2]))
+		}
+		rhs[i] = d.Atoms[idx1].Dot(signal)
+	}
+	solution := cholesky.Decompose(gram).Solve(rhs)
+	for _, x := range solution {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, false
+		}
+	}
+	return []float64(solution), true
+}
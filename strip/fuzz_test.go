@@ -0,0 +1,38 @@
+package strip
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func FuzzPPMReader(f *testing.F) {
+	f.Add([]byte("P6\n3 2\n255\n\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c" +
+		"\x0d\x0e\x0f\x10\x11\x12"))
+	f.Add([]byte("P6 # comment\n1\t# width\n  1 # height\n# max value\n255\r\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := NewPPMReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		b := r.Bounds()
+		if b.Min != (image.Point{}) || b.Dx() < 0 || b.Dy() < 0 {
+			t.Fatalf("bad bounds: %v", b)
+		}
+		// Strips are as large as the header says, so only
+		// plausible images are read.
+		if b.Dx() > 1<<10 || b.Dy() > 1<<10 {
+			return
+		}
+		img, err := r.Strip(0, b.Dy())
+		if err != nil {
+			return
+		}
+		if img.Bounds() != b {
+			t.Fatalf("expected bounds %v but got %v", b, img.Bounds())
+		}
+		if int64(len(data)) < int64(b.Dx()*b.Dy()*3) {
+			t.Fatal("read more pixels than the data contains")
+		}
+	})
+}
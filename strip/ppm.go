@@ -0,0 +1,168 @@
+package strip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+)
+
+// maxPPMTokenSize is the longest field that a PPM header
+// may contain.
+const maxPPMTokenSize = 32
+
+// A PPMReader is a Source that reads a binary PPM (P6)
+// image with 8-bit samples straight from a file, without
+// loading the whole image into memory.
+type PPMReader struct {
+	r          io.ReadSeeker
+	bounds     image.Rectangle
+	dataOffset int64
+}
+
+// NewPPMReader reads the header of a PPM image.
+func NewPPMReader(r io.ReadSeeker) (*PPMReader, error) {
+	br := bufio.NewReader(r)
+	var fields [4]string
+	for i := range fields {
+		var err error
+		fields[i], err = readPPMToken(br)
+		if err != nil {
+			return nil, errors.New("invalid PPM header: " + err.Error())
+		}
+	}
+	magic := fields[0]
+	var dims [3]int
+	for i, field := range fields[1:] {
+		var err error
+		dims[i], err = strconv.Atoi(field)
+		if err != nil {
+			return nil, errors.New("invalid PPM header: " + err.Error())
+		}
+	}
+	width, height, maxVal := dims[0], dims[1], dims[2]
+	if magic != "P6" {
+		return nil, errors.New("only binary PPM (P6) images are supported")
+	} else if maxVal != 0xff {
+		return nil, errors.New("only 8-bit PPM images are supported")
+	} else if width < 0 || height < 0 {
+		return nil, errors.New("invalid PPM dimensions")
+	}
+
+	offset, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &PPMReader{
+		r:          r,
+		bounds:     image.Rect(0, 0, width, height),
+		dataOffset: offset - int64(br.Buffered()),
+	}, nil
+}
+
+// readPPMToken reads a field of a PPM header, skipping
+// any whitespace and comments before it.
+// It consumes the single whitespace character after the
+// field, which ends the header after the last field.
+func readPPMToken(br *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if isPPMSpace(b) {
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		} else if b == '#' && len(token) == 0 {
+			if _, err := br.ReadBytes('\n'); err != nil {
+				return "", err
+			}
+		} else if len(token) < maxPPMTokenSize {
+			token = append(token, b)
+		} else {
+			return "", errors.New("field is too long")
+		}
+	}
+}
+
+func isPPMSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// Bounds returns the bounds of the image.
+func (p *PPMReader) Bounds() image.Rectangle {
+	return p.bounds
+}
+
+// Strip reads the rows from minY to maxY.
+func (p *PPMReader) Strip(minY, maxY int) (image.Image, error) {
+	rowSize := int64(p.bounds.Dx() * 3)
+	if _, err := p.r.Seek(p.dataOffset+int64(minY)*rowSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	res := image.NewRGBA(image.Rect(0, minY, p.bounds.Dx(), maxY))
+	row := make([]byte, rowSize)
+	for y := minY; y < maxY; y++ {
+		if _, err := io.ReadFull(p.r, row); err != nil {
+			return nil, errors.New("failed to read PPM data: " + err.Error())
+		}
+		out := res.Pix[res.PixOffset(0, y):]
+		for x := 0; x < p.bounds.Dx(); x++ {
+			copy(out[x*4:x*4+3], row[x*3:x*3+3])
+			out[x*4+3] = 0xff
+		}
+	}
+	return res, nil
+}
+
+// A PPMWriter is a Sink that writes a binary PPM image
+// as strips arrive.
+type PPMWriter struct {
+	w      *bufio.Writer
+	bounds image.Rectangle
+}
+
+// NewPPMWriter creates a PPMWriter that writes to w.
+func NewPPMWriter(w io.Writer) *PPMWriter {
+	return &PPMWriter{w: bufio.NewWriter(w)}
+}
+
+// Start writes the PPM header.
+func (p *PPMWriter) Start(bounds image.Rectangle) error {
+	p.bounds = bounds
+	_, err := fmt.Fprintf(p.w, "P6\n%d %d\n255\n", bounds.Dx(), bounds.Dy())
+	return err
+}
+
+// WriteStrip writes the rows of a strip.
+func (p *PPMWriter) WriteStrip(strip image.Image) error {
+	b := strip.Bounds()
+	rgba, isRGBA := strip.(*image.RGBA)
+	row := make([]byte, 0, p.bounds.Dx()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := p.bounds.Min.X; x < p.bounds.Max.X; x++ {
+			if isRGBA {
+				px := rgba.Pix[rgba.PixOffset(x, y):]
+				row = append(row, px[0], px[1], px[2])
+			} else {
+				r, g, bl, _ := strip.At(x, y).RGBA()
+				row = append(row, byte(r>>8), byte(g>>8), byte(bl>>8))
+			}
+		}
+		if _, err := p.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes any buffered data.
+// It should be called after the last strip.
+func (p *PPMWriter) Flush() error {
+	return p.w.Flush()
+}
@@ -0,0 +1,83 @@
+package strip
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestPPMReaderComments(t *testing.T) {
+	pixels := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18}
+	headers := []string{
+		"P6\n3 2\n255\n",
+		"P6\n# CREATOR: GIMP PNM Filter Version 1.1\n3 2\n255\n",
+		"P6 # comment\n3\t# width\n  2 # height\n# max value\n255\r",
+		"#leading comment\nP6\n3 2 255 ",
+	}
+	for _, header := range headers {
+		data := append([]byte(header), pixels...)
+		r, err := NewPPMReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%q: %s", header, err)
+		}
+		if r.Bounds() != image.Rect(0, 0, 3, 2) {
+			t.Fatalf("%q: bad bounds %v", header, r.Bounds())
+		}
+		img, err := r.Strip(0, 2)
+		if err != nil {
+			t.Fatalf("%q: %s", header, err)
+		}
+		rgba := img.(*image.RGBA)
+		if rgba.Pix[0] != 1 || rgba.Pix[4*5+2] != 18 {
+			t.Fatalf("%q: bad pixel data", header)
+		}
+	}
+}
+
+func TestPPMReaderInvalid(t *testing.T) {
+	headers := []string{
+		"",
+		"P3\n3 2\n255\n",
+		"P6\n3 2\n65535\n",
+		"P6\n3 x\n255\n",
+		"P6\n-3 2\n255\n",
+		"P6\n3 2\n255",
+		"P6\n# unterminated comment",
+		"P6\n3 99999999999999999999999999999999999\n255\n",
+	}
+	for _, header := range headers {
+		if _, err := NewPPMReader(bytes.NewReader([]byte(header))); err == nil {
+			t.Errorf("%q: expected an error", header)
+		}
+	}
+}
+
+func TestPPMRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 5, 4))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+	var buf bytes.Buffer
+	w := NewPPMWriter(&buf)
+	if err := w.Start(img.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteStrip(img); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	r, err := NewPPMReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := r.Strip(0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.(*image.RGBA).Pix, img.Pix) {
+		t.Fatal("pixels differ")
+	}
+}
@@ -0,0 +1,129 @@
+// Package strip moves images around one horizontal strip
+// at a time, so that images which do not fit in memory
+// can still be compressed and decompressed.
+package strip
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// A Source provides the rows of an image in strips.
+type Source interface {
+	// Bounds returns the bounds of the full image.
+	Bounds() image.Rectangle
+
+	// Strip returns an image containing (at least) the
+	// rows from minY to maxY, using the coordinates of the
+	// full image.
+	//
+	// Strips are usually requested from top to bottom,
+	// but a Source must support starting over from the
+	// top of the image, since encoders may make more than
+	// one pass.
+	Strip(minY, maxY int) (image.Image, error)
+}
+
+// A Sink receives an image one strip at a time.
+type Sink interface {
+	// Start is called with the bounds of the full image
+	// before any strips are written.
+	Start(bounds image.Rectangle) error
+
+	// WriteStrip is called with consecutive strips of the
+	// image, from top to bottom.
+	WriteStrip(strip image.Image) error
+}
+
+type imageSource struct {
+	img image.Image
+}
+
+// ImageSource creates a Source for an image that is
+// already in memory.
+func ImageSource(img image.Image) Source {
+	return imageSource{img}
+}
+
+func (i imageSource) Bounds() image.Rectangle {
+	return i.img.Bounds()
+}
+
+func (i imageSource) Strip(minY, maxY int) (image.Image, error) {
+	b := i.img.Bounds()
+	r := image.Rect(b.Min.X, minY, b.Max.X, maxY).Intersect(b)
+	if sub, ok := i.img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r), nil
+	}
+	res := image.NewRGBA(r)
+	draw.Draw(res, r, i.img, r.Min, draw.Src)
+	return res, nil
+}
+
+// An ImageSink assembles strips into an image in memory.
+type ImageSink struct {
+	Image *image.RGBA
+
+	// DecodeOptions limits the size of the image that
+	// Start allocates.
+	DecodeOptions limits.DecodeOptions
+}
+
+// Start allocates the image.
+func (i *ImageSink) Start(bounds image.Rectangle) error {
+	w, h := uint64(bounds.Dx()), uint64(bounds.Dy())
+	if err := i.DecodeOptions.CheckMemory(limits.Product(w, h, 4)); err != nil {
+		return err
+	}
+	i.Image = image.NewRGBA(bounds)
+	return nil
+}
+
+// WriteStrip copies a strip into the image.
+func (i *ImageSink) WriteStrip(strip image.Image) error {
+	draw.Draw(i.Image, strip.Bounds(), strip, strip.Bounds().Min, draw.Src)
+	return nil
+}
+
+// BlockRows calls f with the blocks (as produced by
+// blocker.Blocks) of each row of blocks in a Source, from
+// top to bottom.
+//
+// Only one row of blocks is in memory at a time.
+func BlockRows(src Source, blockSize int, f func(row int, blocks []linalg.Vector) error) error {
+	b := src.Bounds()
+	for row, y := 0, b.Min.Y; y < b.Max.Y; row, y = row+1, y+blockSize {
+		maxY := y + blockSize
+		if maxY > b.Max.Y {
+			maxY = b.Max.Y
+		}
+		img, err := src.Strip(y, maxY)
+		if err != nil {
+			return err
+		}
+		// Crop the strip in case the Source gave us more
+		// rows than we asked for.
+		cropped := imageSource{img}
+		img, _ = cropped.Strip(y, maxY)
+		if err := f(row, blocker.Blocks(img, blockSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StripBounds returns the bounds of the strip for a row
+// of blocks in a decoded image of the given size.
+func StripBounds(width, height, blockSize, row int) image.Rectangle {
+	maxY := (row + 1) * blockSize
+	if maxY > height {
+		maxY = height
+	}
+	return image.Rect(0, row*blockSize, width, maxY)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/unixpickle/imagecompress/strip"
+)
+
+// A StripedCompressor can compress and decompress images
+// one strip at a time, using a bounded amount of memory.
+type StripedCompressor interface {
+	CompressStriped(src strip.Source, w io.Writer) error
+	DecompressStriped(r io.Reader, sink strip.Sink) error
+}
+
+func compressStriped(c Compressor, inFile, outFile string) error {
+	sc, ok := c.(StripedCompressor)
+	if !ok {
+		return errors.New("compressor does not support striped compression")
+	}
+	in, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	src, err := strip.NewPPMReader(in)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := sc.CompressStriped(src, out); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func decompressStriped(c Compressor, inFile, outFile string) error {
+	sc, ok := c.(StripedCompressor)
+	if !ok {
+		return errors.New("compressor does not support striped decompression")
+	}
+	in, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	sink := strip.NewPPMWriter(out)
+	if err := sc.DecompressStriped(in, sink); err != nil {
+		return err
+	}
+	if err := sink.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,68 @@
+package tiled
+
+import "image"
+
+// concealDamage fills damaged rectangles of an image by
+// interpolating the intact pixels that border them.
+//
+// Each pixel becomes an average of the nearest intact
+// pixels to its left, right, top, and bottom, weighted by
+// the inverse of their distances.
+// Pixels with no intact neighbors become gray.
+func concealDamage(img *image.RGBA, damaged []image.Rectangle) {
+	intact := func(p image.Point) bool {
+		if !p.In(img.Bounds()) {
+			return false
+		}
+		for _, r := range damaged {
+			if p.In(r) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, r := range damaged {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				neighbors := [4]image.Point{
+					{r.Min.X - 1, y},
+					{r.Max.X, y},
+					{x, r.Min.Y - 1},
+					{x, r.Max.Y},
+				}
+				var sums [4]float64
+				var totalWeight float64
+				for _, n := range neighbors {
+					if !intact(n) {
+						continue
+					}
+					dist := absInt(n.X-x) + absInt(n.Y-y)
+					weight := 1 / float64(dist)
+					idx := img.PixOffset(n.X, n.Y)
+					for c := range sums {
+						sums[c] += weight * float64(img.Pix[idx+c])
+					}
+					totalWeight += weight
+				}
+				idx := img.PixOffset(x, y)
+				for c := range sums {
+					if totalWeight == 0 {
+						img.Pix[idx+c] = 0x80
+					} else {
+						img.Pix[idx+c] = uint8(sums[c]/totalWeight + 0.5)
+					}
+				}
+				if totalWeight == 0 {
+					img.Pix[idx+3] = 0xff
+				}
+			}
+		}
+	}
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
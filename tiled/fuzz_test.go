@@ -0,0 +1,51 @@
+package tiled
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func fuzzCompressor(bestEffort bool) *Compressor {
+	codec := smallbasis.NewCompressorBlockSize(0.5, 4)
+	codec.DecodeOptions = testutil.FuzzOptions
+	c := NewCompressor(codec)
+	c.TileSize = 8
+	c.DecodeOptions = testutil.FuzzOptions
+	c.BestEffort = bestEffort
+	return c
+}
+
+func FuzzDecompress(f *testing.F) {
+	f.Add(fuzzCompressor(false).Compress(testutil.Image(19, 11)), false)
+	f.Add(fuzzCompressor(false).Compress(testutil.Image(1, 1)), true)
+	f.Fuzz(func(t *testing.T, data []byte, bestEffort bool) {
+		c := fuzzCompressor(bestEffort)
+		if img, err := c.Decompress(data); err == nil {
+			testutil.CheckFuzzBounds(t, img.Bounds())
+		}
+		r := bytes.NewReader(data)
+		if img, err := c.DecodeRegion(r, image.Rect(3, 2, 12, 9)); err == nil {
+			if !img.Bounds().In(image.Rect(3, 2, 12, 9)) {
+				t.Fatalf("region has bad bounds: %v", img.Bounds())
+			}
+		}
+		c.Verify(r)
+	})
+}
+
+func TestDecompressDimensions(t *testing.T) {
+	c := testCompressor()
+	for _, size := range []image.Point{{1, 1}, {32, 32}, {33, 70}} {
+		decoded, err := c.Decompress(c.Compress(testutil.Image(size.X, size.Y)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Bounds() != (image.Rectangle{Max: size}) {
+			t.Errorf("bad bounds for %v: %v", size, decoded.Bounds())
+		}
+	}
+}
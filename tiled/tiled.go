@@ -0,0 +1,406 @@
+// Package tiled stores images as grids of independently
+// compressed tiles, so that regions of an image can be
+// decoded without decoding the rest of it.
+package tiled
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/parallel"
+)
+
+const (
+	DefaultTileSize = 512
+
+	// maxTiles limits the size of the index table that a
+	// decoder is willing to read.
+	maxTiles = 1 << 24
+
+	// The header consists of the magic, the header fields,
+	// and a checksum of both.
+	headerSize     = 4 + 4*6 + 8 + 4
+	indexEntrySize = 8 + 4 + 4
+)
+
+const fileMagic = "ICTL"
+
+// formatVersion is stored right after the magic, so that
+// decoders can reject layouts they do not understand
+// before interpreting the rest of the header.
+const formatVersion = 1
+
+var encodingEndian = binary.LittleEndian
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A Codec compresses individual tiles.
+type Codec interface {
+	Compress(i image.Image) []byte
+	Decompress(d []byte) (image.Image, error)
+}
+
+// A Compressor splits images into square tiles and
+// compresses each tile with a Codec.
+//
+// The compressed data starts with an index table listing
+// the offset of each tile, so DecodeRegion only has to
+// read and decode the tiles that a region overlaps.
+//
+// The header and every tile have CRC-32C checksums, so
+// damaged tiles are detected and can be located with
+// Verify.
+// The Codec's own data has no checksums, so a tile is the
+// smallest region that can be found to be damaged.
+// In particular, the block rows of the smallbasis and
+// pcaprune streams are not checksummed; a smaller
+// TileSize localizes damage more finely.
+type Compressor struct {
+	Codec    Codec
+	TileSize int
+
+	// Concurrency is the maximum number of tiles that are
+	// compressed or decompressed at once.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	Concurrency int
+
+	// DecodeOptions limits the size of decoded regions and
+	// the size of the compressed tiles that are read.
+	// Tiles themselves are decoded by the Codec, which
+	// should enforce its own limits.
+	DecodeOptions limits.DecodeOptions
+
+	// BestEffort, if true, makes the decoder fill damaged
+	// tiles by interpolating their neighbors instead of
+	// failing.
+	// The header must still be intact.
+	BestEffort bool
+}
+
+// NewCompressor creates a Compressor with the
+// DefaultTileSize.
+//
+// For best results, the tile size should be a multiple
+// of the block size used by the codec.
+func NewCompressor(codec Codec) *Compressor {
+	return &Compressor{Codec: codec, TileSize: DefaultTileSize}
+}
+
+type header struct {
+	Version  uint32
+	Width    uint32
+	Height   uint32
+	TileSize uint32
+	Cols     uint32
+	Rows     uint32
+
+	// Size is the total size of the compressed image, which
+	// bounds the tiles before their checksums are known.
+	Size uint64
+}
+
+type indexEntry struct {
+	Offset uint64
+	Length uint32
+
+	// Checksum covers the offset and length of the entry
+	// as well as the data of the tile, so that damage to
+	// either is detected.
+	Checksum uint32
+}
+
+// Compress compresses an image and returns a binary
+// encoding of the result.
+//
+// It panics if the TileSize is not positive or the image
+// is too large; use CompressChecked to get an error
+// instead.
+func (c *Compressor) Compress(img image.Image) []byte {
+	res, err := c.CompressChecked(img)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// CompressChecked is like Compress, but it returns an
+// error if the TileSize is not positive or the image is
+// too large to encode.
+func (c *Compressor) CompressChecked(img image.Image) ([]byte, error) {
+	if c.TileSize < 1 {
+		return nil, errors.New("tile size must be positive")
+	} else if uint64(c.TileSize) > math.MaxUint32 {
+		return nil, errors.New("tile size is too large")
+	}
+	b := img.Bounds()
+	if uint64(b.Dx()) > math.MaxUint32 || uint64(b.Dy()) > math.MaxUint32 {
+		return nil, errors.New("image is too large")
+	}
+	cols := (uint64(b.Dx()) + uint64(c.TileSize) - 1) / uint64(c.TileSize)
+	rows := (uint64(b.Dy()) + uint64(c.TileSize) - 1) / uint64(c.TileSize)
+	if cols*rows > maxTiles {
+		return nil, errors.New("too many tiles")
+	}
+	h := header{
+		Version:  formatVersion,
+		Width:    uint32(b.Dx()),
+		Height:   uint32(b.Dy()),
+		TileSize: uint32(c.TileSize),
+		Cols:     uint32(cols),
+		Rows:     uint32(rows),
+	}
+
+	tiles := make([][]byte, h.Cols*h.Rows)
+	parallel.For(len(tiles), c.Concurrency, func(i int) {
+		tileRect := h.tileRect(i).Add(b.Min)
+		tiles[i] = c.Codec.Compress(subImage(img, tileRect))
+	})
+
+	offset := h.dataOffset()
+	h.Size = offset
+	for _, tile := range tiles {
+		h.Size += uint64(len(tile))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fileMagic)
+	binary.Write(&buf, encodingEndian, h)
+	binary.Write(&buf, encodingEndian, crc32.Checksum(buf.Bytes(), crcTable))
+	for _, tile := range tiles {
+		entry := indexEntry{Offset: offset, Length: uint32(len(tile))}
+		entry.Checksum = entry.checksum(tile)
+		binary.Write(&buf, encodingEndian, entry)
+		offset += uint64(len(tile))
+	}
+	for _, tile := range tiles {
+		buf.Write(tile)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decodes an entire image that was encoded
+// by Compress.
+func (c *Compressor) Decompress(d []byte) (image.Image, error) {
+	return c.DecodeRegion(bytes.NewReader(d), image.Rect(0, 0, 1<<31-1, 1<<31-1))
+}
+
+// Bounds reads the bounds of a compressed image without
+// decoding any tiles.
+func (c *Compressor) Bounds(r io.ReaderAt) (image.Rectangle, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return image.Rect(0, 0, int(h.Width), int(h.Height)), nil
+}
+
+// DecodeRegion decodes the part of a compressed image
+// that lies inside a rectangle, where the top-left pixel
+// of the image is at (0, 0).
+//
+// Only the index table and the tiles overlapping the
+// rectangle are read from r.
+// The resulting image has the bounds of the rectangle,
+// clipped to the bounds of the image.
+func (c *Compressor) DecodeRegion(r io.ReaderAt, rect image.Rectangle) (image.Image, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	rect = rect.Intersect(image.Rect(0, 0, int(h.Width), int(h.Height)))
+	w, ht := uint64(rect.Dx()), uint64(rect.Dy())
+	if err := c.DecodeOptions.CheckPixels(w, ht); err != nil {
+		return nil, err
+	}
+	if err := c.DecodeOptions.CheckMemory(limits.Product(w, ht, 4)); err != nil {
+		return nil, err
+	}
+	res := image.NewRGBA(rect)
+	if rect.Empty() {
+		return res, nil
+	}
+
+	ts := int(h.TileSize)
+	var tileIndices []int
+	for row := rect.Min.Y / ts; row*ts < rect.Max.Y; row++ {
+		for col := rect.Min.X / ts; col*ts < rect.Max.X; col++ {
+			tileIndices = append(tileIndices, row*int(h.Cols)+col)
+		}
+	}
+
+	errs := make([]error, len(tileIndices))
+	parallel.For(len(tileIndices), c.Concurrency, func(i int) {
+		errs[i] = c.decodeTile(r, h, tileIndices[i], res)
+	})
+	var damaged []image.Rectangle
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*limits.LimitError); ok || !c.BestEffort {
+			return nil, err
+		}
+		damaged = append(damaged, h.tileRect(tileIndices[i]).Intersect(rect))
+	}
+	if len(damaged) > 0 {
+		concealDamage(res, damaged)
+	}
+	return res, nil
+}
+
+// Verify checks the header and the checksum of every tile
+// without decoding any tiles, returning the bounds of the
+// damaged tiles.
+//
+// An error is returned if the header itself is damaged,
+// in which case the tiles cannot be located.
+func (c *Compressor) Verify(r io.ReaderAt) ([]image.Rectangle, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	numTiles := int(h.Cols * h.Rows)
+	damaged := make([]bool, numTiles)
+	parallel.For(numTiles, c.Concurrency, func(i int) {
+		_, err := c.readTile(r, h, i)
+		damaged[i] = err != nil
+	})
+	var res []image.Rectangle
+	for i, d := range damaged {
+		if d {
+			res = append(res, h.tileRect(i))
+		}
+	}
+	return res, nil
+}
+
+// decodeTile decodes a tile and draws the part of it that
+// overlaps dst.
+// Tiles never overlap, so separate tiles may be drawn
+// into dst concurrently.
+func (c *Compressor) decodeTile(r io.ReaderAt, h *header, idx int, dst *image.RGBA) error {
+	data, err := c.readTile(r, h, idx)
+	if err != nil {
+		return err
+	}
+	tile, err := c.Codec.Decompress(data)
+	if _, ok := err.(*limits.LimitError); ok {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("failed to decode tile %d: %s", idx, err)
+	}
+
+	tileRect := h.tileRect(idx)
+	if tile.Bounds().Dx() != tileRect.Dx() || tile.Bounds().Dy() != tileRect.Dy() {
+		return fmt.Errorf("tile %d has unexpected dimensions", idx)
+	}
+	target := tileRect.Intersect(dst.Bounds())
+	srcPoint := tile.Bounds().Min.Add(target.Min.Sub(tileRect.Min))
+	draw.Draw(dst, target, tile, srcPoint, draw.Src)
+	return nil
+}
+
+// readTile reads the data of a tile and verifies its
+// checksum.
+func (c *Compressor) readTile(r io.ReaderAt, h *header, idx int) ([]byte, error) {
+	var entryData [indexEntrySize]byte
+	entryOffset := int64(headerSize + indexEntrySize*idx)
+	if _, err := r.ReadAt(entryData[:], entryOffset); err != nil {
+		return nil, errors.New("failed to read tile index: " + err.Error())
+	}
+	var entry indexEntry
+	binary.Read(bytes.NewReader(entryData[:]), encodingEndian, &entry)
+
+	// The entry is not known to be intact until the data is
+	// read, so it is bounded by the header first.
+	if entry.Offset < h.dataOffset() || entry.Offset > h.Size ||
+		uint64(entry.Length) > h.Size-entry.Offset {
+		return nil, fmt.Errorf("tile %d is out of bounds", idx)
+	}
+	if err := c.DecodeOptions.CheckMemory(uint64(entry.Length)); err != nil {
+		return nil, err
+	}
+	data := make([]byte, entry.Length)
+	if _, err := r.ReadAt(data, int64(entry.Offset)); err != nil {
+		return nil, fmt.Errorf("failed to read tile %d: %s", idx, err)
+	}
+	if entry.checksum(data) != entry.Checksum {
+		return nil, fmt.Errorf("checksum mismatch for tile %d", idx)
+	}
+	return data, nil
+}
+
+func readHeader(r io.ReaderAt) (*header, error) {
+	var data [headerSize]byte
+	if _, err := r.ReadAt(data[:], 0); err != nil {
+		return nil, errors.New("failed to read header: " + err.Error())
+	}
+	if string(data[:4]) != fileMagic {
+		return nil, errors.New("not a tiled image")
+	}
+	if version := encodingEndian.Uint32(data[4:]); version != formatVersion {
+		return nil, fmt.Errorf("unsupported tiled image version: %d", version)
+	}
+	checksum := encodingEndian.Uint32(data[headerSize-4:])
+	if crc32.Checksum(data[:headerSize-4], crcTable) != checksum {
+		return nil, errors.New("checksum mismatch for header")
+	}
+	var h header
+	binary.Read(bytes.NewReader(data[4:headerSize-4]), encodingEndian, &h)
+	if h.TileSize == 0 {
+		return nil, errors.New("invalid tile size")
+	}
+	ts := uint64(h.TileSize)
+	if uint64(h.Cols) != (uint64(h.Width)+ts-1)/ts ||
+		uint64(h.Rows) != (uint64(h.Height)+ts-1)/ts {
+		return nil, errors.New("tile grid does not match image dimensions")
+	}
+	if uint64(h.Cols)*uint64(h.Rows) > maxTiles {
+		return nil, errors.New("too many tiles")
+	}
+	if h.Size < h.dataOffset() {
+		return nil, errors.New("invalid image size")
+	}
+	return &h, nil
+}
+
+// dataOffset returns the offset of the first tile, right
+// after the index table.
+func (h *header) dataOffset() uint64 {
+	return headerSize + indexEntrySize*uint64(h.Cols)*uint64(h.Rows)
+}
+
+func (e *indexEntry) checksum(data []byte) uint32 {
+	var fields [12]byte
+	encodingEndian.PutUint64(fields[:], e.Offset)
+	encodingEndian.PutUint32(fields[8:], e.Length)
+	return crc32.Update(crc32.Checksum(fields[:], crcTable), crcTable, data)
+}
+
+// tileRect returns the bounds of a tile, relative to the
+// top-left corner of the image.
+func (h *header) tileRect(idx int) image.Rectangle {
+	ts := int(h.TileSize)
+	col, row := idx%int(h.Cols), idx/int(h.Cols)
+	r := image.Rect(col*ts, row*ts, (col+1)*ts, (row+1)*ts)
+	return r.Intersect(image.Rect(0, 0, int(h.Width), int(h.Height)))
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	res := image.NewRGBA(r)
+	draw.Draw(res, r, img, r.Min, draw.Src)
+	return res
+}
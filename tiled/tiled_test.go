@@ -0,0 +1,189 @@
+package tiled
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func testCompressor() *Compressor {
+	c := NewCompressor(smallbasis.NewCompressorBlockSize(0.3, 8))
+	c.TileSize = 32
+	return c
+}
+
+func TestCompressConcurrency(t *testing.T) {
+	testutil.CheckConcurrency(t, testutil.Image(100, 70), func(concurrency int) testutil.Codec {
+		c := testCompressor()
+		c.Concurrency = concurrency
+		return c
+	})
+}
+
+func TestCompressChecked(t *testing.T) {
+	img := testutil.Image(10, 10)
+	for _, tileSize := range []int{0, -32} {
+		c := testCompressor()
+		c.TileSize = tileSize
+		if _, err := c.CompressChecked(img); err == nil {
+			t.Errorf("tile size %d: expected error", tileSize)
+		}
+	}
+	c := testCompressor()
+	c.TileSize = 1
+	if _, err := c.CompressChecked(&image.Gray{Rect: image.Rect(0, 0, 1<<13, 1<<12)}); err == nil {
+		t.Error("expected error for too many tiles")
+	}
+}
+
+// damageTile flips a bit in the data of a tile.
+func damageTile(data []byte, idx int) {
+	entry := data[headerSize+indexEntrySize*idx:]
+	data[encodingEndian.Uint64(entry)+1] ^= 1
+}
+
+func TestVerify(t *testing.T) {
+	c := testCompressor()
+	data := c.Compress(testutil.Image(100, 70))
+	if damaged, err := c.Verify(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if len(damaged) != 0 {
+		t.Errorf("unexpected damaged tiles: %v", damaged)
+	}
+
+	damageTile(data, 5)
+	damaged, err := c.Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []image.Rectangle{image.Rect(32, 32, 64, 64)}
+	if !reflect.DeepEqual(damaged, expected) {
+		t.Errorf("expected damaged tiles %v but got %v", expected, damaged)
+	}
+	if _, err := c.Decompress(data); err == nil {
+		t.Error("expected error for damaged tile")
+	}
+
+	data[10] ^= 1
+	if _, err := c.Verify(bytes.NewReader(data)); err == nil {
+		t.Error("expected error for damaged header")
+	}
+}
+
+func TestReadTileBounds(t *testing.T) {
+	c := testCompressor()
+	data := c.Compress(testutil.Image(40, 40))
+	for _, field := range []struct {
+		Offset int
+		Value  uint64
+		Size   int
+	}{
+		{0, 0, 8},
+		{0, uint64(len(data)) + 1, 8},
+		{0, 1 << 63, 8},
+		{8, 1 << 24, 4},
+	} {
+		damaged := append([]byte{}, data...)
+		entry := damaged[headerSize+indexEntrySize*2+field.Offset:]
+		if field.Size == 8 {
+			encodingEndian.PutUint64(entry, field.Value)
+		} else {
+			encodingEndian.PutUint32(entry, uint32(field.Value))
+		}
+		r := &sizeCheckingReader{t: t, data: damaged}
+		if _, err := c.readTile(r, mustReadHeader(t, r), 2); err == nil {
+			t.Errorf("expected error for %+v", field)
+		}
+	}
+}
+
+func TestBestEffort(t *testing.T) {
+	c := testCompressor()
+	img := testutil.Image(100, 70)
+	data := c.Compress(img)
+	expected, err := c.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damageTile(data, 5)
+	damagedRect := image.Rect(32, 32, 64, 64)
+
+	c.BestEffort = true
+	actual, err := c.Decompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Bounds() != expected.Bounds() {
+		t.Fatalf("expected bounds %v but got %v", expected.Bounds(), actual.Bounds())
+	}
+	b := actual.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !image.Pt(x, y).In(damagedRect) && actual.At(x, y) != expected.At(x, y) {
+				t.Fatalf("intact pixel (%d, %d) changed", x, y)
+			}
+		}
+	}
+	if e := testutil.MeanError(actual, img, damagedRect); e > 64 {
+		t.Errorf("mean error of damaged tile is %f", e)
+	}
+
+	data[10] ^= 1
+	if _, err := c.Decompress(data); err == nil {
+		t.Error("expected error for damaged header")
+	}
+}
+
+func TestConcealDamage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 5, 1))
+	img.Set(0, 0, color.RGBA{0, 0, 0, 0xff})
+	img.Set(4, 0, color.RGBA{200, 100, 0, 0xff})
+	concealDamage(img, []image.Rectangle{image.Rect(1, 0, 4, 1)})
+	for x, expected := range []color.RGBA{
+		{0, 0, 0, 0xff},
+		{50, 25, 0, 0xff},
+		{100, 50, 0, 0xff},
+		{150, 75, 0, 0xff},
+		{200, 100, 0, 0xff},
+	} {
+		if actual := img.RGBAAt(x, 0); actual != expected {
+			t.Errorf("pixel %d: expected %v but got %v", x, expected, actual)
+		}
+	}
+
+	concealDamage(img, []image.Rectangle{img.Bounds()})
+	for x := 0; x < 5; x++ {
+		if actual := img.RGBAAt(x, 0); actual != (color.RGBA{0x80, 0x80, 0x80, 0xff}) {
+			t.Errorf("pixel %d: expected gray but got %v", x, actual)
+		}
+	}
+}
+
+// sizeCheckingReader fails a test if a read is larger than
+// the data itself, which means that a buffer was allocated
+// for a tile before the tile was bounded.
+type sizeCheckingReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (s *sizeCheckingReader) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > len(s.data) {
+		s.t.Fatalf("read of %d bytes from %d bytes of data", len(p), len(s.data))
+	}
+	return bytes.NewReader(s.data).ReadAt(p, off)
+}
+
+func mustReadHeader(t *testing.T, r io.ReaderAt) *header {
+	h, err := readHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/metadata"
+	"github.com/unixpickle/imagecompress/sparsecode"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	maxTrainingBlocks = 50000
+	trainingSeed      = 1337
+)
+
+// trainDict runs the train-dict command.
+//
+// The dictionary is saved after every iteration, and an
+// existing output file is treated as a checkpoint to
+// resume training from.
+func trainDict(args []string) error {
+	if len(args) < 6 {
+		return errUsage
+	}
+	var nums [4]int
+	for i := range nums {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid argument: %s", args[i])
+		}
+		nums[i] = n
+	}
+	blockSize, atoms, sparsity, iterations := nums[0], nums[1], nums[2], nums[3]
+	outFile := args[4]
+
+	r := rand.New(rand.NewSource(trainingSeed))
+	blocks, err := trainingBlocks(args[5:], blockSize, r)
+	if err != nil {
+		return err
+	} else if len(blocks) == 0 {
+		return errors.New("no training blocks")
+	}
+
+	trainer := sparsecode.NewTrainer(blocks, atoms, sparsity, r)
+	if _, err := os.Stat(outFile); err == nil {
+		dict, iteration, err := sparsecode.LoadDictionary(outFile)
+		if err != nil {
+			return err
+		} else if dict.Dim() != blockSize*blockSize {
+			return fmt.Errorf("checkpoint has block dimension %d", dict.Dim())
+		} else if len(dict.Atoms) != atoms {
+			return fmt.Errorf("checkpoint has %d atoms", len(dict.Atoms))
+		}
+		fmt.Fprintf(os.Stderr, "resuming from iteration %d\n", iteration)
+		trainer.Dict = dict
+		trainer.Iteration = iteration
+	}
+
+	for trainer.Iteration < iterations {
+		loss := trainer.Step()
+		fmt.Fprintf(os.Stderr, "iteration %d: loss %f\n", trainer.Iteration, loss)
+		if err := sparsecode.SaveDictionary(outFile, trainer.Dict, trainer.Iteration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func trainingBlocks(files []string, blockSize int, r *rand.Rand) ([]linalg.Vector, error) {
+	var blocks []linalg.Vector
+	for _, file := range files {
+		img, err := readImage(file)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, blocker.Blocks(img, blockSize)...)
+	}
+	if len(blocks) > maxTrainingBlocks {
+		perm := r.Perm(len(blocks))
+		sample := make([]linalg.Vector, maxTrainingBlocks)
+		for i := range sample {
+			sample[i] = blocks[perm[i]]
+		}
+		blocks = sample
+	}
+	return blocks, nil
+}
+
+func readImage(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// readImageMetadata is like readImage, but it also reads
+// the metadata of JPEG and PNG files.
+// Malformed metadata is reported and then ignored.
+func readImageMetadata(file string) (image.Image, *metadata.Metadata, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := metadata.Read(data)
+	if err != nil {
+		// The pixels decoded fine, so damaged metadata is no
+		// reason to give up on the image.
+		fmt.Fprintf(os.Stderr, "ignoring metadata of %s: %s\n", file, err)
+		meta = &metadata.Metadata{}
+	}
+	return img, meta, nil
+}
@@ -0,0 +1,106 @@
+package vq
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// MaxCodebookSize is the largest number of vectors that
+// a Codebook may contain.
+const MaxCodebookSize = 1 << 16
+
+// MaxDimension is the largest vector dimension that
+// ReadCodebook will accept.
+const MaxDimension = 1 << 16
+
+var encodingEndian = binary.LittleEndian
+
+// A Codebook is a list of vectors which blocks of an
+// image are approximated by.
+type Codebook struct {
+	Vectors []linalg.Vector
+}
+
+// Nearest returns the index of the codebook vector that
+// is closest to vec.
+func (c *Codebook) Nearest(vec linalg.Vector) int {
+	idx, _ := nearest(c.Vectors, vec)
+	return idx
+}
+
+// WriteTo encodes the codebook.
+func (c *Codebook) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+
+	if err := binary.Write(w, encodingEndian, uint32(len(c.Vectors))); err != nil {
+		return written, err
+	}
+	written += 4
+
+	if err := binary.Write(w, encodingEndian, uint32(len(c.Vectors[0]))); err != nil {
+		return written, err
+	}
+	written += 4
+
+	for _, vec := range c.Vectors {
+		for _, val := range vec {
+			if err := binary.Write(w, encodingEndian, float32(val)); err != nil {
+				return written, err
+			}
+			written += 4
+		}
+	}
+	return written, nil
+}
+
+// ReadCodebook decodes a Codebook that was encoded with
+// WriteTo.
+func ReadCodebook(r io.Reader) (*Codebook, error) {
+	return readCodebook(r, 0)
+}
+
+// readCodebook is like ReadCodebook, but if dim is
+// non-zero, it rejects codebooks of any other dimension
+// before reading their vectors.
+func readCodebook(r io.Reader, dim int) (*Codebook, error) {
+	var count, dimension uint32
+	if err := binary.Read(r, encodingEndian, &count); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, encodingEndian, &dimension); err != nil {
+		return nil, err
+	}
+
+	if count == 0 || dimension == 0 {
+		return nil, errors.New("codebook must not be empty")
+	} else if count > MaxCodebookSize || dimension > MaxDimension {
+		return nil, errors.New("codebook is too large")
+	} else if dim != 0 && int(dimension) != dim {
+		return nil, errors.New("block size mismatch")
+	}
+
+	res := &Codebook{Vectors: make([]linalg.Vector, count)}
+	for i := range res.Vectors {
+		vec := make(linalg.Vector, dimension)
+		for j := range vec {
+			var val float32
+			if err := binary.Read(r, encodingEndian, &val); err != nil {
+				return nil, err
+			}
+			vec[j] = float64(val)
+		}
+		res.Vectors[i] = vec
+	}
+
+	return res, nil
+}
+
+func (c *Codebook) indexBytes() int {
+	if len(c.Vectors) <= 256 {
+		return 1
+	}
+	return 2
+}
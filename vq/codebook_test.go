@@ -0,0 +1,43 @@
+package vq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+)
+
+func TestTrainRounded(t *testing.T) {
+	codebook := NewCompressor(0.5).Train(testutil.Image(40, 30))
+	var buf bytes.Buffer
+	if _, err := codebook.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ReadCodebook(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, codebook) {
+		t.Error("decoded codebook differs from the trained one")
+	}
+}
+
+func TestDecompressDimension(t *testing.T) {
+	// The header claims the largest codebook, but has no
+	// vectors, so only the dimension can be checked.
+	var data bytes.Buffer
+	for _, field := range []uint32{16, 16} {
+		binary.Write(&data, encodingEndian, field)
+	}
+	data.WriteByte(codebookInline)
+	for _, field := range []uint32{MaxCodebookSize, MaxDimension} {
+		binary.Write(&data, encodingEndian, field)
+	}
+	_, err := NewCompressor(0.5).Decompress(data.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "block size mismatch") {
+		t.Errorf("expected block size error but got %v", err)
+	}
+}
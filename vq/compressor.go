@@ -0,0 +1,290 @@
+package vq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/imagecompress/blocker"
+	"github.com/unixpickle/imagecompress/limits"
+	"github.com/unixpickle/imagecompress/parallel"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	DefaultBlockSize  = 4
+	DefaultIterations = 10
+
+	// maxTrainingVectors limits how many blocks are used
+	// to train a codebook, since k-means is expensive.
+	maxTrainingVectors = 20000
+
+	trainingSeed = 1337
+)
+
+const (
+	codebookInline   = 0
+	codebookExternal = 1
+)
+
+// A Compressor uses vector quantization to compress
+// images, replacing each block with the index of the
+// nearest vector in a codebook.
+//
+// Decoding is a table lookup, optionally followed by
+// adding a residual.
+type Compressor struct {
+	// Codebook, if non-nil, is a pretrained codebook to use
+	// instead of training a new codebook for each image.
+	// Pretrained codebooks are not stored in the compressed
+	// data, so the same Codebook is needed to decompress it.
+	Codebook *Codebook
+
+	// Iterations is the number of k-means iterations used
+	// to train codebooks.
+	Iterations int
+
+	// ResidualBasis, if non-nil, is a matrix of orthonormal
+	// columns used to code the difference between each
+	// block and its codebook vector.
+	// It is needed to decompress any residuals.
+	ResidualBasis *linalg.Matrix
+
+	// ResidualTable is used to quantize the coefficients of
+	// the residual in ResidualBasis.
+	ResidualTable *quantize.Table
+
+	// Concurrency is the maximum number of goroutines used
+	// to process blocks.
+	// If it is 0, runtime.GOMAXPROCS(0) goroutines are used.
+	// The compressed data does not depend on Concurrency.
+	Concurrency int
+
+	// DecodeOptions limits the resources used to decode
+	// images, which is important for untrusted data.
+	DecodeOptions limits.DecodeOptions
+
+	codebookSize int
+	blockSize    int
+}
+
+// NewCompressor creates a Compressor with the default
+// block size and a codebook size determined by quality.
+//
+// A quality of 0 uses 2 codebook vectors, and a quality
+// of 1 uses 1024.
+// Larger codebooks take too long to train for every
+// image, but NewCompressorSize allows them.
+func NewCompressor(quality float64) *Compressor {
+	size := int(math.Pow(2, 1+quality*9) + 0.5)
+	return NewCompressorSize(size, DefaultBlockSize)
+}
+
+// NewCompressorSize creates a Compressor that trains
+// codebooks of the given size on blocks of the given size.
+func NewCompressorSize(codebookSize, blockSize int) *Compressor {
+	if codebookSize < 1 {
+		codebookSize = 1
+	} else if codebookSize > MaxCodebookSize {
+		codebookSize = MaxCodebookSize
+	}
+	return &Compressor{
+		Iterations:   DefaultIterations,
+		codebookSize: codebookSize,
+		blockSize:    blockSize,
+	}
+}
+
+// Train creates a codebook for the blocks of some images.
+// The result can be used as a pretrained Codebook.
+//
+// The vectors are rounded to the float32 precision at
+// which WriteTo stores them, so that blocks are assigned
+// to exactly the vectors that a decoder reads back.
+func (c *Compressor) Train(images ...image.Image) *Codebook {
+	var vecs []linalg.Vector
+	for _, img := range images {
+		vecs = append(vecs, blocker.Blocks(img, c.blockSize)...)
+	}
+	r := rand.New(rand.NewSource(trainingSeed))
+	if len(vecs) > maxTrainingVectors {
+		perm := r.Perm(len(vecs))
+		sample := make([]linalg.Vector, maxTrainingVectors)
+		for i := range sample {
+			sample[i] = vecs[perm[i]]
+		}
+		vecs = sample
+	}
+	if len(vecs) == 0 {
+		// Codebooks cannot be empty, even for empty images.
+		return &Codebook{Vectors: []linalg.Vector{make(linalg.Vector, c.blockSize*c.blockSize)}}
+	}
+	centers := KMeans(vecs, c.codebookSize, c.Iterations, r)
+	for _, center := range centers {
+		for i, x := range center {
+			center[i] = float64(float32(x))
+		}
+	}
+	return &Codebook{Vectors: centers}
+}
+
+// Compress compresses an image and returns a binary
+// encoding of the result.
+func (c *Compressor) Compress(i image.Image) []byte {
+	var w bytes.Buffer
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dx()))
+	binary.Write(&w, encodingEndian, uint32(i.Bounds().Dy()))
+
+	codebook := c.Codebook
+	if codebook == nil {
+		codebook = c.Train(i)
+		w.WriteByte(codebookInline)
+		codebook.WriteTo(&w)
+	} else {
+		w.WriteByte(codebookExternal)
+	}
+
+	blocks := blocker.Blocks(i, c.blockSize)
+	indices := make([]int, len(blocks))
+	parallel.For(len(blocks), c.Concurrency, func(i int) {
+		indices[i] = codebook.Nearest(blocks[i])
+	})
+	for i := range blocks {
+		if codebook.indexBytes() == 1 {
+			w.WriteByte(byte(indices[i]))
+		} else {
+			binary.Write(&w, encodingEndian, uint16(indices[i]))
+		}
+	}
+
+	if c.ResidualBasis == nil || c.ResidualTable == nil {
+		w.WriteByte(0)
+		return w.Bytes()
+	}
+	w.WriteByte(1)
+	table := c.ResidualTable.Rounded()
+	quantize.WriteTable(&w, table)
+	bw := quantize.NewBitWriter(&w)
+	residualBasis := columns(c.ResidualBasis)
+	for i, block := range blocks {
+		residual := block.Copy().Add(codebook.Vectors[indices[i]].Copy().Scale(-1))
+		for j, basisVec := range residualBasis {
+			coeff := basisVec.Dot(residual)
+			table.WriteCoeff(bw, table.Quantize(j, coeff))
+		}
+	}
+	bw.Flush()
+
+	return w.Bytes()
+}
+
+// Decompress decodes image data that was encoded
+// by Compress.
+func (c *Compressor) Decompress(b []byte) (image.Image, error) {
+	r := bytes.NewBuffer(b)
+
+	var width, height uint32
+	if err := binary.Read(r, encodingEndian, &width); err != nil {
+		return nil, errors.New("failed to read width field: " + err.Error())
+	}
+	if err := binary.Read(r, encodingEndian, &height); err != nil {
+		return nil, errors.New("failed to read height field: " + err.Error())
+	}
+	// Residual blocks have a coefficient for every pixel.
+	err := c.DecodeOptions.CheckBlocks(uint64(width), uint64(height), c.blockSize,
+		c.blockSize*c.blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	codebook := c.Codebook
+	if kind, err := r.ReadByte(); err != nil {
+		return nil, errors.New("failed to read codebook type")
+	} else if kind == codebookInline {
+		codebook, err = readCodebook(r, c.blockSize*c.blockSize)
+		if err != nil {
+			return nil, errors.New("failed to read codebook: " + err.Error())
+		}
+	} else if kind != codebookExternal {
+		return nil, fmt.Errorf("unknown codebook type: 0x%x", kind)
+	} else if codebook == nil {
+		return nil, errors.New("missing pretrained codebook")
+	}
+	if len(codebook.Vectors[0]) != c.blockSize*c.blockSize {
+		return nil, errors.New("block size mismatch")
+	}
+
+	rect := image.Rect(0, 0, int(width), int(height))
+	blocks := make([]linalg.Vector, blocker.Count(rect, c.blockSize))
+	for i := range blocks {
+		var idx int
+		if codebook.indexBytes() == 1 {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, errors.New("failed to read data: " + err.Error())
+			}
+			idx = int(b)
+		} else {
+			var val uint16
+			if err := binary.Read(r, encodingEndian, &val); err != nil {
+				return nil, errors.New("failed to read data: " + err.Error())
+			}
+			idx = int(val)
+		}
+		if idx >= len(codebook.Vectors) {
+			return nil, errors.New("codebook index out of range")
+		}
+		blocks[i] = codebook.Vectors[idx]
+	}
+
+	if hasResidual, err := r.ReadByte(); err != nil {
+		return nil, errors.New("failed to read residual flag")
+	} else if hasResidual == 1 {
+		if err := c.decodeResiduals(r, blocks); err != nil {
+			return nil, err
+		}
+	}
+
+	return blocker.Image(rect.Dx(), rect.Dy(), blocks, c.blockSize), nil
+}
+
+func (c *Compressor) decodeResiduals(r *bytes.Buffer, blocks []linalg.Vector) error {
+	if c.ResidualBasis == nil {
+		return errors.New("missing residual basis")
+	} else if c.ResidualBasis.Rows != c.blockSize*c.blockSize {
+		return errors.New("residual basis size mismatch")
+	}
+	table, err := quantize.ReadTable(r)
+	if err != nil {
+		return errors.New("failed to read residual table: " + err.Error())
+	}
+	br := quantize.NewBitReader(r)
+	residualBasis := columns(c.ResidualBasis)
+	for i, block := range blocks {
+		block = block.Copy()
+		for j, basisVec := range residualBasis {
+			q, err := table.ReadCoeff(br)
+			if err != nil {
+				return errors.New("failed to read residual: " + err.Error())
+			}
+			if q != 0 {
+				block.Add(basisVec.Copy().Scale(table.Dequantize(j, q)))
+			}
+		}
+		blocks[i] = block
+	}
+	return nil
+}
+
+func columns(m *linalg.Matrix) []linalg.Vector {
+	res := make([]linalg.Vector, m.Cols)
+	for i := range res {
+		res[i] = m.Col(i)
+	}
+	return res
+}
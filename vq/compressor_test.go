@@ -0,0 +1,27 @@
+package vq
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func TestCompressConcurrency(t *testing.T) {
+	img := testutil.Image(70, 50)
+	for _, residual := range []bool{false, true} {
+		t.Run(fmt.Sprintf("residual=%v", residual), func(t *testing.T) {
+			testutil.CheckConcurrency(t, img, func(concurrency int) testutil.Codec {
+				c := NewCompressor(0.5)
+				if residual {
+					c.ResidualBasis = smallbasis.BasisMatrix(DefaultBlockSize * DefaultBlockSize)
+					c.ResidualTable = quantize.Lookup(quantize.CoarseTableID)
+				}
+				c.Concurrency = concurrency
+				return c
+			})
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package vq
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/unixpickle/imagecompress/internal/testutil"
+	"github.com/unixpickle/imagecompress/quantize"
+	"github.com/unixpickle/imagecompress/smallbasis"
+)
+
+func fuzzCompressors() []*Compressor {
+	plain := NewCompressorSize(16, 4)
+	residual := NewCompressorSize(16, 4)
+	residual.ResidualBasis = smallbasis.BasisMatrix(16)
+	residual.ResidualTable = quantize.Lookup(quantize.CoarseTableID)
+	res := []*Compressor{plain, residual}
+	for _, c := range res {
+		c.Iterations = 2
+		c.DecodeOptions = testutil.FuzzOptions
+	}
+	return res
+}
+
+func FuzzDecompress(f *testing.F) {
+	var codecs []testutil.Codec
+	for _, c := range fuzzCompressors() {
+		codecs = append(codecs, c)
+	}
+	testutil.FuzzCodecs(f, codecs)
+}
+
+func FuzzReadCodebook(f *testing.F) {
+	var buf bytes.Buffer
+	if _, err := fuzzCompressors()[0].Train(testutil.Image(11, 9)).WriteTo(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := ReadCodebook(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if len(c.Vectors) > MaxCodebookSize || len(c.Vectors[0]) > MaxDimension {
+			t.Fatalf("codebook is too large: %d vectors of %d", len(c.Vectors),
+				len(c.Vectors[0]))
+		}
+		if len(data) < 8+4*len(c.Vectors)*len(c.Vectors[0]) {
+			t.Fatal("codebook is larger than its data")
+		}
+	})
+}
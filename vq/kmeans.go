@@ -0,0 +1,122 @@
+package vq
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// KMeans clusters vectors into (at most) k clusters and
+// returns the center of each cluster.
+//
+// The centers are initialized with k-means++ and then
+// refined with iters rounds of Lloyd's algorithm.
+// The result is determined entirely by the arguments and
+// the seed of the random source.
+func KMeans(vecs []linalg.Vector, k, iters int, r *rand.Rand) []linalg.Vector {
+	if k > len(vecs) {
+		k = len(vecs)
+	}
+	if k == 0 {
+		return nil
+	}
+	centers := kMeansPlusPlus(vecs, k, r)
+
+	assignments := make([]int, len(vecs))
+	for iter := 0; iter < iters; iter++ {
+		changed := false
+		for i, vec := range vecs {
+			idx, _ := nearest(centers, vec)
+			if idx != assignments[i] || iter == 0 {
+				changed = true
+			}
+			assignments[i] = idx
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([]linalg.Vector, len(centers))
+		counts := make([]int, len(centers))
+		for i, vec := range vecs {
+			idx := assignments[i]
+			if sums[idx] == nil {
+				sums[idx] = make(linalg.Vector, len(vec))
+			}
+			sums[idx].Add(vec)
+			counts[idx]++
+		}
+		for i, sum := range sums {
+			// Empty clusters keep their old centers.
+			if counts[i] > 0 {
+				centers[i] = sum.Scale(1 / float64(counts[i]))
+			}
+		}
+	}
+
+	return centers
+}
+
+// kMeansPlusPlus chooses initial centers by sampling
+// each new center with probability proportional to its
+// squared distance from the existing centers.
+func kMeansPlusPlus(vecs []linalg.Vector, k int, r *rand.Rand) []linalg.Vector {
+	centers := make([]linalg.Vector, 0, k)
+	centers = append(centers, vecs[r.Intn(len(vecs))].Copy())
+
+	dists := make([]float64, len(vecs))
+	for i, vec := range vecs {
+		dists[i] = squaredDist(vec, centers[0])
+	}
+
+	for len(centers) < k {
+		var total float64
+		for _, d := range dists {
+			total += d
+		}
+		if total == 0 {
+			// Every vector is already a center.
+			break
+		}
+		target := r.Float64() * total
+		chosen := len(vecs) - 1
+		for i, d := range dists {
+			target -= d
+			if target < 0 {
+				chosen = i
+				break
+			}
+		}
+		center := vecs[chosen].Copy()
+		centers = append(centers, center)
+		for i, vec := range vecs {
+			dists[i] = math.Min(dists[i], squaredDist(vec, center))
+		}
+	}
+
+	return centers
+}
+
+// nearest finds the index of the closest center to a
+// vector, along with the squared distance to it.
+func nearest(centers []linalg.Vector, vec linalg.Vector) (int, float64) {
+	bestIdx := 0
+	bestDist := math.Inf(1)
+	for i, center := range centers {
+		if d := squaredDist(center, vec); d < bestDist {
+			bestDist = d
+			bestIdx = i
+		}
+	}
+	return bestIdx, bestDist
+}
+
+func squaredDist(v1, v2 linalg.Vector) float64 {
+	var res float64
+	for i, x := range v1 {
+		diff := x - v2[i]
+		res += diff * diff
+	}
+	return res
+}